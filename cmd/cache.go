@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"github.com/mishamyrt/hapm/internal/hapm"
+	"github.com/spf13/cobra"
+)
+
+type cacheCommand struct{}
+
+func (cacheCommand) New(app *hapm.App) *cobra.Command {
+	cacheCmd := cobra.Command{
+		Use:   "cache",
+		Short: "Manage the shared download cache",
+		Example: `hapm cache list
+hapm cache prune --older-than 720h
+hapm cache verify`,
+	}
+
+	listCmd := cobra.Command{
+		Use:   "list",
+		Short: "List cached package artifacts",
+		Args:  cobra.MaximumNArgs(0),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return app.CacheList()
+		},
+	}
+
+	opts := hapm.CachePruneOptions{}
+	pruneCmd := cobra.Command{
+		Use:   "prune",
+		Short: "Remove unused artifacts from the cache",
+		Args:  cobra.MaximumNArgs(0),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return app.CachePrune(opts)
+		},
+	}
+	pruneCmd.Flags().BoolVarP(&opts.All, "all", "a", false, "Remove every cached artifact")
+	pruneCmd.Flags().DurationVar(
+		&opts.OlderThan,
+		"older-than",
+		0,
+		"Remove artifacts that were not used for the given duration, like 720h",
+	)
+
+	verifyCmd := cobra.Command{
+		Use:   "verify",
+		Short: "Check cached artifacts against their checksums",
+		Args:  cobra.MaximumNArgs(0),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return app.CacheVerify()
+		},
+	}
+
+	cacheCmd.AddCommand(&listCmd, &pruneCmd, &verifyCmd)
+	return &cacheCmd
+}
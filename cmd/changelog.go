@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/mishamyrt/hapm/internal/hapm"
+	"github.com/spf13/cobra"
+)
+
+type changelogCommand struct{}
+
+func (changelogCommand) New(app *hapm.App) *cobra.Command {
+	opts := hapm.ChangelogOptions{}
+
+	changelogCmd := cobra.Command{
+		Use:     "changelog <package...>",
+		Short:   "Show release notes between installed and available versions",
+		Example: "hapm changelog mishamyrt/dohome_rgb\nhapm changelog --format md mishamyrt/dohome_rgb --to v1.4.0",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.Entries = args
+			return app.Changelog(opts)
+		},
+	}
+
+	changelogCmd.Flags().StringVar(&opts.To, "to", "", "Target version instead of the available update")
+	changelogCmd.Flags().StringVar(&opts.Format, "format", hapm.ChangelogText, "Output format: text or md")
+	changelogCmd.Flags().BoolVarP(
+		&opts.AllowUnstable,
+		"allow-unstable",
+		"u",
+		false,
+		"Removes the restriction to stable versions when searching for updates",
+	)
+
+	return &changelogCmd
+}
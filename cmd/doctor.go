@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/mishamyrt/hapm/internal/hapm"
+	"github.com/spf13/cobra"
+)
+
+type doctorCommand struct{}
+
+func (doctorCommand) New(app *hapm.App) *cobra.Command {
+	opts := hapm.DoctorOptions{}
+
+	doctorCmd := cobra.Command{
+		Use:     "doctor",
+		Short:   "Verify stored packages against the lockfile",
+		Example: "hapm doctor\nhapm doctor --fix",
+		Args:    cobra.MaximumNArgs(0),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return app.Doctor(opts)
+		},
+	}
+
+	doctorCmd.Flags().BoolVar(
+		&opts.Fix,
+		"fix",
+		false,
+		"Download broken packages again and remove orphan artifacts",
+	)
+
+	return &doctorCmd
+}
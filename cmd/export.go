@@ -5,18 +5,70 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const exportExample = `hapm export ./export
+hapm export --format oci-layer --prefix config ./layer.tar.gz
+hapm export --resources storage ./export`
+
 type exportCommand struct{}
 
 func (exportCommand) New(app *hapm.App) *cobra.Command {
+	incremental := false
+	format := "dir"
+	prefix := ""
+	resources := ""
+	compress := false
+
 	exportCmd := cobra.Command{
 		Use:     "export <path>",
 		Short:   "Export packages to Home Assistant directory structure",
-		Example: "hapm export ./export",
+		Example: exportExample,
 		Args:    cobra.ArbitraryArgs,
 		RunE: func(_ *cobra.Command, args []string) error {
-			return app.Export(args)
+			return app.Export(hapm.ExportOptions{
+				Entries:     args,
+				Incremental: incremental,
+				Format:      format,
+				Prefix:      prefix,
+				Resources:   resources,
+				Compress:    compress,
+			})
 		},
 	}
 
+	exportCmd.Flags().BoolVarP(
+		&incremental,
+		"incremental",
+		"i",
+		false,
+		"Update only files managed by hapm and keep other files of the directory",
+	)
+
+	exportCmd.Flags().StringVarP(
+		&format,
+		"format",
+		"f",
+		format,
+		"Export format: dir, tar, tgz or oci-layer",
+	)
+	exportCmd.Flags().StringVar(
+		&prefix,
+		"prefix",
+		"",
+		"Directory inside the archive to place exported files to",
+	)
+	exportCmd.Flags().StringVar(
+		&resources,
+		"resources",
+		"",
+		"Write Lovelace resources of plugins: yaml or storage",
+	)
+	exportCmd.Flags().BoolVarP(
+		&compress,
+		"compress",
+		"z",
+		false,
+		"Write gzip-compressed copies of plugin scripts and assets",
+	)
+
 	return &exportCmd
 }
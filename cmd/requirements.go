@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/mishamyrt/hapm/internal/hapm"
+	"github.com/spf13/cobra"
+)
+
+type requirementsCommand struct{}
+
+func (requirementsCommand) New(app *hapm.App) *cobra.Command {
+	output := ""
+
+	requirementsCmd := cobra.Command{
+		Use:     "requirements",
+		Short:   "Collect Python requirements of installed integrations",
+		Example: "hapm requirements\nhapm requirements --output requirements.txt",
+		Args:    cobra.MaximumNArgs(0),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return app.Requirements(hapm.RequirementsOptions{Output: output})
+		},
+	}
+
+	requirementsCmd.Flags().StringVarP(
+		&output,
+		"output",
+		"o",
+		"",
+		"Write requirements to the file instead of standard output",
+	)
+
+	return &requirementsCmd
+}
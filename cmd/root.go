@@ -17,9 +17,15 @@ var commands = [...]command{
 	syncCommand{},
 	installCommand{},
 	updatesCommand{},
+	upgradeCommand{},
+	changelogCommand{},
 	versionsCommand{},
 	listCommand{},
 	exportCommand{},
+	requirementsCommand{},
+	vendorCommand{},
+	doctorCommand{},
+	cacheCommand{},
 }
 
 func newRootCmd(stdout io.Writer, stderr io.Writer) *cobra.Command {
@@ -53,6 +59,55 @@ func newRootCmd(stdout io.Writer, stderr io.Writer) *cobra.Command {
 		globals.Dry,
 		"Only print information. Do not make any changes to the files",
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&globals.HomeAssistant,
+		"ha-version",
+		globals.HomeAssistant,
+		"Home Assistant version packages must be compatible with. Overrides the manifest value",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&globals.NoCache,
+		"no-cache",
+		globals.NoCache,
+		"Do not use the shared download cache",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&globals.Offline,
+		"offline",
+		globals.Offline,
+		"Do not access the network. Use only the lockfile, storage and cache",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&globals.Mirror,
+		"mirror",
+		globals.Mirror,
+		"Serve packages from the vendored directory instead of the network",
+	)
+	rootCmd.PersistentFlags().Int64Var(
+		&globals.MaxDownloadSize,
+		"max-download-size",
+		globals.MaxDownloadSize,
+		"Maximum size of a single download in MiB. 0 means no limit",
+	)
+	rootCmd.PersistentFlags().IntVarP(
+		&globals.Concurrency,
+		"concurrency",
+		"j",
+		globals.Concurrency,
+		"Number of packages resolved or downloaded at the same time. 0 means the default of 20",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&globals.Wait,
+		"wait",
+		globals.Wait,
+		"Wait for other hapm processes to release the storage instead of failing",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&globals.WaitTimeout,
+		"wait-timeout",
+		globals.WaitTimeout,
+		"Maximum time to wait for the storage lock. 0 means no limit",
+	)
 
 	for _, command := range commands {
 		rootCmd.AddCommand(command.New(app))
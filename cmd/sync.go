@@ -9,14 +9,15 @@ type syncCommand struct{}
 
 func (syncCommand) New(app *hapm.App) *cobra.Command {
 	allowUnstable := false
+	keepGoing := false
 
 	syncCmd := cobra.Command{
 		Use:     "sync",
 		Short:   "Synchronize storage with manifest",
-		Example: "hapm sync",
+		Example: "hapm sync\nhapm sync --keep-going",
 		Args:    cobra.MaximumNArgs(0),
 		RunE: func(_ *cobra.Command, _ []string) error {
-			return app.Sync(hapm.SyncOptions{AllowUnstable: allowUnstable})
+			return app.Sync(hapm.SyncOptions{AllowUnstable: allowUnstable, KeepGoing: keepGoing})
 		},
 	}
 
@@ -28,5 +29,13 @@ func (syncCommand) New(app *hapm.App) *cobra.Command {
 		"Removes the restriction to stable versions when searching for updates",
 	)
 
+	syncCmd.Flags().BoolVarP(
+		&keepGoing,
+		"keep-going",
+		"k",
+		false,
+		"Apply every change that can succeed and report failed packages at the end",
+	)
+
 	return &syncCmd
 }
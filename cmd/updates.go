@@ -8,25 +8,27 @@ import (
 type updatesCommand struct{}
 
 func (updatesCommand) New(app *hapm.App) *cobra.Command {
-	allowUnstable := false
+	opts := hapm.UpdatesOptions{}
 
 	updatesCmd := cobra.Command{
 		Use:     "updates",
 		Short:   "Show available package updates",
-		Example: "hapm updates",
+		Example: "hapm updates\nhapm updates --changelog --format md",
 		Args:    cobra.MaximumNArgs(0),
 		RunE: func(_ *cobra.Command, _ []string) error {
-			return app.PrintUpdates(hapm.UpdatesOptions{AllowUnstable: allowUnstable})
+			return app.PrintUpdates(opts)
 		},
 	}
 
 	updatesCmd.Flags().BoolVarP(
-		&allowUnstable,
+		&opts.AllowUnstable,
 		"allow-unstable",
 		"u",
 		false,
 		"Removes the restriction to stable versions when searching for updates",
 	)
+	updatesCmd.Flags().BoolVarP(&opts.Changelog, "changelog", "c", false, "Print release notes of every update")
+	updatesCmd.Flags().StringVar(&opts.Format, "format", hapm.ChangelogText, "Release notes format: text or md")
 
 	return &updatesCmd
 }
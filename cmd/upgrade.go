@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/mishamyrt/hapm/internal/hapkg"
+	"github.com/mishamyrt/hapm/internal/hapm"
+	"github.com/spf13/cobra"
+)
+
+type upgradeCommand struct{}
+
+func (upgradeCommand) New(app *hapm.App) *cobra.Command {
+	allowUnstable := false
+	patchOnly := false
+	minorOnly := false
+
+	upgradeCmd := cobra.Command{
+		Use:     "upgrade [package...]",
+		Short:   "Bump manifest versions to available updates and synchronize",
+		Example: "hapm upgrade\nhapm upgrade mishamyrt/dohome_rgb\nhapm upgrade --patch",
+		RunE: func(_ *cobra.Command, args []string) error {
+			level := hapkg.UpdateMajor
+			if minorOnly {
+				level = hapkg.UpdateMinor
+			}
+			if patchOnly {
+				level = hapkg.UpdatePatch
+			}
+			return app.Upgrade(hapm.UpgradeOptions{
+				Entries:       args,
+				Level:         level,
+				AllowUnstable: allowUnstable,
+			})
+		},
+	}
+
+	upgradeCmd.Flags().BoolVarP(
+		&allowUnstable,
+		"allow-unstable",
+		"u",
+		false,
+		"Removes the restriction to stable versions when searching for updates",
+	)
+	upgradeCmd.Flags().BoolVar(&patchOnly, "patch", false, "Upgrade only to patch releases of the current minor version")
+	upgradeCmd.Flags().BoolVar(&minorOnly, "minor", false, "Upgrade only to releases of the current major version")
+	upgradeCmd.MarkFlagsMutuallyExclusive("patch", "minor")
+
+	return &upgradeCmd
+}
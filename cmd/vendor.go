@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/mishamyrt/hapm/internal/hapm"
+	"github.com/spf13/cobra"
+)
+
+type vendorCommand struct{}
+
+func (vendorCommand) New(app *hapm.App) *cobra.Command {
+	return &cobra.Command{
+		Use:     "vendor <dir>",
+		Short:   "Write installed package artifacts to a mirror directory",
+		Example: "hapm vendor ./vendor\nhapm --mirror ./vendor sync",
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return app.Vendor(args)
+		},
+	}
+}
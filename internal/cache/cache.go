@@ -0,0 +1,483 @@
+// Package cache implements a content-addressed cache of package artifacts
+// shared between storages of the same host.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+const (
+	// PathVar overrides location of the cache.
+	PathVar = "HAPM_CACHE_DIR"
+
+	blobsDir     = "blobs"
+	refsDir      = "refs"
+	tagsDir      = "tags"
+	requiresDir  = "requirements"
+	digestPrefix = hapkg.DigestPrefix
+	refExtension = ".json"
+)
+
+// Entry is a cached artifact of a package version.
+type Entry struct {
+	FullName string    `json:"full_name"`
+	Version  string    `json:"version"`
+	Kind     string    `json:"kind"`
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	StoredAt time.Time `json:"stored_at"`
+	// UsedAt is the last time the artifact was restored from the cache.
+	UsedAt time.Time `json:"-"`
+}
+
+// Problem describes a cache entry that failed verification.
+type Problem struct {
+	Entry   Entry
+	Message string
+}
+
+// PruneOptions describe which entries are removed from the cache.
+type PruneOptions struct {
+	// All removes every entry of the cache.
+	All bool
+	// OlderThan removes entries that were not used for the given duration.
+	OlderThan time.Duration
+}
+
+// PruneResult describes entries removed from the cache.
+type PruneResult struct {
+	Entries []Entry
+	Blobs   int
+	Size    int64
+}
+
+// Cache stores artifacts in blobs/sha256/<digest> and maps repository,
+// ref and kind to them with refs/<owner>/<repo>/<kind>/<ref>.json files.
+// Only refs that parse as versions are cached, because branches and other
+// refs move and their content can't be reused.
+// Restored artifacts are hardlinks to the blobs when possible, so an
+// artifact changed in place changes the blob too. hapm always replaces
+// artifacts with new files, and a changed blob fails verification on the
+// next restore.
+// Tags and Home Assistant requirements of repositories are kept in tags
+// and requirements directories for offline use.
+type Cache struct {
+	root string
+	now  func() time.Time
+}
+
+// DefaultPath returns location of the cache: $HAPM_CACHE_DIR if set,
+// otherwise hapm inside the user cache directory ($XDG_CACHE_HOME on Linux).
+func DefaultPath() (string, error) {
+	if path := os.Getenv(PathVar); path != "" {
+		return path, nil
+	}
+	root, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "hapm"), nil
+}
+
+func New(root string) *Cache {
+	return &Cache{root: root, now: time.Now}
+}
+
+func (c *Cache) Path() string {
+	return c.root
+}
+
+// Has reports whether the artifact is cached.
+func (c *Cache) Has(key hapkg.ArtifactKey) bool {
+	if !immutable(key.Version) {
+		return false
+	}
+	entry, err := c.readRef(c.refPath(key))
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(c.blobPath(entry.Digest))
+	return err == nil
+}
+
+// Restore hardlinks or copies the cached artifact to target. Entries
+// whose content does not match the digest are dropped.
+func (c *Cache) Restore(key hapkg.ArtifactKey, target string) (bool, error) {
+	if !immutable(key.Version) {
+		return false, nil
+	}
+	entry, err := c.readRef(c.refPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	blob := c.blobPath(entry.Digest)
+	digest, _, err := hapkg.FileDigest(blob)
+	if err != nil || digest != entry.Digest {
+		_ = os.Remove(c.refPath(key))
+		if err == nil {
+			_ = os.Remove(blob)
+		}
+		return false, nil
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if err := linkOrCopy(blob, target); err != nil {
+		return false, err
+	}
+	now := c.now()
+	_ = os.Chtimes(c.refPath(key), now, now)
+	return true, nil
+}
+
+// Store adds the artifact to the cache. Equal artifacts share a blob.
+// Artifacts of refs that are not versions are skipped.
+func (c *Cache) Store(key hapkg.ArtifactKey, source string, digest string) error {
+	if !immutable(key.Version) {
+		return nil
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if digest == "" {
+		if digest, size, err = hapkg.FileDigest(source); err != nil {
+			return err
+		}
+	}
+	blob := c.blobPath(digest)
+	if _, err := os.Stat(blob); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+			return err
+		}
+		temp := fmt.Sprintf("%s.%d.tmp", blob, os.Getpid())
+		if err := linkOrCopy(source, temp); err != nil {
+			return err
+		}
+		if err := os.Rename(temp, blob); err != nil {
+			_ = os.Remove(temp)
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	entry := Entry{
+		FullName: key.FullName,
+		Version:  key.Version,
+		Kind:     key.Kind,
+		Digest:   digest,
+		Size:     size,
+		StoredAt: c.now().UTC(),
+	}
+	return c.writeRef(c.refPath(key), entry)
+}
+
+type versionsFile struct {
+	Versions  []string  `json:"versions"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// StoreVersions saves tags of the repository for offline use.
+func (c *Cache) StoreVersions(fullName string, versions []string) error {
+	content, err := json.MarshalIndent(versionsFile{Versions: versions, FetchedAt: c.now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := c.versionsPath(fullName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(temp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// Versions returns tags of the repository saved by StoreVersions.
+func (c *Cache) Versions(fullName string) ([]string, error) {
+	content, err := os.ReadFile(c.versionsPath(fullName))
+	if err != nil {
+		return nil, err
+	}
+	var file versionsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing cached versions of %s: %w", fullName, err)
+	}
+	return file.Versions, nil
+}
+
+type requirementFile struct {
+	HomeAssistant string    `json:"homeassistant"`
+	FetchedAt     time.Time `json:"fetched_at"`
+}
+
+// StoreRequirement saves the minimal Home Assistant version declared by
+// hacs.json of the package version for offline use. Requirements of refs
+// that are not versions are skipped.
+func (c *Cache) StoreRequirement(fullName string, version string, required string) error {
+	if !immutable(version) {
+		return nil
+	}
+	content, err := json.MarshalIndent(requirementFile{HomeAssistant: required, FetchedAt: c.now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := c.requirementPath(fullName, version)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(temp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// Requirement returns the requirement saved by StoreRequirement and
+// reports whether it was found.
+func (c *Cache) Requirement(fullName string, version string) (string, bool) {
+	if !immutable(version) {
+		return "", false
+	}
+	content, err := os.ReadFile(c.requirementPath(fullName, version))
+	if err != nil {
+		return "", false
+	}
+	var file requirementFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return "", false
+	}
+	return file.HomeAssistant, true
+}
+
+// List returns cached entries ordered by repository, kind and version.
+func (c *Cache) List() ([]Entry, error) {
+	entries := make([]Entry, 0)
+	root := filepath.Join(c.root, refsDir)
+	err := filepath.WalkDir(root, func(path string, item fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == root {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if item.IsDir() || !strings.HasSuffix(path, refExtension) {
+			return nil
+		}
+		entry, err := c.readRef(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i int, j int) bool {
+		if entries[i].FullName != entries[j].FullName {
+			return entries[i].FullName < entries[j].FullName
+		}
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		return entries[i].Version < entries[j].Version
+	})
+	return entries, nil
+}
+
+// Verify checks that every cached artifact matches its digest.
+func (c *Cache) Verify() ([]Problem, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]Problem, 0)
+	for _, entry := range entries {
+		digest, size, err := hapkg.FileDigest(c.blobPath(entry.Digest))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, Problem{Entry: entry, Message: "artifact is missing"})
+		case err != nil:
+			problems = append(problems, Problem{Entry: entry, Message: err.Error()})
+		case digest != entry.Digest:
+			problems = append(problems, Problem{Entry: entry, Message: "checksum mismatch: " + digest})
+		case size != entry.Size:
+			problems = append(problems, Problem{Entry: entry, Message: fmt.Sprintf("size mismatch: %d", size)})
+		}
+	}
+	return problems, nil
+}
+
+// Prune removes entries selected by options together with entries whose
+// artifacts are missing, and then blobs that are no longer referenced.
+func (c *Cache) Prune(opts PruneOptions) (*PruneResult, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	result := &PruneResult{Entries: make([]Entry, 0)}
+	if opts.All {
+		for _, dir := range []string{tagsDir, requiresDir} {
+			if err := os.RemoveAll(filepath.Join(c.root, dir)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	referenced := map[string]bool{}
+	for _, entry := range entries {
+		_, err := os.Stat(c.blobPath(entry.Digest))
+		expired := opts.OlderThan > 0 && c.now().Sub(entry.UsedAt) > opts.OlderThan
+		if opts.All || expired || errors.Is(err, os.ErrNotExist) {
+			key := hapkg.ArtifactKey{FullName: entry.FullName, Version: entry.Version, Kind: entry.Kind}
+			if err := os.Remove(c.refPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			result.Entries = append(result.Entries, entry)
+			continue
+		}
+		referenced[entry.Digest] = true
+	}
+
+	blobs := filepath.Join(c.root, blobsDir, "sha256")
+	items, err := os.ReadDir(blobs)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if referenced[digestPrefix+item.Name()] {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(filepath.Join(blobs, item.Name())); err != nil {
+			return nil, err
+		}
+		result.Blobs++
+		result.Size += info.Size()
+	}
+	removeEmptyDirs(filepath.Join(c.root, refsDir))
+	return result, nil
+}
+
+// immutable reports whether the ref is a version tag, which is expected
+// to always point to the same content.
+func immutable(ref string) bool {
+	_, err := hapkg.NewVersion(ref)
+	return err == nil
+}
+
+func (c *Cache) refPath(key hapkg.ArtifactKey) string {
+	return filepath.Join(
+		c.root,
+		refsDir,
+		filepath.FromSlash(key.FullName),
+		key.Kind,
+		url.PathEscape(key.Version)+refExtension,
+	)
+}
+
+func (c *Cache) versionsPath(fullName string) string {
+	return filepath.Join(c.root, tagsDir, filepath.FromSlash(fullName)+refExtension)
+}
+
+func (c *Cache) requirementPath(fullName string, version string) string {
+	return filepath.Join(c.root, requiresDir, filepath.FromSlash(fullName), url.PathEscape(version)+refExtension)
+}
+
+func (c *Cache) blobPath(digest string) string {
+	return filepath.Join(c.root, blobsDir, "sha256", strings.TrimPrefix(digest, digestPrefix))
+}
+
+func (c *Cache) readRef(path string) (Entry, error) {
+	var entry Entry
+	info, err := os.Stat(path)
+	if err != nil {
+		return entry, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	entry.UsedAt = info.ModTime()
+	return entry, nil
+}
+
+func (c *Cache) writeRef(path string, entry Entry) error {
+	content, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(temp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// linkOrCopy hardlinks source to target, falling back to copying when
+// they are located on different devices.
+func linkOrCopy(source string, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
+
+// removeEmptyDirs removes empty directories inside root.
+func removeEmptyDirs(root string) {
+	dirs := make([]string, 0)
+	_ = filepath.WalkDir(root, func(path string, item fs.DirEntry, err error) error {
+		if err == nil && item.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+}
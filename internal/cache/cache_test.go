@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+func TestCacheStoreRestore(t *testing.T) {
+	tmp := t.TempDir()
+	cache := New(filepath.Join(tmp, "cache"))
+	source := filepath.Join(tmp, "foo-bar@v1.0.0.tar.gz")
+	if err := os.WriteFile(source, []byte("artifact"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key := hapkg.ArtifactKey{FullName: "foo/bar", Version: "v1.0.0", Kind: "integrations"}
+	other := hapkg.ArtifactKey{FullName: "foo/bar", Version: "v1.0.1", Kind: "integrations"}
+
+	found, err := cache.Restore(key, filepath.Join(tmp, "missing"))
+	if err != nil || found {
+		t.Fatalf("unexpected restore of missing entry: %v, %v", found, err)
+	}
+	if err := cache.Store(key, source, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Store(other, source, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(tmp, "storage", "foo-bar@v1.0.0.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	found, err = cache.Restore(key, target)
+	if err != nil || !found {
+		t.Fatalf("expected cached artifact: %v, %v", found, err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "artifact" {
+		t.Fatalf("unexpected restored artifact: %q, %v", content, err)
+	}
+
+	entries, err := cache.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Version != "v1.0.0" || entries[0].Digest != entries[1].Digest || entries[0].Size != 8 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	blobs, err := os.ReadDir(filepath.Join(cache.Path(), "blobs", "sha256"))
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("expected equal artifacts to share a blob: %v, %v", blobs, err)
+	}
+
+	branch := hapkg.ArtifactKey{FullName: "foo/bar", Version: "main", Kind: "integrations"}
+	if err := cache.Store(branch, source, ""); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Has(branch) {
+		t.Fatal("expected artifact of a branch not to be cached")
+	}
+	found, err = cache.Restore(branch, filepath.Join(tmp, "branch"))
+	if err != nil || found {
+		t.Fatalf("unexpected restore of a branch: %v, %v", found, err)
+	}
+}
+
+func TestCacheVerifyAndRestoreCorrupted(t *testing.T) {
+	tmp := t.TempDir()
+	cache := New(filepath.Join(tmp, "cache"))
+	source := filepath.Join(tmp, "artifact")
+	if err := os.WriteFile(source, []byte("artifact"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key := hapkg.ArtifactKey{FullName: "foo/bar", Version: "v1.0.0", Kind: "plugins"}
+	if err := cache.Store(key, source, ""); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := cache.Verify()
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("unexpected problems: %+v, %v", problems, err)
+	}
+
+	entries, err := cache.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(source); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.blobPath(entries[0].Digest), []byte("damaged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	problems, err = cache.Verify()
+	if err != nil || len(problems) != 1 {
+		t.Fatalf("expected checksum problem: %+v, %v", problems, err)
+	}
+	found, err := cache.Restore(key, filepath.Join(tmp, "target"))
+	if err != nil || found {
+		t.Fatalf("expected corrupted entry to be skipped: %v, %v", found, err)
+	}
+	if entries, err := cache.List(); err != nil || len(entries) != 0 {
+		t.Fatalf("expected corrupted entry to be dropped: %+v, %v", entries, err)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	tmp := t.TempDir()
+	cache := New(filepath.Join(tmp, "cache"))
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	for _, version := range []string{"v1.0.0", "v2.0.0"} {
+		source := filepath.Join(tmp, version)
+		if err := os.WriteFile(source, []byte(version), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		key := hapkg.ArtifactKey{FullName: "foo/bar", Version: version, Kind: "integrations"}
+		if err := cache.Store(key, source, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := now.Add(-60 * 24 * time.Hour)
+	oldRef := cache.refPath(hapkg.ArtifactKey{FullName: "foo/bar", Version: "v1.0.0", Kind: "integrations"})
+	if err := os.Chtimes(oldRef, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fresh := cache.refPath(hapkg.ArtifactKey{FullName: "foo/bar", Version: "v2.0.0", Kind: "integrations"})
+	if err := os.Chtimes(fresh, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cache.Prune(PruneOptions{OlderThan: 30 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Version != "v1.0.0" || result.Blobs != 1 {
+		t.Fatalf("unexpected prune result: %+v", result)
+	}
+
+	result, err = cache.Prune(PruneOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Blobs != 1 {
+		t.Fatalf("unexpected prune result: %+v", result)
+	}
+	if entries, err := cache.List(); err != nil || len(entries) != 0 {
+		t.Fatalf("expected empty cache: %+v, %v", entries, err)
+	}
+}
+
+func TestOfflineClient(t *testing.T) {
+	tmp := t.TempDir()
+	cache := New(filepath.Join(tmp, "cache"))
+	if err := cache.StoreVersions("foo/bar", []string{"v1.0.0", "v1.1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	client := NewOfflineClient(cache)
+	versions, err := client.GetVersions("foo/bar")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("unexpected cached versions: %+v, %v", versions, err)
+	}
+	if _, err := client.GetVersions("foo/other"); !errors.Is(err, hapkg.ErrOffline) {
+		t.Fatalf("expected offline error, got %v", err)
+	}
+	if err := cache.StoreRequirement("foo/bar", "v1.0.0", "2024.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if required, ok := client.Requirement("foo/bar", "v1.0.0"); !ok || required != "2024.1.0" {
+		t.Fatalf("unexpected cached requirement: %q, %v", required, ok)
+	}
+	if _, ok := client.Requirement("foo/bar", "v1.1.0"); ok {
+		t.Fatal("unexpected requirement of version that was never checked")
+	}
+	if _, err := client.GetTarball("foo/bar", "v1.0.0"); !errors.Is(err, hapkg.ErrOffline) {
+		t.Fatalf("expected offline error, got %v", err)
+	}
+	if _, err := NewOfflineClient(nil).GetVersions("foo/bar"); !errors.Is(err, hapkg.ErrOffline) {
+		t.Fatalf("expected offline error without cache, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// Client is a git client that reuses artifacts of the shared cache and
+// keeps repository tags and Home Assistant requirements for offline use.
+type Client struct {
+	hapkg.GitClient
+	cache *Cache
+}
+
+func NewClient(client hapkg.GitClient, cache *Cache) *Client {
+	return &Client{GitClient: client, cache: cache}
+}
+
+func (c *Client) GetVersions(fullName string) ([]string, error) {
+	versions, err := c.GitClient.GetVersions(fullName)
+	if err != nil {
+		return nil, err
+	}
+	_ = c.cache.StoreVersions(fullName, versions)
+	return versions, nil
+}
+
+func (c *Client) RestoreArtifact(key hapkg.ArtifactKey, target string) (bool, error) {
+	return c.cache.Restore(key, target)
+}
+
+func (c *Client) StoreArtifact(key hapkg.ArtifactKey, source string, digest string) error {
+	return c.cache.Store(key, source, digest)
+}
+
+func (c *Client) HasArtifact(key hapkg.ArtifactKey) bool {
+	return c.cache.Has(key)
+}
+
+func (c *Client) StoreRequirement(fullName string, version string, required string) error {
+	return c.cache.StoreRequirement(fullName, version, required)
+}
+
+func (c *Client) Requirement(fullName string, version string) (string, bool) {
+	return c.cache.Requirement(fullName, version)
+}
+
+// OfflineClient is a git client that never accesses the network. It serves
+// tags, artifacts and Home Assistant requirements from the cache, every
+// other request fails with hapkg.ErrOffline.
+type OfflineClient struct {
+	cache *Cache
+}
+
+// NewOfflineClient creates offline client. Cache may be nil, then every
+// request fails.
+func NewOfflineClient(cache *Cache) *OfflineClient {
+	return &OfflineClient{cache: cache}
+}
+
+func (c *OfflineClient) GetVersions(fullName string) ([]string, error) {
+	if c.cache == nil {
+		return nil, offlineError(fullName)
+	}
+	versions, err := c.cache.Versions(fullName)
+	if err != nil {
+		return nil, offlineError(fullName)
+	}
+	return versions, nil
+}
+
+func (c *OfflineClient) GetTreeFile(fullName string, branch string, _ string) ([]byte, error) {
+	return nil, offlineError(fullName + "@" + branch)
+}
+
+func (c *OfflineClient) GetReleaseFile(fullName string, branch string, _ string) (io.ReadCloser, error) {
+	return nil, offlineError(fullName + "@" + branch)
+}
+
+func (c *OfflineClient) GetReleaseAssets(fullName string, branch string) ([]string, error) {
+	return nil, offlineError(fullName + "@" + branch)
+}
+
+func (c *OfflineClient) GetTarball(fullName string, branch string) (io.ReadCloser, error) {
+	return nil, offlineError(fullName + "@" + branch)
+}
+
+func (c *OfflineClient) RestoreArtifact(key hapkg.ArtifactKey, target string) (bool, error) {
+	if c.cache == nil {
+		return false, nil
+	}
+	return c.cache.Restore(key, target)
+}
+
+func (c *OfflineClient) StoreArtifact(hapkg.ArtifactKey, string, string) error {
+	return nil
+}
+
+func (c *OfflineClient) HasArtifact(key hapkg.ArtifactKey) bool {
+	return c.cache != nil && c.cache.Has(key)
+}
+
+func (c *OfflineClient) StoreRequirement(string, string, string) error {
+	return nil
+}
+
+func (c *OfflineClient) Requirement(fullName string, version string) (string, bool) {
+	if c.cache == nil {
+		return "", false
+	}
+	return c.cache.Requirement(fullName, version)
+}
+
+func offlineError(target string) error {
+	return fmt.Errorf("%s: %w", target, hapkg.ErrOffline)
+}
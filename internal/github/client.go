@@ -3,6 +3,7 @@ package github
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
 )
 
 const (
@@ -55,9 +58,17 @@ type releaseAsset struct {
 }
 
 type release struct {
-	Assets []releaseAsset `json:"assets"`
+	TagName     string         `json:"tag_name"`
+	Name        string         `json:"name"`
+	Body        string         `json:"body"`
+	Draft       bool           `json:"draft"`
+	PublishedAt time.Time      `json:"published_at"`
+	Assets      []releaseAsset `json:"assets"`
 }
 
+// releasesPageSize is the number of releases requested per page.
+const releasesPageSize = 100
+
 func (c *Client) GetVersions(fullName string) ([]string, error) {
 	endpoint := fmt.Sprintf("%s/repos/%s/tags", c.apiBaseURL, fullName)
 	body, err := c.get(endpoint)
@@ -95,7 +106,32 @@ func (c *Client) GetTreeFile(fullName string, branch string, filePath string) ([
 	return decoded, nil
 }
 
-func (c *Client) GetReleaseFile(fullName string, branch string, filename string) ([]byte, error) {
+func (c *Client) GetReleaseFile(fullName string, branch string, filename string) (io.ReadCloser, error) {
+	rel, err := c.getRelease(fullName, branch)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range rel.Assets {
+		if asset.Name == filename {
+			return c.open(asset.BrowserDownloadURL)
+		}
+	}
+	return nil, fmt.Errorf("asset %s not found", filename)
+}
+
+func (c *Client) GetReleaseAssets(fullName string, branch string) ([]string, error) {
+	rel, err := c.getRelease(fullName, branch)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rel.Assets))
+	for _, asset := range rel.Assets {
+		names = append(names, asset.Name)
+	}
+	return names, nil
+}
+
+func (c *Client) getRelease(fullName string, branch string) (*release, error) {
 	endpoint := fmt.Sprintf("%s/repos/%s/releases/tags/%s", c.apiBaseURL, fullName, url.QueryEscape(branch))
 	body, err := c.get(endpoint)
 	if err != nil {
@@ -105,27 +141,123 @@ func (c *Client) GetReleaseFile(fullName string, branch string, filename string)
 	if err := json.Unmarshal(body, &rel); err != nil {
 		return nil, err
 	}
-	for _, asset := range rel.Assets {
-		if asset.Name == filename {
-			return c.get(asset.BrowserDownloadURL)
+	return &rel, nil
+}
+
+// GetReleases returns published releases of the repository. Pages are read
+// until one of them has a release that is not newer than the since version,
+// so every release after it is returned. If since is not a version, every
+// page is read.
+func (c *Client) GetReleases(fullName string, since string) ([]hapkg.Release, error) {
+	sinceVersion, sinceErr := hapkg.NewVersion(since)
+	endpoint := fmt.Sprintf("%s/repos/%s/releases?per_page=%d", c.apiBaseURL, fullName, releasesPageSize)
+	releases := make([]hapkg.Release, 0)
+	for endpoint != "" {
+		body, next, err := c.getPage(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		var items []release
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, err
+		}
+		reached := false
+		for _, item := range items {
+			if version, err := hapkg.NewVersion(item.TagName); err == nil && sinceErr == nil {
+				reached = reached || version.Compare(sinceVersion) <= 0
+			}
+			if item.Draft {
+				continue
+			}
+			releases = append(releases, hapkg.Release{
+				Tag:         item.TagName,
+				Name:        item.Name,
+				Body:        item.Body,
+				PublishedAt: item.PublishedAt,
+			})
 		}
+		if reached {
+			break
+		}
+		endpoint = next
 	}
-	return nil, fmt.Errorf("asset %s not found", filename)
+	return releases, nil
 }
 
-func (c *Client) GetTarball(fullName string, branch string) ([]byte, error) {
+func (c *Client) GetTarball(fullName string, branch string) (io.ReadCloser, error) {
 	endpoint := fmt.Sprintf("%s/%s/tarball/%s", c.webBaseURL, fullName, url.PathEscape(branch))
-	return c.get(endpoint)
+	return c.open(endpoint)
 }
 
 func RepoURL(fullName string) string {
 	return fmt.Sprintf("%s/%s", defaultWebBaseURL, fullName)
 }
 
-func (c *Client) get(endpoint string) ([]byte, error) {
+func (c *Client) get(endpoint string) (content []byte, err error) {
+	body, err := c.open(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeErr := body.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return io.ReadAll(body)
+}
+
+// getPage requests the endpoint and returns the response body together
+// with URL of the next page taken from the Link header. Local files have
+// a single page.
+func (c *Client) getPage(endpoint string) (content []byte, next string, err error) {
+	if parsed, err := url.Parse(endpoint); err == nil && parsed.Scheme == "file" {
+		content, err := c.get(endpoint)
+		return content, "", err
+	}
+	resp, err := c.request(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	content, err = io.ReadAll(resp.Body)
+	return content, nextPage(resp.Header), err
+}
+
+// nextPage returns the rel="next" link of the paginated response.
+func nextPage(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// open requests the endpoint and returns the response body, which must be
+// closed by the caller.
+func (c *Client) open(endpoint string) (io.ReadCloser, error) {
 	if parsed, err := url.Parse(endpoint); err == nil && parsed.Scheme == "file" {
-		return os.ReadFile(parsed.Path)
+		return os.Open(parsed.Path)
 	}
+	resp, err := c.request(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// request sends GET request to the endpoint and returns successful
+// response, whose body must be closed by the caller.
+func (c *Client) request(endpoint string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
@@ -138,18 +270,19 @@ func (c *Client) get(endpoint string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		closeErr := resp.Body.Close()
-		if err == nil {
-			err = closeErr
-		}
-	}()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		if len(body) == 0 {
-			return nil, fmt.Errorf("http status: %d", resp.StatusCode)
+		_ = resp.Body.Close()
+		message := fmt.Sprintf("http status: %d", resp.StatusCode)
+		if len(body) > 0 {
+			message += ": " + strings.TrimSpace(string(body))
+		}
+		// Missing files and refs are reported as os.ErrNotExist, so callers
+		// can tell them from network and access errors.
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s: %w", message, os.ErrNotExist)
 		}
-		return nil, fmt.Errorf("http status: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return nil, errors.New(message)
 	}
-	return io.ReadAll(resp.Body)
+	return resp, nil
 }
@@ -3,10 +3,12 @@ package github
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -50,10 +52,11 @@ func TestClientGetVersionsAndTarball(t *testing.T) {
 		t.Fatalf("unexpected versions: %+v", versions)
 	}
 
-	content, err := client.GetTarball("foo/bar", "v1.0.1")
+	stream, err := client.GetTarball("foo/bar", "v1.0.1")
 	if err != nil {
 		t.Fatal(err)
 	}
+	content := readStream(t, stream)
 	if string(content) != "tarball" {
 		t.Fatalf("unexpected tarball payload: %q", string(content))
 	}
@@ -88,13 +91,156 @@ func TestClientTreeAndReleaseFallback(t *testing.T) {
 		t.Fatalf("unexpected tree content: %q", string(content))
 	}
 
-	releaseContent, err := client.GetReleaseFile("foo/bar", "v1.0.0", "plugin.js")
+	stream, err := client.GetReleaseFile("foo/bar", "v1.0.0", "plugin.js")
 	if err != nil {
 		t.Fatal(err)
 	}
+	releaseContent := readStream(t, stream)
 	if string(releaseContent) != string(script) {
 		t.Fatalf("unexpected release content: %q", string(releaseContent))
 	}
+
+	assets, err := client.GetReleaseAssets("foo/bar", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 1 || assets[0] != "plugin.js" {
+		t.Fatalf("unexpected release assets: %+v", assets)
+	}
+}
+
+func TestClientGetReleases(t *testing.T) {
+	pages := map[string]string{
+		"https://api.local/repos/foo/bar/releases?per_page=100": `[
+			{"tag_name":"v1.2.0","name":"Draft","body":"Soon","draft":true,"published_at":null},
+			{"tag_name":"v1.1.0","name":"Next","body":"Fixes","draft":false,"published_at":"2024-05-01T10:00:00Z"}
+		]`,
+		"https://api.local/repos/foo/bar/releases?per_page=100&page=2": `[{"tag_name":"v1.0.0"}]`,
+		"https://api.local/repos/foo/bar/releases?per_page=100&page=3": `[{"tag_name":"v0.9.0"}]`,
+	}
+	requested := make([]string, 0)
+	client := &Client{
+		httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			endpoint := req.URL.String()
+			body, ok := pages[endpoint]
+			if !ok {
+				return newResponse(404, "not found"), nil
+			}
+			requested = append(requested, endpoint)
+			resp := newResponse(200, body)
+			if page := len(requested); page < len(pages) {
+				resp.Header.Set("Link", fmt.Sprintf(
+					`<https://api.local/repos/foo/bar/releases?per_page=100&page=%d>; rel="next", <https://api.local/repos/foo/bar/releases?per_page=100&page=3>; rel="last"`,
+					page+1,
+				))
+			}
+			return resp, nil
+		})},
+		apiBaseURL: "https://api.local",
+		webBaseURL: "https://web.local",
+	}
+	releases, err := client.GetReleases("foo/bar", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 2 {
+		t.Fatalf("unexpected requested pages: %v", requested)
+	}
+	if len(releases) != 2 || releases[0].Tag != "v1.1.0" || releases[0].Body != "Fixes" || releases[0].PublishedAt.Year() != 2024 {
+		t.Fatalf("unexpected releases: %+v", releases)
+	}
+
+	requested = requested[:0]
+	releases, err = client.GetReleases("foo/bar", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 3 || len(releases) != 3 {
+		t.Fatalf("expected every page to be read, got %v: %+v", requested, releases)
+	}
+}
+
+func TestClientGetReleasesFromFiles(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "repos", "foo", "bar")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "releases"), []byte(`[{"tag_name":"v1.1.0"},{"tag_name":"v1.0.0"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{
+		httpClient: &http.Client{},
+		apiBaseURL: "file://" + filepath.ToSlash(root),
+		webBaseURL: "https://web.local",
+	}
+	releases, err := client.GetReleases("foo/bar", "v0.9.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[0].Tag != "v1.1.0" {
+		t.Fatalf("unexpected releases: %+v", releases)
+	}
+}
+
+func TestClientGetTarballStatusError(t *testing.T) {
+	closed := false
+	client := &Client{
+		httpClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			resp := newResponse(404, "not found")
+			resp.Body = closeFunc{Reader: resp.Body, close: func() { closed = true }}
+			return resp, nil
+		})},
+		apiBaseURL: "https://api.local",
+		webBaseURL: "https://web.local",
+	}
+	if _, err := client.GetTarball("foo/bar", "v1.0.0"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected status error, got %v", err)
+	}
+	if !closed {
+		t.Fatal("expected response body to be closed")
+	}
+}
+
+func TestClientGetTreeFileNotFound(t *testing.T) {
+	status := http.StatusNotFound
+	client := &Client{
+		httpClient: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return newResponse(status, `{"message": "Not Found"}`), nil
+		})},
+		apiBaseURL: "https://api.local",
+		webBaseURL: "https://web.local",
+	}
+	if _, err := client.GetTreeFile("foo/bar", "v1.0.0", "hacs.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	status = http.StatusForbidden
+	_, err := client.GetTreeFile("foo/bar", "v1.0.0", "hacs.json")
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected access error, got %v", err)
+	}
+}
+
+type closeFunc struct {
+	io.Reader
+	close func()
+}
+
+func (c closeFunc) Close() error {
+	c.close()
+	return nil
+}
+
+func readStream(t *testing.T, stream io.ReadCloser) []byte {
+	t.Helper()
+	defer func() {
+		_ = stream.Close()
+	}()
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
 }
 
 func TestRepoURL(t *testing.T) {
@@ -0,0 +1,144 @@
+package hapkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const AppDaemonKind = "appdaemon"
+
+const (
+	appDaemonSourceFolder = "apps"
+	appDaemonFolderName   = "appdaemon/apps"
+	appDaemonConfigName   = "apps.yaml"
+)
+
+type AppDaemonPackage struct {
+	base BasePackage
+}
+
+func NewAppDaemonPackage(description PackageDescription, rootPath string, client GitClient) Package {
+	return &AppDaemonPackage{base: newBasePackage(description, rootPath, "tar.gz", AppDaemonKind, client)}
+}
+
+func (p *AppDaemonPackage) Description() PackageDescription { return p.base.Description() }
+func (p *AppDaemonPackage) FullName() string                { return p.base.FullName() }
+func (p *AppDaemonPackage) Version() string                 { return p.base.Version() }
+func (p *AppDaemonPackage) Kind() string                    { return p.base.Kind() }
+func (p *AppDaemonPackage) Destroy() error                  { return p.base.Destroy() }
+func (p *AppDaemonPackage) LatestVersion(stableOnly bool) (string, error) {
+	return p.base.LatestVersion(stableOnly)
+}
+
+func (p *AppDaemonPackage) Setup() error {
+	if p.base.version == "latest" {
+		return fmt.Errorf("version is unknown")
+	}
+	return p.base.downloadTarball(p.base.version)
+}
+
+func (p *AppDaemonPackage) Switch(version string) error {
+	if err := p.base.downloadTarball(version); err != nil {
+		return err
+	}
+	if err := os.Remove(p.base.Path("")); err != nil {
+		return err
+	}
+	p.base.version = version
+	return nil
+}
+
+func (p *AppDaemonPackage) Export(dest string) error {
+	return extractFolder(p.base.Path(""), dest, appDaemonSourceFolder, appDaemonFolderName, nil)
+}
+
+func AppDaemonPreExport(path string) error {
+	return os.MkdirAll(filepath.Join(path, appDaemonFolderName), 0o755)
+}
+
+// AppDaemonPostExport merges apps.yaml fragments shipped inside app folders
+// into the single apps.yaml at the root of the apps directory. Fragments are
+// removed afterwards, otherwise AppDaemon would load every app twice.
+func AppDaemonPostExport(path string) ([]string, error) {
+	appsPath := filepath.Join(path, appDaemonFolderName)
+	entries, err := os.ReadDir(appsPath)
+	if err != nil {
+		return nil, err
+	}
+	fragments := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fragment := filepath.Join(appsPath, entry.Name(), appDaemonConfigName)
+		if _, err := os.Stat(fragment); err == nil {
+			fragments = append(fragments, entry.Name()+"/"+appDaemonConfigName)
+		}
+	}
+	if len(fragments) == 0 {
+		return nil, nil
+	}
+	sort.Strings(fragments)
+
+	configPath := filepath.Join(appsPath, appDaemonConfigName)
+	merged := bytes.NewBuffer(nil)
+	if content, err := os.ReadFile(configPath); err == nil {
+		merged.Write(content)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	owners := map[string]string{}
+	if err := collectAppNames(merged.Bytes(), appDaemonConfigName, owners); err != nil {
+		return nil, err
+	}
+	for _, fragment := range fragments {
+		fragmentPath := filepath.Join(appsPath, filepath.FromSlash(fragment))
+		content, err := os.ReadFile(fragmentPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := collectAppNames(content, fragment, owners); err != nil {
+			return nil, err
+		}
+		if merged.Len() > 0 && !bytes.HasSuffix(merged.Bytes(), []byte("\n")) {
+			merged.WriteString("\n")
+		}
+		merged.WriteString("# " + fragment + "\n")
+		merged.Write(content)
+		if err := os.Remove(fragmentPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(configPath, merged.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	return fragments, nil
+}
+
+func collectAppNames(content []byte, source string, owners map[string]string) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("parsing %s: %w", source, err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s must be a mapping of apps", source)
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		name := root.Content[i].Value
+		if owner, ok := owners[name]; ok {
+			return fmt.Errorf("app %s is declared in both %s and %s", name, owner, source)
+		}
+		owners[name] = source
+	}
+	return nil
+}
@@ -1,17 +1,22 @@
 package hapkg
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// GitClient reads repositories from git hosting. Release files and
+// tarballs are returned as streams, which must be closed by the caller.
 type GitClient interface {
 	GetVersions(fullName string) ([]string, error)
 	GetTreeFile(fullName string, branch string, filePath string) ([]byte, error)
-	GetReleaseFile(fullName string, branch string, filename string) ([]byte, error)
-	GetTarball(fullName string, branch string) ([]byte, error)
+	GetReleaseFile(fullName string, branch string, filename string) (io.ReadCloser, error)
+	GetReleaseAssets(fullName string, branch string) ([]string, error)
+	GetTarball(fullName string, branch string) (io.ReadCloser, error)
 }
 
 type Package interface {
@@ -26,6 +31,12 @@ type Package interface {
 	LatestVersion(stableOnly bool) (string, error)
 }
 
+// DomainProvider is implemented by packages that ship Home Assistant
+// integration domains.
+type DomainProvider interface {
+	Domains() ([]string, error)
+}
+
 type BasePackage struct {
 	kind      string
 	extension string
@@ -33,6 +44,8 @@ type BasePackage struct {
 	version   string
 	basePath  string
 	name      string
+	include   []string
+	exclude   []string
 	client    GitClient
 }
 
@@ -44,12 +57,20 @@ func newBasePackage(description PackageDescription, rootPath string, extension s
 		version:   description.Version,
 		basePath:  filepath.Join(rootPath, strings.ReplaceAll(description.FullName, "/", "-")),
 		name:      description.ShortName(),
+		include:   description.Include,
+		exclude:   description.Exclude,
 		client:    client,
 	}
 }
 
 func (b *BasePackage) Description() PackageDescription {
-	return PackageDescription{FullName: b.fullName, Kind: b.kind, Version: b.version}
+	return PackageDescription{
+		FullName: b.fullName,
+		Kind:     b.kind,
+		Version:  b.version,
+		Include:  b.include,
+		Exclude:  b.exclude,
+	}
 }
 
 func (b *BasePackage) Path(version string) string {
@@ -63,6 +84,44 @@ func (b *BasePackage) Destroy() error {
 	return os.Remove(b.Path(""))
 }
 
+func (b *BasePackage) downloadTarball(version string) error {
+	return b.materialize(version, func(path string) (string, error) {
+		stream, err := b.client.GetTarball(b.fullName, version)
+		if err != nil {
+			return "", err
+		}
+		return writeStream(path, stream)
+	})
+}
+
+// materialize places the artifact of the version to the storage. If the
+// client has a shared cache, the artifact is taken from it when possible
+// and added to it after download. Cache failures never fail the download.
+// The download function returns digest of the written artifact.
+func (b *BasePackage) materialize(version string, download func(path string) (string, error)) error {
+	target := b.Path(version)
+	cache, ok := b.client.(ArtifactCache)
+	if !ok {
+		_, err := download(target)
+		return err
+	}
+	key := ArtifactKey{FullName: b.fullName, Version: version, Kind: b.kind}
+	if found, err := cache.RestoreArtifact(key, target); err == nil && found {
+		return nil
+	}
+	// The target may be a hardlink to a cached blob, which must not be
+	// overwritten in place.
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	digest, err := download(target)
+	if err != nil {
+		return err
+	}
+	_ = cache.StoreArtifact(key, target, digest)
+	return nil
+}
+
 func (b *BasePackage) LatestVersion(stableOnly bool) (string, error) {
 	versions, err := b.client.GetVersions(b.fullName)
 	if err != nil {
@@ -0,0 +1,39 @@
+package hapkg
+
+import "errors"
+
+// ErrOffline is returned by git clients that are not allowed to access
+// the network.
+var ErrOffline = errors.New("network access is disabled")
+
+// ArtifactKey identifies a stored package artifact in a shared cache.
+type ArtifactKey struct {
+	FullName string
+	Version  string
+	Kind     string
+}
+
+// ArtifactCache is implemented by git clients that can reuse artifacts
+// downloaded by other storages.
+type ArtifactCache interface {
+	// RestoreArtifact places the cached artifact to target and reports
+	// whether it was found.
+	RestoreArtifact(key ArtifactKey, target string) (bool, error)
+	// StoreArtifact adds the artifact located at source to the cache.
+	// Digest is the sha256 digest of the artifact computed during download,
+	// it is calculated from the file when empty.
+	StoreArtifact(key ArtifactKey, source string, digest string) error
+	// HasArtifact reports whether the artifact is cached.
+	HasArtifact(key ArtifactKey) bool
+}
+
+// RequirementCache is implemented by git clients that keep Home Assistant
+// requirements of package versions for offline use.
+type RequirementCache interface {
+	// StoreRequirement saves the minimal Home Assistant version declared by
+	// the package version. Empty version means there is no requirement.
+	StoreRequirement(fullName string, version string, required string) error
+	// Requirement returns the saved requirement of the package version and
+	// reports whether it was found.
+	Requirement(fullName string, version string) (string, bool)
+}
@@ -0,0 +1,54 @@
+package hapkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const hacsManifestName = "hacs.json"
+
+type hacsManifest struct {
+	HomeAssistant string `json:"homeassistant"`
+}
+
+// MinHomeAssistantVersion returns the minimal Home Assistant version declared
+// in hacs.json of the repository at the given version. Empty string means
+// the repository declares no requirement. Only a missing hacs.json means
+// that, other errors of the client are returned.
+func MinHomeAssistantVersion(client GitClient, fullName string, version string) (string, error) {
+	content, err := client.GetTreeFile(fullName, version, hacsManifestName)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s of %s@%s: %w", hacsManifestName, fullName, version, err)
+	}
+	if len(content) == 0 {
+		return "", nil
+	}
+	var manifest hacsManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return "", fmt.Errorf("parsing %s of %s@%s: %w", hacsManifestName, fullName, version, err)
+	}
+	return manifest.HomeAssistant, nil
+}
+
+// IsCompatible reports whether Home Assistant of the current version
+// satisfies the required minimal version. Versions that cannot be parsed
+// are considered compatible.
+func IsCompatible(required string, current string) bool {
+	if required == "" || current == "" {
+		return true
+	}
+	requiredVersion, err := NewVersion(required)
+	if err != nil {
+		return true
+	}
+	currentVersion, err := NewVersion(current)
+	if err != nil {
+		return true
+	}
+	return requiredVersion.CompareRelease(currentVersion) <= 0
+}
@@ -0,0 +1,77 @@
+package hapkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// compressedExtensions lists extensions of text assets the frontend can
+// serve pre-compressed.
+var compressedExtensions = []string{".js", ".mjs", ".css", ".json", ".map", ".svg", ".html", ".txt"}
+
+// Compressor is implemented by packages that can export gzip-compressed
+// copies of their files next to the originals.
+type Compressor interface {
+	ExportCompressed(path string) error
+}
+
+// ExportCompressed exports the plugin and writes a gzip-compressed
+// sibling for each text asset.
+func (p *PluginPackage) ExportCompressed(path string) error {
+	if err := p.Export(path); err != nil {
+		return err
+	}
+	return compressFiles(filepath.Join(path, pluginFolderName, p.base.name))
+}
+
+// compressFiles writes deterministic .gz siblings of text assets inside
+// root. Siblings that are not smaller than the original are skipped.
+func compressFiles(root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || !isCompressible(entry.Name()) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		compressed, err := gzipBytes(content)
+		if err != nil {
+			return err
+		}
+		if len(compressed) >= len(content) {
+			return nil
+		}
+		return os.WriteFile(path+".gz", compressed, 0o644)
+	})
+}
+
+// gzipBytes compresses content without name and modification time in
+// the header, so equal content always gives equal bytes.
+func gzipBytes(content []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	gz, err := gzip.NewWriterLevel(buffer, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(content); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func isCompressible(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	return slices.Contains(compressedExtensions, extension)
+}
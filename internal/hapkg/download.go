@@ -0,0 +1,140 @@
+package hapkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DigestPrefix marks sha256 digests of artifacts.
+const DigestPrefix = "sha256:"
+
+// ErrDownloadLimit is returned when a download exceeds the maximum size.
+var ErrDownloadLimit = errors.New("download size limit exceeded")
+
+// DownloadOptions bound and observe downloads of release files and tarballs.
+type DownloadOptions struct {
+	// MaxSize limits size of a single download in bytes. Zero disables
+	// the limit.
+	MaxSize int64
+	// Progress is called with full name of the package and number of
+	// bytes received since the previous call.
+	Progress func(fullName string, received int64)
+}
+
+// MeteredClient is a git client that enforces download options on
+// streams of the wrapped client.
+type MeteredClient struct {
+	GitClient
+	opts DownloadOptions
+}
+
+func NewMeteredClient(client GitClient, opts DownloadOptions) *MeteredClient {
+	return &MeteredClient{GitClient: client, opts: opts}
+}
+
+func (c *MeteredClient) GetReleaseFile(fullName string, branch string, filename string) (io.ReadCloser, error) {
+	stream, err := c.GitClient.GetReleaseFile(fullName, branch, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{ReadCloser: stream, fullName: fullName, opts: c.opts}, nil
+}
+
+func (c *MeteredClient) GetTarball(fullName string, branch string) (io.ReadCloser, error) {
+	stream, err := c.GitClient.GetTarball(fullName, branch)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{ReadCloser: stream, fullName: fullName, opts: c.opts}, nil
+}
+
+type meteredReader struct {
+	io.ReadCloser
+	fullName string
+	opts     DownloadOptions
+	received int64
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.received += int64(n)
+	if r.opts.MaxSize > 0 && r.received > r.opts.MaxSize {
+		return n, fmt.Errorf("%s: %w: %d bytes", r.fullName, ErrDownloadLimit, r.opts.MaxSize)
+	}
+	if n > 0 && r.opts.Progress != nil {
+		r.opts.Progress(r.fullName, int64(n))
+	}
+	return n, err
+}
+
+// writeStream copies the stream to path through a temporary file in the
+// same directory and closes it. The target is replaced only when the
+// stream is received completely. Returns sha256 digest of the content.
+func writeStream(path string, stream io.ReadCloser) (digest string, err error) {
+	defer func() {
+		closeErr := stream.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = temp.Close()
+			_ = os.Remove(temp.Name())
+		}
+	}()
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(temp, hash), stream); err != nil {
+		return "", err
+	}
+	if err = temp.Chmod(0o644); err != nil {
+		return "", err
+	}
+	if err = temp.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(temp.Name(), path); err != nil {
+		return "", err
+	}
+	return DigestPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// FileDigest returns sha256 digest and size of the file. Symlinks are
+// hashed by their target path.
+func FileDigest(path string) (string, int64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	hash := sha256.New()
+	if info.Mode()&fs.ModeSymlink != 0 {
+		linkname, err := os.Readlink(path)
+		if err != nil {
+			return "", 0, err
+		}
+		_, _ = hash.Write([]byte("symlink:" + linkname))
+		return DigestPrefix + hex.EncodeToString(hash.Sum(nil)), 0, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return DigestPrefix + hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -0,0 +1,235 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errUnsafeEntry  = errors.New("unsafe archive entry")
+	errArchiveLimit = errors.New("archive limit exceeded")
+)
+
+// extractLimits bound the amount of data unpacked from a single archive.
+type extractLimits struct {
+	files int
+	size  int64
+}
+
+var archiveLimits = extractLimits{
+	files: 10000,
+	size:  512 << 20,
+}
+
+type pendingSymlink struct {
+	rel      string
+	linkname string
+}
+
+// extractor unpacks archive entries into root. Entry paths must stay
+// inside root: absolute paths and parent references are rejected.
+// Symlinks are created after every regular file is written, so no file
+// is ever written through a link taken from the archive. Entries whose
+// path or link target goes through another archive symlink are rejected,
+// so chains of links can't leave root either.
+type extractor struct {
+	root     string
+	limits   extractLimits
+	files    int
+	size     int64
+	written  map[string]bool
+	links    map[string]bool
+	symlinks []pendingSymlink
+}
+
+func newExtractor(root string) *extractor {
+	return &extractor{
+		root:    root,
+		limits:  archiveLimits,
+		written: map[string]bool{},
+		links:   map[string]bool{},
+	}
+}
+
+func (e *extractor) extract(header *tar.Header, reader io.Reader, rel string, linkname string) error {
+	rel = path.Clean(filepath.ToSlash(rel))
+	if rel == "." {
+		return nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return fmt.Errorf("%w: %s", errUnsafeEntry, header.Name)
+	}
+	if link := e.linkAncestor(rel); link != "" {
+		return fmt.Errorf("%w: %s goes through symlink %s", errUnsafeEntry, header.Name, link)
+	}
+	target := filepath.Join(e.root, filepath.FromSlash(rel))
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0o755)
+	case tar.TypeReg:
+		if err := e.count(header.Size); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := writeFile(target, io.LimitReader(reader, header.Size), fileMode(header.Mode)); err != nil {
+			return err
+		}
+		e.written[rel] = true
+	case tar.TypeLink:
+		source := path.Clean(filepath.ToSlash(linkname))
+		if !e.written[source] {
+			return fmt.Errorf("%w: hardlink %s points to unknown file %s", errUnsafeEntry, header.Name, linkname)
+		}
+		sourcePath := filepath.Join(e.root, filepath.FromSlash(source))
+		info, err := os.Stat(sourcePath)
+		if err != nil {
+			return err
+		}
+		if err := e.count(info.Size()); err != nil {
+			return err
+		}
+		file, err := os.Open(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := writeFile(target, file, info.Mode().Perm()); err != nil {
+			return err
+		}
+		e.written[rel] = true
+	case tar.TypeSymlink:
+		if strings.HasPrefix(linkname, "/") || filepath.IsAbs(linkname) {
+			return fmt.Errorf("%w: symlink %s points to absolute path", errUnsafeEntry, header.Name)
+		}
+		resolved := path.Join(path.Dir(rel), filepath.ToSlash(linkname))
+		if !filepath.IsLocal(filepath.FromSlash(resolved)) {
+			return fmt.Errorf("%w: symlink %s points outside of the tree", errUnsafeEntry, header.Name)
+		}
+		if err := e.count(0); err != nil {
+			return err
+		}
+		e.links[rel] = true
+		e.symlinks = append(e.symlinks, pendingSymlink{rel: rel, linkname: linkname})
+	}
+	return nil
+}
+
+// finish creates symlinks collected during extraction. Every link is
+// checked against all archive links and its real target is resolved
+// against root before it is created.
+func (e *extractor) finish() error {
+	if len(e.symlinks) == 0 {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(e.root)
+	if err != nil {
+		return err
+	}
+	for _, link := range e.symlinks {
+		if through := e.linkAncestor(link.rel); through != "" {
+			return fmt.Errorf("%w: symlink %s goes through symlink %s", errUnsafeEntry, link.rel, through)
+		}
+		if through := e.linkInTarget(link); through != "" {
+			return fmt.Errorf("%w: symlink %s points through symlink %s", errUnsafeEntry, link.rel, through)
+		}
+		target := filepath.Join(e.root, filepath.FromSlash(link.rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		resolved, err := filepath.Rel(root, filepath.Join(parent, filepath.FromSlash(link.linkname)))
+		if err != nil || !filepath.IsLocal(resolved) && resolved != "." {
+			return fmt.Errorf("%w: symlink %s points outside of the tree", errUnsafeEntry, link.rel)
+		}
+		if err := os.Symlink(link.linkname, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkAncestor returns the archive symlink that is a parent of rel, or
+// empty string if there is none.
+func (e *extractor) linkAncestor(rel string) string {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if e.links[dir] {
+			return dir
+		}
+	}
+	return ""
+}
+
+// linkInTarget returns the archive symlink the link target passes through
+// before its last element, or empty string if there is none. Such targets
+// can't be checked by their text, because the OS resolves the inner link
+// before applying parent references that follow it.
+func (e *extractor) linkInTarget(link pendingSymlink) string {
+	current := path.Dir(link.rel)
+	parts := strings.Split(filepath.ToSlash(link.linkname), "/")
+	for i, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+		current = path.Join(current, part)
+		if i < len(parts)-1 && e.links[current] {
+			return current
+		}
+	}
+	return ""
+}
+
+func (e *extractor) count(size int64) error {
+	e.files++
+	e.size += size
+	if e.files > e.limits.files {
+		return fmt.Errorf("%w: more than %d files", errArchiveLimit, e.limits.files)
+	}
+	if e.size > e.limits.size {
+		return fmt.Errorf("%w: more than %d bytes", errArchiveLimit, e.limits.size)
+	}
+	return nil
+}
+
+// fileMode normalizes permissions of extracted files: only the
+// executable bit is taken from the archive.
+func fileMode(mode int64) os.FileMode {
+	if mode&0o111 != 0 {
+		return 0o755
+	}
+	return 0o644
+}
+
+func writeFile(target string, reader io.Reader, mode os.FileMode) error {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
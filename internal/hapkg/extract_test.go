@@ -0,0 +1,209 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+	cases := map[string]tarEntry{
+		"traversal": {header: tar.Header{Name: "repo/custom_components/../../evil.py", Typeflag: tar.TypeReg}},
+		"parent":    {header: tar.Header{Name: "repo/custom_components/../evil.py", Typeflag: tar.TypeReg}},
+		"symlink outside": {header: tar.Header{
+			Name:     "repo/custom_components/demo/link",
+			Linkname: "../../../etc/passwd",
+			Typeflag: tar.TypeSymlink,
+		}},
+		"symlink absolute": {header: tar.Header{
+			Name:     "repo/custom_components/demo/link",
+			Linkname: "/etc/passwd",
+			Typeflag: tar.TypeSymlink,
+		}},
+		"hardlink outside": {header: tar.Header{
+			Name:     "repo/custom_components/demo/link",
+			Linkname: "repo/README.md",
+			Typeflag: tar.TypeLink,
+		}},
+	}
+	for name, entry := range cases {
+		tmp := t.TempDir()
+		archive := writeTestTarball(t, tmp, []tarEntry{
+			{header: tar.Header{Name: "repo/README.md", Typeflag: tar.TypeReg}, content: "readme"},
+			entry,
+		})
+		dest := filepath.Join(tmp, "export", "nested")
+		err := extractFolder(archive, dest, integrationFolderName, integrationFolderName, nil)
+		if !errors.Is(err, errUnsafeEntry) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		for _, path := range []string{filepath.Join(tmp, "export", "evil.py"), filepath.Join(tmp, "evil.py")} {
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s: file was written outside of the export: %s", name, path)
+			}
+		}
+	}
+}
+
+func TestExtractRejectsSymlinkChains(t *testing.T) {
+	cases := map[string][]tarEntry{
+		"entry through link": {
+			{header: tar.Header{Name: "repo/custom_components/demo/d", Linkname: ".", Typeflag: tar.TypeSymlink}},
+			{header: tar.Header{Name: "repo/custom_components/demo/d/e", Linkname: "../../secret", Typeflag: tar.TypeSymlink}},
+		},
+		"target through link": {
+			{header: tar.Header{Name: "repo/custom_components/demo/d", Linkname: ".", Typeflag: tar.TypeSymlink}},
+			{header: tar.Header{Name: "repo/custom_components/demo/e", Linkname: "d/../../../secret", Typeflag: tar.TypeSymlink}},
+		},
+		"target through later link": {
+			{header: tar.Header{Name: "repo/custom_components/demo/e", Linkname: "d/../../../secret", Typeflag: tar.TypeSymlink}},
+			{header: tar.Header{Name: "repo/custom_components/demo/d", Linkname: ".", Typeflag: tar.TypeSymlink}},
+		},
+	}
+	for name, entries := range cases {
+		tmp := t.TempDir()
+		archive := writeTestTarball(t, tmp, entries)
+		dest := filepath.Join(tmp, "export")
+		err := extractFolder(archive, dest, integrationFolderName, integrationFolderName, nil)
+		if !errors.Is(err, errUnsafeEntry) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		for _, link := range []string{"d/e", "e"} {
+			path := filepath.Join(dest, integrationFolderName, "demo", filepath.FromSlash(link))
+			if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s: escaping link was created: %s", name, path)
+			}
+		}
+	}
+}
+
+func TestExtractAllRejectsAbsolutePath(t *testing.T) {
+	tmp := t.TempDir()
+	archive := writeTestTarball(t, tmp, []tarEntry{
+		{header: tar.Header{Name: "/abs.js", Typeflag: tar.TypeReg}, content: "abs"},
+	})
+	if err := extractAll(archive, filepath.Join(tmp, "export")); !errors.Is(err, errUnsafeEntry) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestExtractLinksInsideTree(t *testing.T) {
+	tmp := t.TempDir()
+	archive := writeTestTarball(t, tmp, []tarEntry{
+		{header: tar.Header{Name: "repo/custom_components/demo/link.py", Linkname: "impl.py", Typeflag: tar.TypeSymlink}},
+		{header: tar.Header{Name: "repo/custom_components/demo/impl.py", Typeflag: tar.TypeReg}, content: "impl"},
+		{header: tar.Header{
+			Name:     "repo/custom_components/demo/copy.py",
+			Linkname: "repo/custom_components/demo/impl.py",
+			Typeflag: tar.TypeLink,
+		}},
+	})
+	dest := filepath.Join(tmp, "export")
+	if err := extractFolder(archive, dest, integrationFolderName, integrationFolderName, nil); err != nil {
+		t.Fatal(err)
+	}
+	demo := filepath.Join(dest, integrationFolderName, "demo")
+	linkname, err := os.Readlink(filepath.Join(demo, "link.py"))
+	if err != nil || linkname != "impl.py" {
+		t.Fatalf("unexpected symlink: %q, %v", linkname, err)
+	}
+	content, err := os.ReadFile(filepath.Join(demo, "copy.py"))
+	if err != nil || string(content) != "impl" {
+		t.Fatalf("unexpected hardlink content: %q, %v", string(content), err)
+	}
+	info, err := os.Lstat(filepath.Join(demo, "copy.py"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("expected hardlink to be extracted as regular file: %v", err)
+	}
+}
+
+func TestExtractNormalizesPermissions(t *testing.T) {
+	tmp := t.TempDir()
+	archive := writeTestTarball(t, tmp, []tarEntry{
+		{header: tar.Header{Name: "repo/custom_components/demo", Typeflag: tar.TypeDir, Mode: 0o777}},
+		{header: tar.Header{Name: "repo/custom_components/demo/open.py", Typeflag: tar.TypeReg, Mode: 0o666}},
+		{header: tar.Header{Name: "repo/custom_components/demo/run.sh", Typeflag: tar.TypeReg, Mode: 0o4777}},
+		{header: tar.Header{Name: "repo/custom_components/demo/closed.py", Typeflag: tar.TypeReg, Mode: 0o000}},
+	})
+	dest := filepath.Join(tmp, "export")
+	if err := extractFolder(archive, dest, integrationFolderName, integrationFolderName, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]os.FileMode{
+		"":          os.ModeDir | 0o755,
+		"open.py":   0o644,
+		"run.sh":    0o755,
+		"closed.py": 0o644,
+	}
+	for name, mode := range expected {
+		info, err := os.Stat(filepath.Join(dest, integrationFolderName, "demo", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Fatalf("unexpected mode of %q: %v", name, info.Mode())
+		}
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	defaults := archiveLimits
+	t.Cleanup(func() {
+		archiveLimits = defaults
+	})
+	entries := []tarEntry{
+		{header: tar.Header{Name: "repo/custom_components/demo/a.py", Typeflag: tar.TypeReg}, content: "12345"},
+		{header: tar.Header{Name: "repo/custom_components/demo/b.py", Typeflag: tar.TypeReg}, content: "12345"},
+		{header: tar.Header{Name: "repo/custom_components/demo/c.py", Typeflag: tar.TypeReg}, content: "12345"},
+	}
+	cases := []extractLimits{
+		{files: 2, size: 1024},
+		{files: 10, size: 12},
+	}
+	for _, limits := range cases {
+		archiveLimits = limits
+		tmp := t.TempDir()
+		archive := writeTestTarball(t, tmp, entries)
+		err := extractFolder(archive, filepath.Join(tmp, "export"), integrationFolderName, integrationFolderName, nil)
+		if !errors.Is(err, errArchiveLimit) {
+			t.Fatalf("unexpected error for %+v: %v", limits, err)
+		}
+	}
+}
+
+func writeTestTarball(t *testing.T, dir string, entries []tarEntry) string {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := entry.header
+		header.Size = int64(len(entry.content))
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatalf("write header %s: %v", header.Name, err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("write content %s: %v", header.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "fixture.tar.gz")
+	if err := os.WriteFile(path, buffer.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
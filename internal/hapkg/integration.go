@@ -1,12 +1,10 @@
 package hapkg
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -15,6 +13,8 @@ const integrationFolderName = "custom_components"
 
 type IntegrationPackage struct {
 	base BasePackage
+	// haVersion is the Home Assistant version the integration must support.
+	haVersion string
 }
 
 func NewIntegrationPackage(description PackageDescription, rootPath string, client GitClient) Package {
@@ -34,11 +34,22 @@ func (p *IntegrationPackage) Setup() error {
 	if p.base.version == "latest" {
 		return fmt.Errorf("version is unknown")
 	}
-	return p.downloadTarball(p.base.version)
+	if err := p.base.downloadTarball(p.base.version); err != nil {
+		return err
+	}
+	if err := p.verify(p.base.version); err != nil {
+		_ = os.Remove(p.base.Path(""))
+		return err
+	}
+	return nil
 }
 
 func (p *IntegrationPackage) Switch(version string) error {
-	if err := p.downloadTarball(version); err != nil {
+	if err := p.base.downloadTarball(version); err != nil {
+		return err
+	}
+	if err := p.verify(version); err != nil {
+		_ = os.Remove(p.base.Path(version))
 		return err
 	}
 	if err := os.Remove(p.base.Path("")); err != nil {
@@ -49,75 +60,36 @@ func (p *IntegrationPackage) Switch(version string) error {
 }
 
 func (p *IntegrationPackage) Export(dest string) error {
-	file, err := os.Open(p.base.Path(""))
+	domains, err := listFolder(p.base.Path(""), integrationFolderName)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		closeErr := file.Close()
-		if err == nil {
-			err = closeErr
+	for _, domain := range p.base.include {
+		if !slices.Contains(domains, domain) {
+			return fmt.Errorf("domain %s is not found in %s@%s", domain, p.base.fullName, p.base.version)
 		}
-	}()
-
-	gz, err := gzip.NewReader(file)
-	if err != nil {
-		return err
 	}
-	defer func() {
-		closeErr := gz.Close()
-		if err == nil {
-			err = closeErr
-		}
-	}()
-
-	reader := tar.NewReader(gz)
-	for {
-		header, err := reader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		name := filepath.ToSlash(header.Name)
-		idx := strings.Index(name, "/"+integrationFolderName+"/")
-		if idx < 0 {
-			continue
-		}
-		rel := name[idx+1:]
-		target := filepath.Join(dest, rel)
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
-				return err
-			}
-			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(out, reader); err != nil {
-				_ = out.Close()
-				return err
-			}
-			if err := out.Close(); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	description := p.Description()
+	return extractFolder(p.base.Path(""), dest, integrationFolderName, integrationFolderName, func(rel string) bool {
+		return description.Allows(strings.SplitN(rel, "/", 2)[0])
+	})
 }
 
-func (p *IntegrationPackage) downloadTarball(version string) error {
-	content, err := p.base.client.GetTarball(p.base.fullName, version)
+// Domains returns integration domains provided by the package after
+// include and exclude filters are applied.
+func (p *IntegrationPackage) Domains() ([]string, error) {
+	domains, err := listFolder(p.base.Path(""), integrationFolderName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	description := p.Description()
+	allowed := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if description.Allows(domain) {
+			allowed = append(allowed, domain)
+		}
 	}
-	return os.WriteFile(p.base.Path(version), content, 0o644)
+	return allowed, nil
 }
 
 func IntegrationPreExport(path string) error {
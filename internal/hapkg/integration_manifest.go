@@ -0,0 +1,289 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const integrationManifestName = "manifest.json"
+
+// Severities of manifest issues.
+const (
+	IssueWarning = "warning"
+	IssueError   = "error"
+)
+
+// IntegrationManifest is the manifest.json of a custom component.
+type IntegrationManifest struct {
+	// Folder is the name of the custom_components folder the manifest
+	// was read from.
+	Folder            string   `json:"-"`
+	Domain            string   `json:"domain"`
+	Name              string   `json:"name"`
+	Version           string   `json:"version"`
+	Codeowners        []string `json:"codeowners"`
+	Dependencies      []string `json:"dependencies"`
+	AfterDependencies []string `json:"after_dependencies"`
+	Requirements      []string `json:"requirements"`
+	// HomeAssistant is the minimal supported Home Assistant version.
+	HomeAssistant string `json:"homeassistant"`
+}
+
+// ManifestIssue is a problem found in a manifest of an integration domain.
+type ManifestIssue struct {
+	Domain   string
+	Severity string
+	Message  string
+}
+
+// ManifestError is returned by Setup and Switch when the downloaded
+// integration is not a valid custom component.
+type ManifestError struct {
+	FullName string
+	Version  string
+	Issues   []ManifestIssue
+}
+
+func (e *ManifestError) Error() string {
+	messages := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		if issue.Severity == IssueError {
+			messages = append(messages, issue.Domain+": "+issue.Message)
+		}
+	}
+	return fmt.Sprintf("invalid integration %s@%s: %s", e.FullName, e.Version, strings.Join(messages, "; "))
+}
+
+// ManifestProvider is implemented by packages that ship custom components
+// with manifest.json files.
+type ManifestProvider interface {
+	Manifests() ([]IntegrationManifest, error)
+}
+
+// Validator is implemented by packages that can check stored content.
+type Validator interface {
+	Validate() ([]ManifestIssue, error)
+}
+
+// HomeAssistantConstrained is implemented by packages that refuse to set up
+// content requiring a newer Home Assistant than the configured one.
+type HomeAssistantConstrained interface {
+	SetHomeAssistantVersion(version string)
+}
+
+// integrationFolder is a domain folder of custom_components.
+type integrationFolder struct {
+	manifest *IntegrationManifest
+	keys     map[string]bool
+	err      error
+}
+
+// Manifests returns manifests of integration domains provided by the
+// package ordered by domain folder name.
+func (p *IntegrationPackage) Manifests() ([]IntegrationManifest, error) {
+	return p.manifests(p.base.version)
+}
+
+func (p *IntegrationPackage) manifests(version string) ([]IntegrationManifest, error) {
+	folders, err := readIntegrationFolders(p.base.Path(version))
+	if err != nil {
+		return nil, err
+	}
+	description := p.Description()
+	items := make([]IntegrationManifest, 0, len(folders))
+	for _, name := range sortedFolders(folders) {
+		folder := folders[name]
+		if folder.manifest == nil || !description.Allows(name) {
+			continue
+		}
+		if folder.err != nil {
+			return nil, folder.err
+		}
+		items = append(items, *folder.manifest)
+	}
+	return items, nil
+}
+
+// Validate checks manifests of the stored integration.
+func (p *IntegrationPackage) Validate() ([]ManifestIssue, error) {
+	return p.validate(p.base.version)
+}
+
+func (p *IntegrationPackage) validate(version string) ([]ManifestIssue, error) {
+	folders, err := readIntegrationFolders(p.base.Path(version))
+	if err != nil {
+		return nil, err
+	}
+	return validateIntegrationFolders(folders, p.Description()), nil
+}
+
+// SetHomeAssistantVersion makes Setup and Switch fail if manifests of
+// the downloaded version require a newer Home Assistant.
+func (p *IntegrationPackage) SetHomeAssistantVersion(version string) {
+	p.haVersion = version
+}
+
+// verify fails if the stored version of the integration has manifest errors
+// or requires a newer Home Assistant than the configured one.
+func (p *IntegrationPackage) verify(version string) error {
+	issues, err := p.validate(version)
+	if err != nil {
+		return err
+	}
+	if p.haVersion != "" && !hasIssueErrors(issues) {
+		manifests, err := p.manifests(version)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, ManifestIncompatibilities(manifests, p.haVersion)...)
+	}
+	for _, issue := range issues {
+		if issue.Severity == IssueError {
+			return &ManifestError{FullName: p.base.fullName, Version: version, Issues: issues}
+		}
+	}
+	return nil
+}
+
+// ManifestIncompatibilities reports integration domains whose manifests
+// require a newer Home Assistant than the current one.
+func ManifestIncompatibilities(manifests []IntegrationManifest, current string) []ManifestIssue {
+	issues := make([]ManifestIssue, 0)
+	for _, manifest := range manifests {
+		if IsCompatible(manifest.HomeAssistant, current) {
+			continue
+		}
+		issues = append(issues, ManifestIssue{
+			Domain:   manifest.Folder,
+			Severity: IssueError,
+			Message:  "requires Home Assistant " + manifest.HomeAssistant + ", but " + current + " is configured",
+		})
+	}
+	return issues
+}
+
+func hasIssueErrors(issues []ManifestIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == IssueError {
+			return true
+		}
+	}
+	return false
+}
+
+func validateIntegrationFolders(folders map[string]*integrationFolder, description PackageDescription) []ManifestIssue {
+	issues := make([]ManifestIssue, 0)
+	add := func(domain string, severity string, format string, args ...any) {
+		issues = append(issues, ManifestIssue{Domain: domain, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+	allowed := make([]string, 0, len(folders))
+	for _, name := range sortedFolders(folders) {
+		if description.Allows(name) {
+			allowed = append(allowed, name)
+		}
+	}
+	if len(allowed) == 0 {
+		add(integrationFolderName, IssueError, "no integration domains found")
+		return issues
+	}
+	for _, name := range allowed {
+		folder := folders[name]
+		switch {
+		case folder.manifest == nil:
+			add(name, IssueError, "%s is missing", integrationManifestName)
+			continue
+		case folder.err != nil:
+			add(name, IssueError, "%v", folder.err)
+			continue
+		}
+		manifest := folder.manifest
+		switch {
+		case manifest.Domain == "":
+			add(name, IssueError, "required key domain is missing")
+		case manifest.Domain != name:
+			add(name, IssueError, "domain %s does not match folder name", manifest.Domain)
+		}
+		if manifest.Version == "" {
+			add(name, IssueError, "required key version is missing")
+		}
+		for _, key := range []string{"name", "codeowners"} {
+			if !folder.keys[key] {
+				add(name, IssueError, "required key %s is missing", key)
+			}
+		}
+		for _, dependency := range manifest.Dependencies {
+			if _, ok := folders[dependency]; ok && !description.Allows(dependency) {
+				add(name, IssueError, "depends on %s, which is excluded from export", dependency)
+			}
+		}
+		for _, dependency := range manifest.AfterDependencies {
+			if _, ok := folders[dependency]; ok && !description.Allows(dependency) {
+				add(name, IssueWarning, "loads after %s, which is excluded from export", dependency)
+			}
+		}
+	}
+	return issues
+}
+
+// readIntegrationFolders reads domain folders of custom_components and
+// their manifests from the tarball.
+func readIntegrationFolders(archivePath string) (map[string]*integrationFolder, error) {
+	folders := map[string]*integrationFolder{}
+	err := walkTarball(archivePath, func(header *tar.Header, reader io.Reader) error {
+		rel, ok := folderPath(header.Name, integrationFolderName)
+		if !ok {
+			return nil
+		}
+		parts := strings.Split(strings.TrimSuffix(rel, "/"), "/")
+		if parts[0] == "" || (len(parts) == 1 && header.Typeflag != tar.TypeDir) {
+			return nil
+		}
+		folder, ok := folders[parts[0]]
+		if !ok {
+			folder = &integrationFolder{}
+			folders[parts[0]] = folder
+		}
+		if len(parts) != 2 || parts[1] != integrationManifestName || header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		folder.manifest, folder.keys, folder.err = parseIntegrationManifest(parts[0], reader)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func parseIntegrationManifest(folder string, reader io.Reader) (*IntegrationManifest, map[string]bool, error) {
+	manifest := &IntegrationManifest{Folder: folder}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return manifest, nil, err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return manifest, nil, fmt.Errorf("parsing %s/%s: %w", folder, integrationManifestName, err)
+	}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return manifest, nil, fmt.Errorf("parsing %s/%s: %w", folder, integrationManifestName, err)
+	}
+	keys := make(map[string]bool, len(raw))
+	for key := range raw {
+		keys[key] = true
+	}
+	return manifest, keys, nil
+}
+
+func sortedFolders(folders map[string]*integrationFolder) []string {
+	names := make([]string, 0, len(folders))
+	for name := range folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+package hapkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of generated Lovelace resources.
+const (
+	ResourcesYAML    = "yaml"
+	ResourcesStorage = "storage"
+)
+
+const (
+	pluginURLPrefix       = "/local/custom_lovelace/"
+	lovelaceResourceType  = "module"
+	lovelaceResourcesYAML = "lovelace_resources.yaml"
+	lovelaceResourcesKey  = "lovelace_resources"
+)
+
+var lovelaceResourcesStorage = filepath.Join(".storage", lovelaceResourcesKey)
+
+// LovelaceResource is a dashboard resource of an exported plugin.
+type LovelaceResource struct {
+	URL  string `yaml:"url"`
+	Type string `yaml:"type"`
+}
+
+type lovelaceStorageItem struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type lovelaceStorage struct {
+	Version      int    `json:"version"`
+	MinorVersion int    `json:"minor_version"`
+	Key          string `json:"key"`
+	Data         struct {
+		Items []lovelaceStorageItem `json:"items"`
+	} `json:"data"`
+}
+
+// PluginURL returns URL the exported plugin file is served by.
+func PluginURL(file string) string {
+	return pluginURLPrefix + file
+}
+
+// PluginResources returns Lovelace resources for plugin entry modules
+// returned by PluginPostExport. Versions are keyed by plugin folder name
+// and appended to URLs to bust the browser cache on updates.
+func PluginResources(files []string, versions map[string]string) []LovelaceResource {
+	resources := make([]LovelaceResource, 0, len(files))
+	for _, file := range files {
+		resource := LovelaceResource{URL: PluginURL(file), Type: lovelaceResourceType}
+		folder := strings.SplitN(file, "/", 2)[0]
+		if version, ok := versions[folder]; ok {
+			resource.URL += "?v=" + url.QueryEscape(version)
+		}
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+// ValidateResourcesFormat checks that resources format is supported.
+func ValidateResourcesFormat(format string) error {
+	switch format {
+	case "", ResourcesYAML, ResourcesStorage:
+		return nil
+	}
+	return fmt.Errorf("unsupported resources format: %s", format)
+}
+
+// WriteLovelaceResources writes resources to the export directory in
+// the given format and returns slash-separated path of the written file.
+func WriteLovelaceResources(path string, format string, resources []LovelaceResource) (string, error) {
+	var (
+		name    string
+		content []byte
+		err     error
+	)
+	switch format {
+	case ResourcesYAML:
+		name = lovelaceResourcesYAML
+		config := map[string]any{"lovelace": map[string]any{"resources": resources}}
+		content, err = yaml.Marshal(config)
+	case ResourcesStorage:
+		name = lovelaceResourcesStorage
+		content, err = json.MarshalIndent(newLovelaceStorage(resources), "", "  ")
+	default:
+		return "", ValidateResourcesFormat(format)
+	}
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(path, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(target, content, 0o644); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(name), nil
+}
+
+// newLovelaceStorage builds the storage collection of resources. Item ids
+// are derived from URLs without the version, so they are stable across
+// updates.
+func newLovelaceStorage(resources []LovelaceResource) lovelaceStorage {
+	storage := lovelaceStorage{Version: 1, MinorVersion: 1, Key: lovelaceResourcesKey}
+	storage.Data.Items = make([]lovelaceStorageItem, 0, len(resources))
+	for _, resource := range resources {
+		sum := sha256.Sum256([]byte(strings.SplitN(resource.URL, "?", 2)[0]))
+		storage.Data.Items = append(storage.Data.Items, lovelaceStorageItem{
+			ID:   hex.EncodeToString(sum[:16]),
+			Type: resource.Type,
+			URL:  resource.URL,
+		})
+	}
+	return storage
+}
@@ -4,9 +4,13 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -15,6 +19,7 @@ type fakeGitClient struct {
 	tarballs map[string][]byte
 	tree     map[string][]byte
 	release  map[string][]byte
+	assets   map[string][]string
 }
 
 func (f fakeGitClient) GetVersions(fullName string) ([]string, error) {
@@ -29,21 +34,28 @@ func (f fakeGitClient) GetTreeFile(fullName string, branch string, filePath stri
 	if content, ok := f.tree[key]; ok {
 		return content, nil
 	}
-	return nil, errors.New("tree file not found")
+	return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
 }
 
-func (f fakeGitClient) GetReleaseFile(fullName string, branch string, filename string) ([]byte, error) {
+func (f fakeGitClient) GetReleaseFile(fullName string, branch string, filename string) (io.ReadCloser, error) {
 	key := fullName + "@" + branch + ":" + filename
 	if content, ok := f.release[key]; ok {
-		return content, nil
+		return io.NopCloser(bytes.NewReader(content)), nil
 	}
 	return nil, errors.New("release file not found")
 }
 
-func (f fakeGitClient) GetTarball(fullName string, branch string) ([]byte, error) {
+func (f fakeGitClient) GetReleaseAssets(fullName string, branch string) ([]string, error) {
+	if assets, ok := f.assets[fullName+"@"+branch]; ok {
+		return assets, nil
+	}
+	return nil, errors.New("release not found")
+}
+
+func (f fakeGitClient) GetTarball(fullName string, branch string) (io.ReadCloser, error) {
 	key := fullName + "@" + branch
 	if content, ok := f.tarballs[key]; ok {
-		return content, nil
+		return io.NopCloser(bytes.NewReader(content)), nil
 	}
 	return nil, errors.New("tarball not found")
 }
@@ -51,7 +63,7 @@ func (f fakeGitClient) GetTarball(fullName string, branch string) ([]byte, error
 func TestIntegrationPackageExport(t *testing.T) {
 	tmp := t.TempDir()
 	tarball := makeTarball(t, map[string]string{
-		"repo-abc/custom_components/demo/manifest.json": `{}`,
+		"repo-abc/custom_components/demo/manifest.json": testManifest("demo"),
 		"repo-abc/custom_components/demo/__init__.py":   "",
 		"repo-abc/README.md":                            "hello",
 	})
@@ -79,6 +91,386 @@ func TestIntegrationPackageExport(t *testing.T) {
 	}
 }
 
+// cachingGitClient keeps artifacts in memory and counts downloads.
+type cachingGitClient struct {
+	fakeGitClient
+	artifacts map[ArtifactKey][]byte
+	downloads int
+}
+
+func (c *cachingGitClient) GetTarball(fullName string, branch string) (io.ReadCloser, error) {
+	c.downloads++
+	return c.fakeGitClient.GetTarball(fullName, branch)
+}
+
+func (c *cachingGitClient) RestoreArtifact(key ArtifactKey, target string) (bool, error) {
+	content, ok := c.artifacts[key]
+	if !ok {
+		return false, nil
+	}
+	return true, os.WriteFile(target, content, 0o644)
+}
+
+func (c *cachingGitClient) HasArtifact(key ArtifactKey) bool {
+	_, ok := c.artifacts[key]
+	return ok
+}
+
+func (c *cachingGitClient) StoreArtifact(key ArtifactKey, source string, _ string) error {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	c.artifacts[key] = content
+	return nil
+}
+
+func TestIntegrationPackageSharedCache(t *testing.T) {
+	tmp := t.TempDir()
+	tarball := makeTarball(t, map[string]string{
+		"repo-abc/custom_components/demo/manifest.json": testManifest("demo"),
+	})
+	client := &cachingGitClient{
+		fakeGitClient: fakeGitClient{tarballs: map[string][]byte{"foo/demo@v1.0.0": tarball}},
+		artifacts:     map[ArtifactKey][]byte{},
+	}
+	desc := PackageDescription{FullName: "foo/demo", Version: "v1.0.0", Kind: IntegrationKind}
+	for _, storage := range []string{"first", "second"} {
+		root := filepath.Join(tmp, storage)
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewIntegrationPackage(desc, root, client).Setup(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "foo-demo@v1.0.0.tar.gz")); err != nil {
+			t.Fatalf("expected stored artifact in %s: %v", storage, err)
+		}
+	}
+	if client.downloads != 1 {
+		t.Fatalf("expected single download, got %d", client.downloads)
+	}
+	key := ArtifactKey{FullName: "foo/demo", Version: "v1.0.0", Kind: IntegrationKind}
+	if !bytes.Equal(client.artifacts[key], tarball) {
+		t.Fatalf("unexpected cached artifact")
+	}
+}
+
+func TestIntegrationPackageDomainFilters(t *testing.T) {
+	tmp := t.TempDir()
+	tarball := makeTarball(t, map[string]string{
+		"repo-abc/custom_components/alpha/manifest.json": testManifest("alpha"),
+		"repo-abc/custom_components/beta/manifest.json":  testManifest("beta"),
+		"repo-abc/custom_components/gamma/manifest.json": testManifest("gamma"),
+	})
+	client := fakeGitClient{tarballs: map[string][]byte{"foo/multi@v1.0.0": tarball}}
+
+	cases := []struct {
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{nil, nil, []string{"alpha", "beta", "gamma"}},
+		{[]string{"alpha", "gamma"}, nil, []string{"alpha", "gamma"}},
+		{nil, []string{"beta"}, []string{"alpha", "gamma"}},
+	}
+	for i, tc := range cases {
+		root := filepath.Join(tmp, fmt.Sprintf("case-%d", i))
+		desc := PackageDescription{
+			FullName: "foo/multi",
+			Version:  "v1.0.0",
+			Kind:     IntegrationKind,
+			Include:  tc.include,
+			Exclude:  tc.exclude,
+		}
+		pkg := NewIntegrationPackage(desc, root, client)
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := pkg.Setup(); err != nil {
+			t.Fatal(err)
+		}
+		domains, err := pkg.(DomainProvider).Domains()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(domains, ",") != strings.Join(tc.expected, ",") {
+			t.Fatalf("unexpected domains for case %d: %+v", i, domains)
+		}
+		exportDir := filepath.Join(root, "export")
+		if err := os.MkdirAll(exportDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := pkg.Export(exportDir); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(filepath.Join(exportDir, "custom_components"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			exported = append(exported, entry.Name())
+		}
+		if strings.Join(exported, ",") != strings.Join(tc.expected, ",") {
+			t.Fatalf("unexpected exported domains for case %d: %+v", i, exported)
+		}
+	}
+
+	desc := PackageDescription{FullName: "foo/multi", Version: "v1.0.0", Kind: IntegrationKind, Include: []string{"delta"}}
+	pkg := NewIntegrationPackage(desc, filepath.Join(tmp, "case-0"), client)
+	if err := pkg.Export(filepath.Join(tmp, "missing")); err == nil {
+		t.Fatalf("expected missing domain error")
+	}
+}
+
+func TestIntegrationPackageManifests(t *testing.T) {
+	tmp := t.TempDir()
+	tarball := makeTarball(t, map[string]string{
+		"repo-abc/custom_components/beta/manifest.json":         `{"domain":"beta","name":"Beta","codeowners":[],"version":"1.0.0","requirements":["requests==2.31.0"]}`,
+		"repo-abc/custom_components/alpha/manifest.json":        `{"domain":"alpha","name":"Alpha","codeowners":[],"version":"1.0.0","requirements":["aiohttp>=3.8"]}`,
+		"repo-abc/custom_components/gamma/manifest.json":        testManifest("gamma"),
+		"repo-abc/custom_components/alpha/nested/manifest.json": `{"domain":"nested"}`,
+	})
+	client := fakeGitClient{tarballs: map[string][]byte{"foo/multi@v1.0.0": tarball}}
+	desc := PackageDescription{FullName: "foo/multi", Version: "v1.0.0", Kind: IntegrationKind, Exclude: []string{"gamma"}}
+	pkg := NewIntegrationPackage(desc, tmp, client)
+	if err := pkg.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := pkg.(ManifestProvider).Manifests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 || manifests[0].Folder != "alpha" || manifests[1].Folder != "beta" {
+		t.Fatalf("unexpected manifests: %+v", manifests)
+	}
+	if manifests[0].Version != "1.0.0" || strings.Join(manifests[1].Requirements, ",") != "requests==2.31.0" {
+		t.Fatalf("unexpected manifest content: %+v", manifests)
+	}
+}
+
+func TestIntegrationPackageValidation(t *testing.T) {
+	cases := []struct {
+		name     string
+		files    map[string]string
+		exclude  []string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:  "valid",
+			files: map[string]string{"repo-abc/custom_components/demo/manifest.json": testManifest("demo")},
+		},
+		{
+			name:   "missing name and codeowners",
+			files:  map[string]string{"repo-abc/custom_components/demo/manifest.json": `{"domain":"demo","version":"1.0"}`},
+			errors: []string{"demo: required key name is missing", "demo: required key codeowners is missing"},
+		},
+		{
+			name: "missing required keys",
+			files: map[string]string{
+				"repo-abc/custom_components/demo/manifest.json": `{"domain":"other","name":"Demo","codeowners":[]}`,
+				"repo-abc/custom_components/empty/__init__.py":  "",
+			},
+			errors: []string{
+				"demo: domain other does not match folder name",
+				"demo: required key version is missing",
+				"empty: manifest.json is missing",
+			},
+		},
+		{
+			name:   "invalid json",
+			files:  map[string]string{"repo-abc/custom_components/demo/manifest.json": `{`},
+			errors: []string{"demo: parsing demo/manifest.json: unexpected end of JSON input"},
+		},
+		{
+			name:   "no domains",
+			files:  map[string]string{"repo-abc/README.md": "hello"},
+			errors: []string{"custom_components: no integration domains found"},
+		},
+		{
+			name: "excluded dependencies",
+			files: map[string]string{
+				"repo-abc/custom_components/demo/manifest.json": `{"domain":"demo","name":"Demo","version":"1.0","codeowners":[],` +
+					`"dependencies":["http","core"],"after_dependencies":["extra"]}`,
+				"repo-abc/custom_components/core/manifest.json":  testManifest("core"),
+				"repo-abc/custom_components/extra/manifest.json": testManifest("extra"),
+			},
+			exclude:  []string{"core", "extra"},
+			errors:   []string{"demo: depends on core, which is excluded from export"},
+			warnings: []string{"demo: loads after extra, which is excluded from export"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			client := fakeGitClient{tarballs: map[string][]byte{"foo/demo@v1.0.0": makeTarball(t, tc.files)}}
+			desc := PackageDescription{FullName: "foo/demo", Version: "v1.0.0", Kind: IntegrationKind, Exclude: tc.exclude}
+			pkg := NewIntegrationPackage(desc, tmp, client)
+			err := pkg.Setup()
+			var manifestErr *ManifestError
+			if len(tc.errors) > 0 {
+				if !errors.As(err, &manifestErr) {
+					t.Fatalf("expected manifest error, got %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(tmp, "foo-demo@v1.0.0.tar.gz")); !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("expected invalid tarball to be removed: %v", err)
+				}
+				assertIssues(t, manifestErr.Issues, IssueError, tc.errors)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			issues, err := pkg.(Validator).Validate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertIssues(t, issues, IssueWarning, tc.warnings)
+		})
+	}
+}
+
+func TestIntegrationPackageHomeAssistantRequirement(t *testing.T) {
+	tmp := t.TempDir()
+	manifest := func(required string) string {
+		return `{"domain":"demo","name":"Demo","version":"1.0","codeowners":[],"homeassistant":"` + required + `"}`
+	}
+	client := fakeGitClient{tarballs: map[string][]byte{
+		"foo/demo@v1.0.0": makeTarball(t, map[string]string{"repo-abc/custom_components/demo/manifest.json": manifest("2023.1.0")}),
+		"foo/demo@v2.0.0": makeTarball(t, map[string]string{"repo-abc/custom_components/demo/manifest.json": manifest("2024.6.0")}),
+	}}
+	desc := PackageDescription{FullName: "foo/demo", Version: "v1.0.0", Kind: IntegrationKind}
+	pkg := NewIntegrationPackage(desc, tmp, client)
+	pkg.(HomeAssistantConstrained).SetHomeAssistantVersion("2024.1")
+	if err := pkg.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	err := pkg.Switch("v2.0.0")
+	var manifestErr *ManifestError
+	if !errors.As(err, &manifestErr) {
+		t.Fatalf("expected manifest error, got %v", err)
+	}
+	assertIssues(t, manifestErr.Issues, IssueError, []string{"demo: requires Home Assistant 2024.6.0, but 2024.1 is configured"})
+	if pkg.Version() != "v1.0.0" {
+		t.Fatalf("unexpected version after failed switch: %s", pkg.Version())
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "foo-demo@v1.0.0.tar.gz")); err != nil {
+		t.Fatalf("expected installed version to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "foo-demo@v2.0.0.tar.gz")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected incompatible version to be removed: %v", err)
+	}
+}
+
+func assertIssues(t *testing.T, issues []ManifestIssue, severity string, expected []string) {
+	t.Helper()
+	messages := make([]string, 0)
+	for _, issue := range issues {
+		if issue.Severity == severity {
+			messages = append(messages, issue.Domain+": "+issue.Message)
+		}
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected %s issues: %q", severity, messages)
+	}
+}
+
+func TestPythonScriptPackageExport(t *testing.T) {
+	tmp := t.TempDir()
+	tarball := makeTarball(t, map[string]string{
+		"repo-abc/python_scripts/hello.py": "print('hello')",
+		"repo-abc/README.md":               "hello",
+	})
+	client := fakeGitClient{tarballs: map[string][]byte{"foo/scripts@v1.0.0": tarball}}
+	desc := PackageDescription{FullName: "foo/scripts", Version: "v1.0.0", Kind: PythonScriptKind}
+
+	pkg := NewPythonScriptPackage(desc, tmp, client)
+	if err := pkg.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	exportDir := filepath.Join(tmp, "export")
+	if err := PythonScriptPreExport(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.Export(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(exportDir, "python_scripts", "hello.py")); err != nil {
+		t.Fatalf("expected exported script: %v", err)
+	}
+}
+
+func TestAppDaemonPackageExportMergesConfig(t *testing.T) {
+	tmp := t.TempDir()
+	client := fakeGitClient{tarballs: map[string][]byte{
+		"foo/hello@v1.0.0": makeTarball(t, map[string]string{
+			"hello-abc/apps/hello/hello.py":  "import hassapi",
+			"hello-abc/apps/hello/apps.yaml": "hello:\n  module: hello\n  class: Hello\n",
+		}),
+		"foo/bye@v1.0.0": makeTarball(t, map[string]string{
+			"bye-abc/apps/bye/bye.py":    "import hassapi",
+			"bye-abc/apps/bye/apps.yaml": "bye:\n  module: bye\n  class: Bye\n",
+		}),
+	}}
+
+	exportDir := filepath.Join(tmp, "export")
+	if err := AppDaemonPreExport(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo/hello", "foo/bye"} {
+		desc := PackageDescription{FullName: name, Version: "v1.0.0", Kind: AppDaemonKind}
+		pkg := NewAppDaemonPackage(desc, tmp, client)
+		if err := pkg.Setup(); err != nil {
+			t.Fatal(err)
+		}
+		if err := pkg.Export(exportDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := AppDaemonPostExport(exportDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != "bye/apps.yaml" || files[1] != "hello/apps.yaml" {
+		t.Fatalf("unexpected merged fragments: %+v", files)
+	}
+	appsDir := filepath.Join(exportDir, "appdaemon", "apps")
+	if _, err := os.Stat(filepath.Join(appsDir, "hello", "hello.py")); err != nil {
+		t.Fatalf("expected exported app: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(appsDir, "hello", "apps.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected fragment to be removed: %v", err)
+	}
+	merged, err := os.ReadFile(filepath.Join(appsDir, "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, needle := range []string{"# bye/apps.yaml", "class: Bye", "# hello/apps.yaml", "class: Hello"} {
+		if !bytes.Contains(merged, []byte(needle)) {
+			t.Fatalf("missing %q in merged config: %s", needle, string(merged))
+		}
+	}
+}
+
+func TestAppDaemonPostExportDuplicateApp(t *testing.T) {
+	exportDir := t.TempDir()
+	for _, app := range []string{"one", "two"} {
+		dir := filepath.Join(exportDir, "appdaemon", "apps", app)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "apps.yaml"), []byte("same:\n  module: same\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := AppDaemonPostExport(exportDir); err == nil {
+		t.Fatalf("expected duplicate app error")
+	}
+}
+
 func TestPluginPackageFallbackAndExport(t *testing.T) {
 	tmp := t.TempDir()
 	script := []byte("console.log('ok')")
@@ -87,8 +479,11 @@ func TestPluginPackageFallbackAndExport(t *testing.T) {
 		release: map[string][]byte{
 			"foo/lovelace-demo@v1.0.0:demo.js": script,
 		},
+		assets: map[string][]string{
+			"foo/lovelace-demo@v1.0.0": {"demo.js"},
+		},
 	}
-	_, content := setupAndExportPlugin(t, tmp, client)
+	_, content := setupAndExportPlugin(t, tmp, client, "demo.js")
 	if string(content) != string(script) {
 		t.Fatalf("unexpected script content: %s", string(content))
 	}
@@ -102,15 +497,98 @@ func TestPluginPackageBundleReleaseFallbackAndExport(t *testing.T) {
 		release: map[string][]byte{
 			"foo/lovelace-demo@v1.0.0:demo-bundle.js": script,
 		},
+		assets: map[string][]string{
+			"foo/lovelace-demo@v1.0.0": {"demo-bundle.js"},
+		},
 	}
 
-	outputPath, content := setupAndExportPlugin(t, tmp, client)
+	outputPath, content := setupAndExportPlugin(t, tmp, client, "demo-bundle.js")
 	if string(content) != string(script) {
 		t.Fatalf("unexpected script content: %s", string(content))
 	}
-	bundleOutputPath := filepath.Join(filepath.Dir(outputPath), "lovelace-demo-bundle.js")
-	if _, err := os.Stat(bundleOutputPath); !errors.Is(err, os.ErrNotExist) {
-		t.Fatalf("unexpected bundle output file: %v", err)
+	regularOutputPath := filepath.Join(filepath.Dir(outputPath), "demo.js")
+	if _, err := os.Stat(regularOutputPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected regular output file: %v", err)
+	}
+}
+
+func TestPluginPackageReleaseAssets(t *testing.T) {
+	tmp := t.TempDir()
+	script := []byte("import('./chunk-1.js')")
+	client := fakeGitClient{
+		tree: map[string][]byte{},
+		release: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0:demo.js":     script,
+			"foo/lovelace-demo@v1.0.0:chunk-1.js":  []byte("export default 1"),
+			"foo/lovelace-demo@v1.0.0:demo.js.map": []byte("{}"),
+		},
+		assets: map[string][]string{
+			"foo/lovelace-demo@v1.0.0": {"demo.js", "chunk-1.js", "demo.js.map", "source.zip"},
+		},
+	}
+
+	outputPath, _ := setupAndExportPlugin(t, tmp, client, "demo.js")
+	dir := filepath.Dir(outputPath)
+	for _, name := range []string{"chunk-1.js", "demo.js.map"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected exported asset %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "source.zip")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected archive asset: %v", err)
+	}
+}
+
+func TestPluginPackageExportCompressed(t *testing.T) {
+	tmp := t.TempDir()
+	script := []byte(strings.Repeat("customElements.define('demo-card', DemoCard);\n", 100))
+	client := fakeGitClient{
+		tree: map[string][]byte{},
+		release: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0:demo.js":   script,
+			"foo/lovelace-demo@v1.0.0:tiny.js":   []byte("1"),
+			"foo/lovelace-demo@v1.0.0:image.png": []byte(strings.Repeat("x", 1000)),
+		},
+		assets: map[string][]string{
+			"foo/lovelace-demo@v1.0.0": {"demo.js", "tiny.js", "image.png"},
+		},
+	}
+	desc := PackageDescription{FullName: "foo/lovelace-demo", Version: "v1.0.0", Kind: PluginKind}
+	pkg := NewPluginPackage(desc, tmp, client)
+	if err := pkg.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := make([][]byte, 0, 2)
+	for _, name := range []string{"first", "second"} {
+		exportDir := filepath.Join(tmp, name)
+		if err := pkg.(Compressor).ExportCompressed(exportDir); err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(exportDir, "www", "custom_lovelace", "lovelace-demo")
+		content, err := os.ReadFile(filepath.Join(dir, "demo.js.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed = append(compressed, content)
+		for _, skipped := range []string{"tiny.js.gz", "image.png.gz"} {
+			if _, err := os.Stat(filepath.Join(dir, skipped)); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("unexpected compressed file %s: %v", skipped, err)
+			}
+		}
+	}
+	if !bytes.Equal(compressed[0], compressed[1]) {
+		t.Fatalf("compressed files are not deterministic")
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, script) {
+		t.Fatalf("unexpected decompressed content")
 	}
 }
 
@@ -122,12 +600,55 @@ func TestPluginPackageBundleDistFallback(t *testing.T) {
 			"foo/lovelace-demo@v1.0.0:dist/demo-bundle.js": script,
 		},
 		release: map[string][]byte{},
+		tarballs: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0": makeTarball(t, map[string]string{
+				"demo-abc/dist/demo-bundle.js":       string(script),
+				"demo-abc/dist/translations/en.json": "{}",
+				"demo-abc/src/demo.ts":               "",
+			}),
+		},
 	}
 
-	_, content := setupAndExportPlugin(t, tmp, client)
+	outputPath, content := setupAndExportPlugin(t, tmp, client, "demo-bundle.js")
 	if string(content) != string(script) {
 		t.Fatalf("unexpected script content: %s", string(content))
 	}
+	translation := filepath.Join(filepath.Dir(outputPath), "translations", "en.json")
+	if _, err := os.Stat(translation); err != nil {
+		t.Fatalf("expected exported translation: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(outputPath), "demo.ts")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected source file: %v", err)
+	}
+}
+
+func TestPluginPackageDistLimit(t *testing.T) {
+	defaults := archiveLimits
+	t.Cleanup(func() {
+		archiveLimits = defaults
+	})
+	archiveLimits = extractLimits{files: 10, size: 8}
+	tmp := t.TempDir()
+	client := fakeGitClient{
+		tree: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0:dist/demo.js": []byte("demo"),
+		},
+		release: map[string][]byte{},
+		tarballs: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0": makeTarball(t, map[string]string{
+				"demo-abc/dist/demo.js":  "demo",
+				"demo-abc/dist/chunk.js": "export default 1",
+			}),
+		},
+	}
+	desc := PackageDescription{FullName: "foo/lovelace-demo", Version: "v1.0.0", Kind: PluginKind}
+	pkg := NewPluginPackage(desc, tmp, client)
+	if err := pkg.Setup(); !errors.Is(err, errArchiveLimit) {
+		t.Fatalf("expected archive limit error, got %v", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Fatalf("unexpected stored files: %v", entries)
+	}
 }
 
 func TestPluginPackageBundleRootFallback(t *testing.T) {
@@ -140,12 +661,34 @@ func TestPluginPackageBundleRootFallback(t *testing.T) {
 		release: map[string][]byte{},
 	}
 
-	_, content := setupAndExportPlugin(t, tmp, client)
+	_, content := setupAndExportPlugin(t, tmp, client, "demo-bundle.js")
 	if string(content) != string(script) {
 		t.Fatalf("unexpected script content: %s", string(content))
 	}
 }
 
+func TestPluginPackagePrefersReleaseOverRoot(t *testing.T) {
+	tmp := t.TempDir()
+	script := []byte("import('./chunk-1.js')")
+	client := fakeGitClient{
+		tree: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0:demo.js": script,
+		},
+		release: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0:demo.js":    script,
+			"foo/lovelace-demo@v1.0.0:chunk-1.js": []byte("export default 1"),
+		},
+		assets: map[string][]string{
+			"foo/lovelace-demo@v1.0.0": {"demo.js", "chunk-1.js"},
+		},
+	}
+
+	outputPath, _ := setupAndExportPlugin(t, tmp, client, "demo.js")
+	if _, err := os.Stat(filepath.Join(filepath.Dir(outputPath), "chunk-1.js")); err != nil {
+		t.Fatalf("expected exported chunk: %v", err)
+	}
+}
+
 func TestPluginPackagePrefersRegularScriptOverBundle(t *testing.T) {
 	tmp := t.TempDir()
 	regularScript := []byte("console.log('regular')")
@@ -156,12 +699,52 @@ func TestPluginPackagePrefersRegularScriptOverBundle(t *testing.T) {
 			"foo/lovelace-demo@v1.0.0:dist/demo-bundle.js": bundleScript,
 		},
 		release: map[string][]byte{},
+		tarballs: map[string][]byte{
+			"foo/lovelace-demo@v1.0.0": makeTarball(t, map[string]string{
+				"demo-abc/dist/demo.js":        string(regularScript),
+				"demo-abc/dist/demo-bundle.js": string(bundleScript),
+			}),
+		},
 	}
 
-	_, content := setupAndExportPlugin(t, tmp, client)
+	_, content := setupAndExportPlugin(t, tmp, client, "demo.js")
 	if string(content) != string(regularScript) {
 		t.Fatalf("unexpected script content: %s", string(content))
 	}
+	files, err := PluginPostExport(filepath.Join(tmp, "export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "lovelace-demo/demo.js" {
+		t.Fatalf("unexpected entry modules: %+v", files)
+	}
+}
+
+func TestPluginPackageLegacyScriptExport(t *testing.T) {
+	tmp := t.TempDir()
+	script := []byte("console.log('legacy')")
+	if err := os.WriteFile(filepath.Join(tmp, "foo-lovelace-demo@v1.0.0.js"), script, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	desc := PackageDescription{FullName: "foo/lovelace-demo", Version: "v1.0.0", Kind: PluginKind}
+	pkg := NewPluginPackage(desc, tmp, fakeGitClient{})
+	exportDir := filepath.Join(tmp, "export")
+	if err := PluginPreExport(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.Export(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(exportDir, "www", "custom_lovelace", "lovelace-demo", "demo.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != string(script) {
+		t.Fatalf("unexpected script content: %s", string(content))
+	}
+	if err := pkg.Destroy(); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func TestPluginPackageNotFound(t *testing.T) {
@@ -174,7 +757,63 @@ func TestPluginPackageNotFound(t *testing.T) {
 	}
 }
 
-func setupAndExportPlugin(t *testing.T, tmp string, client fakeGitClient) (string, []byte) {
+func TestWriteLovelaceResources(t *testing.T) {
+	tmp := t.TempDir()
+	resources := PluginResources(
+		[]string{"lovelace-demo/demo.js", "other/other-bundle.js"},
+		map[string]string{"lovelace-demo": "v1.2.0"},
+	)
+	path, err := WriteLovelaceResources(tmp, ResourcesYAML, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmp, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "lovelace:\n" +
+		"    resources:\n" +
+		"        - url: /local/custom_lovelace/lovelace-demo/demo.js?v=v1.2.0\n" +
+		"          type: module\n" +
+		"        - url: /local/custom_lovelace/other/other-bundle.js\n" +
+		"          type: module\n"
+	if string(content) != expected {
+		t.Fatalf("unexpected yaml resources:\n%s", content)
+	}
+
+	path, err = WriteLovelaceResources(tmp, ResourcesStorage, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != ".storage/lovelace_resources" {
+		t.Fatalf("unexpected storage path: %s", path)
+	}
+	content, err = os.ReadFile(filepath.Join(tmp, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var storage lovelaceStorage
+	if err := json.Unmarshal(content, &storage); err != nil {
+		t.Fatal(err)
+	}
+	items := storage.Data.Items
+	if storage.Key != "lovelace_resources" || len(items) != 2 || items[0].Type != "module" || len(items[0].ID) != 32 {
+		t.Fatalf("unexpected storage resources: %s", content)
+	}
+	if !strings.Contains(string(content), `"type": "module"`) {
+		t.Fatalf("expected resource type to be stored in type key: %s", content)
+	}
+	updated := PluginResources([]string{"lovelace-demo/demo.js"}, map[string]string{"lovelace-demo": "v2.0.0"})
+	if newLovelaceStorage(updated).Data.Items[0].ID != items[0].ID {
+		t.Fatalf("expected resource id to be stable across versions")
+	}
+
+	if _, err := WriteLovelaceResources(tmp, "toml", resources); err == nil {
+		t.Fatalf("expected unsupported format error")
+	}
+}
+
+func setupAndExportPlugin(t *testing.T, tmp string, client fakeGitClient, entry string) (string, []byte) {
 	t.Helper()
 
 	desc := PackageDescription{FullName: "foo/lovelace-demo", Version: "v1.0.0", Kind: PluginKind}
@@ -189,7 +828,7 @@ func setupAndExportPlugin(t *testing.T, tmp string, client fakeGitClient) (strin
 	if err := pkg.Export(exportDir); err != nil {
 		t.Fatal(err)
 	}
-	outputPath := filepath.Join(exportDir, "www", "custom_lovelace", "lovelace-demo.js")
+	outputPath := filepath.Join(exportDir, "www", "custom_lovelace", "lovelace-demo", entry)
 	content, err := os.ReadFile(outputPath)
 	if err != nil {
 		t.Fatal(err)
@@ -197,6 +836,10 @@ func setupAndExportPlugin(t *testing.T, tmp string, client fakeGitClient) (strin
 	return outputPath, content
 }
 
+func testManifest(domain string) string {
+	return fmt.Sprintf(`{"domain":%q,"name":"Test","version":"1.0.0","codeowners":["@test"]}`, domain)
+}
+
 func makeTarball(t *testing.T, files map[string]string) []byte {
 	t.Helper()
 	buffer := bytes.NewBuffer(nil)
@@ -226,3 +869,82 @@ func makeTarball(t *testing.T, files map[string]string) []byte {
 	}
 	return buffer.Bytes()
 }
+
+// failingReader returns content and then fails.
+type failingReader struct {
+	reader io.Reader
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func (r failingReader) Close() error { return nil }
+
+type streamGitClient struct {
+	fakeGitClient
+	stream io.ReadCloser
+}
+
+func (c streamGitClient) GetTarball(string, string) (io.ReadCloser, error) {
+	return c.stream, nil
+}
+
+func TestMeteredClient(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"repo-abc/custom_components/demo/manifest.json": testManifest("demo"),
+	})
+	client := fakeGitClient{tarballs: map[string][]byte{"foo/demo@v1.0.0": tarball}}
+	received := map[string]int64{}
+	metered := NewMeteredClient(client, DownloadOptions{
+		Progress: func(fullName string, n int64) {
+			received[fullName] += n
+		},
+	})
+	desc := PackageDescription{FullName: "foo/demo", Version: "v1.0.0", Kind: IntegrationKind}
+	if err := NewIntegrationPackage(desc, t.TempDir(), metered).Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if received["foo/demo"] != int64(len(tarball)) {
+		t.Fatalf("unexpected progress: %v, want %d bytes", received, len(tarball))
+	}
+
+	tmp := t.TempDir()
+	limited := NewMeteredClient(client, DownloadOptions{MaxSize: int64(len(tarball)) - 1})
+	err := NewIntegrationPackage(desc, tmp, limited).Setup()
+	if !errors.Is(err, ErrDownloadLimit) {
+		t.Fatalf("expected download limit error, got %v", err)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected no files after failed download: %v, %v", entries, err)
+	}
+}
+
+func TestDownloadFailureKeepsStoredArtifact(t *testing.T) {
+	tmp := t.TempDir()
+	tarball := makeTarball(t, map[string]string{
+		"repo-abc/custom_components/demo/manifest.json": testManifest("demo"),
+	})
+	target := filepath.Join(tmp, "foo-demo@v1.0.0.tar.gz")
+	if err := os.WriteFile(target, []byte("stored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := streamGitClient{stream: failingReader{reader: bytes.NewReader(tarball)}}
+	desc := PackageDescription{FullName: "foo/demo", Version: "v1.0.0", Kind: IntegrationKind}
+	pkg := NewIntegrationPackage(desc, tmp, client)
+	if err := pkg.Setup(); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("expected stream error, got %v", err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "stored" {
+		t.Fatalf("stored artifact was changed: %q, %v", content, err)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected temporary file to be removed: %v, %v", entries, err)
+	}
+}
@@ -11,19 +11,24 @@ const PluginKind = "plugins"
 
 const pluginFolderName = "www/custom_lovelace"
 
+// legacyPluginExtension is used by storages created before plugins were
+// stored as archives of every file the card needs.
+const legacyPluginExtension = "js"
+
+var pluginSkippedAssets = []string{".zip", ".tar.gz", ".tgz"}
+
 type PluginPackage struct {
 	base BasePackage
 }
 
 func NewPluginPackage(description PackageDescription, rootPath string, client GitClient) Package {
-	return &PluginPackage{base: newBasePackage(description, rootPath, "js", PluginKind, client)}
+	return &PluginPackage{base: newBasePackage(description, rootPath, "tar.gz", PluginKind, client)}
 }
 
 func (p *PluginPackage) Description() PackageDescription { return p.base.Description() }
 func (p *PluginPackage) FullName() string                { return p.base.FullName() }
 func (p *PluginPackage) Version() string                 { return p.base.Version() }
 func (p *PluginPackage) Kind() string                    { return p.base.Kind() }
-func (p *PluginPackage) Destroy() error                  { return p.base.Destroy() }
 func (p *PluginPackage) LatestVersion(stableOnly bool) (string, error) {
 	return p.base.LatestVersion(stableOnly)
 }
@@ -32,72 +37,188 @@ func (p *PluginPackage) Setup() error {
 	if p.base.version == "latest" {
 		return fmt.Errorf("version is unknown")
 	}
-	return p.downloadScript(p.base.version)
+	return p.downloadFiles(p.base.version)
 }
 
 func (p *PluginPackage) Switch(version string) error {
-	if err := p.downloadScript(version); err != nil {
+	if err := p.downloadFiles(version); err != nil {
 		return err
 	}
-	if err := os.Remove(p.base.Path("")); err != nil {
+	if err := os.Remove(p.storedPath("")); err != nil {
 		return err
 	}
 	p.base.version = version
 	return nil
 }
 
+func (p *PluginPackage) Destroy() error {
+	return os.Remove(p.storedPath(""))
+}
+
 func (p *PluginPackage) Export(path string) error {
-	target := filepath.Join(path, pluginFolderName, p.base.name+".js")
-	content, err := os.ReadFile(p.base.Path(""))
+	target := filepath.Join(path, pluginFolderName, p.base.name)
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+	stored := p.storedPath("")
+	if stored == p.base.Path("") {
+		return extractAll(stored, target)
+	}
+	content, err := os.ReadFile(stored)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(target, content, 0o644)
+	return os.WriteFile(filepath.Join(target, pluginEntryNames(p.base.name)[0]), content, 0o644)
 }
 
 func PluginPreExport(path string) error {
 	return os.MkdirAll(filepath.Join(path, pluginFolderName), 0o755)
 }
 
+// PluginPostExport returns entry modules of exported plugins relative
+// to the plugins folder.
 func PluginPostExport(path string) ([]string, error) {
-	entries, err := os.ReadDir(filepath.Join(path, pluginFolderName))
+	root := filepath.Join(path, pluginFolderName)
+	entries, err := os.ReadDir(root)
 	if err != nil {
 		return nil, err
 	}
-	names := make([]string, len(entries))
-	for i, e := range entries {
-		names[i] = e.Name()
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+			continue
+		}
+		for _, entry := range pluginEntryNames(e.Name()) {
+			if _, err := os.Stat(filepath.Join(root, e.Name(), entry)); err == nil {
+				names = append(names, e.Name()+"/"+entry)
+				break
+			}
+		}
 	}
 	return names, nil
 }
 
-func (p *PluginPackage) downloadScript(version string) error {
-	content, err := p.getScript(version)
-	if err != nil {
-		return err
+// storedPath returns location of the stored plugin, falling back to
+// the legacy single-script layout if it is the only one present.
+func (p *PluginPackage) storedPath(version string) string {
+	archivePath := p.base.Path(version)
+	if _, err := os.Stat(archivePath); err == nil {
+		return archivePath
 	}
-	return os.WriteFile(p.base.Path(version), content, 0o644)
+	legacyPath := strings.TrimSuffix(archivePath, p.base.extension) + legacyPluginExtension
+	if _, err := os.Stat(legacyPath); err == nil {
+		return legacyPath
+	}
+	return archivePath
 }
 
-func (p *PluginPackage) getScript(version string) ([]byte, error) {
-	pluginName := strings.TrimPrefix(p.base.name, "lovelace-")
-	pluginFiles := []string{
-		pluginName + ".js",
-		pluginName + "-bundle.js",
-	}
-	for _, pluginFile := range pluginFiles {
+func (p *PluginPackage) downloadFiles(version string) error {
+	return p.base.materialize(version, func(path string) (string, error) {
+		stage, err := os.MkdirTemp("", "hapm-plugin-")
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			_ = os.RemoveAll(stage)
+		}()
+		if err := p.getFiles(version, stage); err != nil {
+			return "", err
+		}
+		return writeArchive(path, stage)
+	})
+}
+
+// getFiles looks for the entry module and places it into stage together
+// with files that are shipped next to it: the dist folder of the
+// repository or release assets. An entry module in the repository root
+// is used alone, so it is the last resort.
+func (p *PluginPackage) getFiles(version string, stage string) error {
+	for _, pluginFile := range pluginEntryNames(p.base.name) {
 		content, err := p.base.client.GetTreeFile(p.base.fullName, version, "dist/"+pluginFile)
 		if err == nil && len(content) > 0 {
-			return content, nil
+			return p.getDistFiles(version, stage, pluginFile, content)
+		}
+		size, err := p.getReleaseFile(version, stage, pluginFile)
+		if err == nil && size > 0 {
+			return p.getReleaseFiles(version, stage, pluginFile)
 		}
+		_ = os.Remove(filepath.Join(stage, pluginFile))
 		content, err = p.base.client.GetTreeFile(p.base.fullName, version, pluginFile)
 		if err == nil && len(content) > 0 {
-			return content, nil
+			return os.WriteFile(filepath.Join(stage, pluginFile), content, 0o644)
 		}
-		content, err = p.base.client.GetReleaseFile(p.base.fullName, version, pluginFile)
-		if err == nil && len(content) > 0 {
-			return content, nil
+	}
+	return fmt.Errorf("plugin script is not found: %s@%s", p.base.fullName, version)
+}
+
+func (p *PluginPackage) getDistFiles(version string, stage string, entry string, content []byte) error {
+	tarball, err := p.base.client.GetTarball(p.base.fullName, version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tarball.Close()
+	}()
+	if err := unpackFiles(tarball, stage, "dist"); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stage, entry), content, 0o644)
+}
+
+func (p *PluginPackage) getReleaseFiles(version string, stage string, entry string) error {
+	assets, err := p.base.client.GetReleaseAssets(p.base.fullName, version)
+	if err != nil {
+		return err
+	}
+	for _, asset := range assets {
+		if asset == entry || isSkippedAsset(asset) {
+			continue
 		}
+		if _, err := p.getReleaseFile(version, stage, asset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getReleaseFile streams the release asset into stage and returns its size.
+func (p *PluginPackage) getReleaseFile(version string, stage string, name string) (int64, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return 0, fmt.Errorf("%w: %s", errUnsafeEntry, name)
+	}
+	target := filepath.Join(stage, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return 0, err
+	}
+	stream, err := p.base.client.GetReleaseFile(p.base.fullName, version, name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := writeStream(target, stream); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func isSkippedAsset(name string) bool {
+	for _, suffix := range pluginSkippedAssets {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginEntryNames returns candidate entry module names in order of preference.
+func pluginEntryNames(name string) []string {
+	pluginName := strings.TrimPrefix(name, "lovelace-")
+	return []string{
+		pluginName + ".js",
+		pluginName + "-bundle.js",
 	}
-	return nil, fmt.Errorf("plugin script is not found: %s@%s", p.base.fullName, version)
 }
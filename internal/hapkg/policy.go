@@ -0,0 +1,96 @@
+package hapkg
+
+import (
+	"fmt"
+	"path"
+)
+
+// UpdatePolicy limits versions a package may be updated to.
+type UpdatePolicy struct {
+	// Hold freezes the package at the installed version.
+	Hold bool
+	// IgnoreVersions are tags or glob patterns of tags that are never
+	// picked, like v2.0.0 or v3.*.
+	IgnoreVersions []string
+	// Allow is the widest update level. Empty value allows any update.
+	Allow string
+	// AllowUnstable lets updates pick unstable releases of the package.
+	AllowUnstable bool
+}
+
+// IsZero reports whether the policy doesn't limit anything.
+func (p UpdatePolicy) IsZero() bool {
+	return !p.Hold && len(p.IgnoreVersions) == 0 && p.Allow == "" && !p.AllowUnstable
+}
+
+// StableOnly reports whether only stable versions may be picked when
+// stableOnly is requested for every package.
+func (p UpdatePolicy) StableOnly(stableOnly bool) bool {
+	return stableOnly && !p.AllowUnstable
+}
+
+// Ignores reports whether the tag is ignored by the policy.
+func (p UpdatePolicy) Ignores(tag string) bool {
+	for _, pattern := range p.IgnoreVersions {
+		if matched, err := path.Match(pattern, tag); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Level returns the stricter of the policy level and the requested one.
+func (p UpdatePolicy) Level(level string) string {
+	return StricterLevel(p.Allow, level)
+}
+
+// Filter returns tags the package may be updated to from the current
+// version within the level. If the current version is empty, levels are
+// not applied.
+func (p UpdatePolicy) Filter(current string, tags []string, level string) []string {
+	level = p.Level(level)
+	var currentVersion *Version
+	if current != "" && level != UpdateMajor {
+		parsed, err := NewVersion(current)
+		if err != nil {
+			return nil
+		}
+		currentVersion = &parsed
+	}
+	filtered := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if p.Ignores(tag) {
+			continue
+		}
+		if currentVersion != nil {
+			version, err := NewVersion(tag)
+			if err != nil || !version.WithinLevel(*currentVersion, level) {
+				continue
+			}
+		}
+		filtered = append(filtered, tag)
+	}
+	return filtered
+}
+
+// StricterLevel returns the narrower of update levels. Empty level allows
+// any update.
+func StricterLevel(left string, right string) string {
+	rank := map[string]int{UpdatePatch: 0, UpdateMinor: 1, UpdateMajor: 2, "": 2}
+	if rank[left] < rank[right] {
+		return left
+	}
+	if right == "" {
+		return UpdateMajor
+	}
+	return right
+}
+
+// ValidateLevel returns an error if the level is unknown.
+func ValidateLevel(level string) error {
+	switch level {
+	case UpdatePatch, UpdateMinor, UpdateMajor:
+		return nil
+	}
+	return fmt.Errorf("unknown update level %s, expected patch, minor or major", level)
+}
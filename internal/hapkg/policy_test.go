@@ -0,0 +1,43 @@
+package hapkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpdatePolicyFilter(t *testing.T) {
+	tags := []string{"v1.2.3", "v1.2.4", "v1.3.0", "v2.0.0", "v2.1.0"}
+	cases := []struct {
+		policy   UpdatePolicy
+		level    string
+		expected string
+	}{
+		{UpdatePolicy{}, UpdateMajor, "v1.2.3 v1.2.4 v1.3.0 v2.0.0 v2.1.0"},
+		{UpdatePolicy{Allow: UpdateMinor}, UpdateMajor, "v1.2.3 v1.2.4 v1.3.0"},
+		{UpdatePolicy{Allow: UpdateMinor}, UpdatePatch, "v1.2.3 v1.2.4"},
+		{UpdatePolicy{IgnoreVersions: []string{"v1.2.4", "v2.*"}}, UpdateMajor, "v1.2.3 v1.3.0"},
+	}
+	for _, tc := range cases {
+		got := strings.Join(tc.policy.Filter("v1.2.3", tags, tc.level), " ")
+		if got != tc.expected {
+			t.Fatalf("unexpected tags for %+v within %s: %s", tc.policy, tc.level, got)
+		}
+	}
+	if got := (UpdatePolicy{Allow: UpdatePatch}).Filter("", tags, UpdateMajor); len(got) != len(tags) {
+		t.Fatalf("levels are applied without current version: %v", got)
+	}
+}
+
+func TestStricterLevel(t *testing.T) {
+	cases := [][3]string{
+		{"", "", UpdateMajor},
+		{"", UpdateMinor, UpdateMinor},
+		{UpdatePatch, UpdateMajor, UpdatePatch},
+		{UpdateMinor, UpdatePatch, UpdatePatch},
+	}
+	for _, tc := range cases {
+		if got := StricterLevel(tc[0], tc[1]); got != tc[2] {
+			t.Fatalf("unexpected stricter level of %q and %q: %s", tc[0], tc[1], got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package hapkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const PythonScriptKind = "python_scripts"
+const pythonScriptFolderName = "python_scripts"
+
+type PythonScriptPackage struct {
+	base BasePackage
+}
+
+func NewPythonScriptPackage(description PackageDescription, rootPath string, client GitClient) Package {
+	return &PythonScriptPackage{base: newBasePackage(description, rootPath, "tar.gz", PythonScriptKind, client)}
+}
+
+func (p *PythonScriptPackage) Description() PackageDescription { return p.base.Description() }
+func (p *PythonScriptPackage) FullName() string                { return p.base.FullName() }
+func (p *PythonScriptPackage) Version() string                 { return p.base.Version() }
+func (p *PythonScriptPackage) Kind() string                    { return p.base.Kind() }
+func (p *PythonScriptPackage) Destroy() error                  { return p.base.Destroy() }
+func (p *PythonScriptPackage) LatestVersion(stableOnly bool) (string, error) {
+	return p.base.LatestVersion(stableOnly)
+}
+
+func (p *PythonScriptPackage) Setup() error {
+	if p.base.version == "latest" {
+		return fmt.Errorf("version is unknown")
+	}
+	return p.base.downloadTarball(p.base.version)
+}
+
+func (p *PythonScriptPackage) Switch(version string) error {
+	if err := p.base.downloadTarball(version); err != nil {
+		return err
+	}
+	if err := os.Remove(p.base.Path("")); err != nil {
+		return err
+	}
+	p.base.version = version
+	return nil
+}
+
+func (p *PythonScriptPackage) Export(dest string) error {
+	return extractFolder(p.base.Path(""), dest, pythonScriptFolderName, pythonScriptFolderName, nil)
+}
+
+func PythonScriptPreExport(path string) error {
+	return os.MkdirAll(filepath.Join(path, pythonScriptFolderName), 0o755)
+}
+
+func PythonScriptPostExport(_ string) ([]string, error) {
+	return nil, nil
+}
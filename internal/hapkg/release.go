@@ -0,0 +1,54 @@
+package hapkg
+
+import (
+	"sort"
+	"time"
+)
+
+// Release is a published release of a package repository.
+type Release struct {
+	Tag         string
+	Name        string
+	Body        string
+	PublishedAt time.Time
+}
+
+// ReleaseProvider is implemented by clients that can read release notes.
+// GetReleases returns at least every release newer than the since version.
+type ReleaseProvider interface {
+	GetReleases(fullName string, since string) ([]Release, error)
+}
+
+// ReleasesBetween returns releases newer than the from version up to and
+// including the to version, newest first. Releases with tags that are not
+// versions are skipped.
+func ReleasesBetween(releases []Release, from string, to string) []Release {
+	fromVersion, fromErr := NewVersion(from)
+	toVersion, err := NewVersion(to)
+	if err != nil {
+		return nil
+	}
+	type versioned struct {
+		release Release
+		version Version
+	}
+	selected := make([]versioned, 0)
+	for _, release := range releases {
+		version, err := NewVersion(release.Tag)
+		if err != nil || version.Compare(toVersion) > 0 {
+			continue
+		}
+		if fromErr == nil && version.Compare(fromVersion) <= 0 {
+			continue
+		}
+		selected = append(selected, versioned{release: release, version: version})
+	}
+	sort.SliceStable(selected, func(i int, j int) bool {
+		return selected[i].version.Compare(selected[j].version) > 0
+	})
+	result := make([]Release, 0, len(selected))
+	for _, item := range selected {
+		result = append(result, item.release)
+	}
+	return result
+}
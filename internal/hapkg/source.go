@@ -0,0 +1,62 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+)
+
+// Source is a stored package version in the form git hosting serves it.
+type Source struct {
+	// Tarball is the path of the stored repository tarball.
+	Tarball string
+	// Assets are release files keyed by asset name.
+	Assets map[string][]byte
+}
+
+// SourceProvider is implemented by packages whose stored artifact can be
+// served again by a mirror.
+type SourceProvider interface {
+	Source() (Source, error)
+}
+
+func (p *IntegrationPackage) Source() (Source, error)  { return p.base.source() }
+func (p *PythonScriptPackage) Source() (Source, error) { return p.base.source() }
+func (p *AppDaemonPackage) Source() (Source, error)    { return p.base.source() }
+
+// Source returns files of the stored plugin as release assets, which is
+// the last place the plugin lookup checks.
+func (p *PluginPackage) Source() (Source, error) {
+	stored := p.storedPath("")
+	if stored != p.base.Path("") {
+		content, err := os.ReadFile(stored)
+		if err != nil {
+			return Source{}, err
+		}
+		return Source{Assets: map[string][]byte{pluginEntryNames(p.base.name)[0]: content}}, nil
+	}
+	assets := map[string][]byte{}
+	err := walkTarball(stored, func(header *tar.Header, reader io.Reader) error {
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		assets[header.Name] = content
+		return nil
+	})
+	if err != nil {
+		return Source{}, err
+	}
+	return Source{Assets: assets}, nil
+}
+
+func (b *BasePackage) source() (Source, error) {
+	path := b.Path("")
+	if _, err := os.Stat(path); err != nil {
+		return Source{}, err
+	}
+	return Source{Tarball: path}, nil
+}
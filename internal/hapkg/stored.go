@@ -0,0 +1,104 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// StoredPackage is implemented by packages that are kept in the storage
+// as a single artifact.
+type StoredPackage interface {
+	// ArtifactPath returns location of the stored artifact.
+	ArtifactPath() string
+	// CheckArtifact reads the stored artifact completely and verifies that
+	// it has the layout the package kind expects.
+	CheckArtifact() error
+}
+
+func (p *IntegrationPackage) ArtifactPath() string  { return p.base.Path("") }
+func (p *PythonScriptPackage) ArtifactPath() string { return p.base.Path("") }
+func (p *AppDaemonPackage) ArtifactPath() string    { return p.base.Path("") }
+func (p *PluginPackage) ArtifactPath() string       { return p.storedPath("") }
+
+func (p *IntegrationPackage) CheckArtifact() error {
+	return checkTarball(p.base.Path(""), integrationFolderName)
+}
+
+func (p *PythonScriptPackage) CheckArtifact() error {
+	return checkTarball(p.base.Path(""), pythonScriptFolderName)
+}
+
+func (p *AppDaemonPackage) CheckArtifact() error {
+	return checkTarball(p.base.Path(""), appDaemonSourceFolder)
+}
+
+// CheckArtifact verifies that the stored plugin contains its entry module.
+func (p *PluginPackage) CheckArtifact() error {
+	stored := p.storedPath("")
+	if stored != p.base.Path("") {
+		info, err := os.Stat(stored)
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			return fmt.Errorf("plugin script is empty")
+		}
+		return nil
+	}
+	if err := checkGzip(stored); err != nil {
+		return err
+	}
+	entries := pluginEntryNames(p.base.name)
+	found := false
+	err := walkTarball(stored, func(header *tar.Header, _ io.Reader) error {
+		found = found || slices.Contains(entries, header.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("entry module %s is missing", entries[0])
+	}
+	return nil
+}
+
+// checkTarball verifies that the tarball is intact and has entries under
+// the folder.
+func checkTarball(archivePath string, folder string) error {
+	if err := checkGzip(archivePath); err != nil {
+		return err
+	}
+	names, err := listFolder(archivePath, folder)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%s folder is missing", folder)
+	}
+	return nil
+}
+
+// checkGzip reads the gzip stream to the end, which verifies its checksum.
+func checkGzip(archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	_, err = io.Copy(io.Discard, gz)
+	return err
+}
@@ -0,0 +1,226 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// walkTarball calls fn for every entry of the gzip-compressed tarball.
+func walkTarball(archivePath string, fn func(header *tar.Header, reader io.Reader) error) (err error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return walkStream(file, fn)
+}
+
+// walkStream calls fn for every entry of the gzip-compressed tarball stream.
+func walkStream(archive io.Reader, fn func(header *tar.Header, reader io.Reader) error) (err error) {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := gz.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// extractFolder unpacks files located under srcFolder of the tarball
+// into dstFolder inside dest. If accept is set, only entries whose path
+// relative to srcFolder is accepted are unpacked.
+func extractFolder(
+	archivePath string,
+	dest string,
+	srcFolder string,
+	dstFolder string,
+	accept func(rel string) bool,
+) error {
+	ex := newExtractor(filepath.Join(dest, dstFolder))
+	err := walkTarball(archivePath, func(header *tar.Header, reader io.Reader) error {
+		rel, ok := folderPath(header.Name, srcFolder)
+		if !ok || (accept != nil && !accept(rel)) {
+			return nil
+		}
+		linkname := header.Linkname
+		if header.Typeflag == tar.TypeLink {
+			linkname, ok = folderPath(linkname, srcFolder)
+			if !ok {
+				return fmt.Errorf("%w: hardlink %s points outside of %s", errUnsafeEntry, header.Name, srcFolder)
+			}
+		}
+		return ex.extract(header, reader, rel, linkname)
+	})
+	if err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+// listFolder returns names of top-level entries located under srcFolder
+// of the tarball.
+func listFolder(archivePath string, srcFolder string) ([]string, error) {
+	seen := map[string]bool{}
+	names := make([]string, 0)
+	err := walkTarball(archivePath, func(header *tar.Header, _ io.Reader) error {
+		rel, ok := folderPath(header.Name, srcFolder)
+		if !ok || rel == "" {
+			return nil
+		}
+		name := strings.SplitN(rel, "/", 2)[0]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// folderPath returns entry path relative to srcFolder. The folder must be
+// located right under the top-level directory of the archive, so folders
+// with the same name deeper in the tree are ignored.
+func folderPath(name string, srcFolder string) (string, bool) {
+	_, rest, ok := strings.Cut(strings.TrimPrefix(filepath.ToSlash(name), "./"), "/")
+	if !ok {
+		return "", false
+	}
+	rel, ok := strings.CutPrefix(rest, srcFolder+"/")
+	if !ok {
+		return "", false
+	}
+	return rel, true
+}
+
+// extractAll unpacks every entry of the tarball into dest.
+func extractAll(archivePath string, dest string) error {
+	ex := newExtractor(dest)
+	err := walkTarball(archivePath, func(header *tar.Header, reader io.Reader) error {
+		return ex.extract(header, reader, header.Name, header.Linkname)
+	})
+	if err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+// unpackFiles unpacks regular files located under srcFolder of the
+// tarball stream into dest.
+func unpackFiles(archive io.Reader, dest string, srcFolder string) error {
+	ex := newExtractor(dest)
+	err := walkStream(archive, func(header *tar.Header, reader io.Reader) error {
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		rel, ok := folderPath(header.Name, srcFolder)
+		if !ok {
+			return nil
+		}
+		return ex.extract(header, reader, rel, "")
+	})
+	if err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+// writeArchive stores regular files of root as a gzip-compressed tarball
+// with entries sorted by name and returns digest of the archive. Files are
+// streamed into the archive, which is streamed to path.
+func writeArchive(path string, root string) (string, error) {
+	names := make([]string, 0)
+	err := filepath.WalkDir(root, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(names)
+
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(writeTarball(writer, root, names))
+	}()
+	return writeStream(path, reader)
+}
+
+// writeTarball writes named files of root to out as a gzip-compressed tarball.
+func writeTarball(out io.Writer, root string, names []string) error {
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		if err := addFile(tw, filepath.Join(root, filepath.FromSlash(name)), name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, source string, name string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0o644,
+		Size:     info.Size(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,42 @@
+package hapkg
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestFolderPath(t *testing.T) {
+	cases := []struct {
+		name   string
+		folder string
+		rel    string
+		ok     bool
+	}{
+		{name: "repo/apps/demo/app.py", folder: "apps", rel: "demo/app.py", ok: true},
+		{name: "./repo/apps/demo/app.py", folder: "apps", rel: "demo/app.py", ok: true},
+		{name: "repo/apps/", folder: "apps", rel: "", ok: true},
+		{name: "repo/docs/apps/demo.md", folder: "apps", ok: false},
+		{name: "repo/apps.md", folder: "apps", ok: false},
+		{name: "apps/demo/app.py", folder: "apps", ok: false},
+		{name: "repo/www/custom_lovelace/card.js", folder: "www/custom_lovelace", rel: "card.js", ok: true},
+		{name: "repo/src/www/custom_lovelace/card.js", folder: "www/custom_lovelace", ok: false},
+	}
+	for _, c := range cases {
+		rel, ok := folderPath(c.name, c.folder)
+		if ok != c.ok || rel != c.rel {
+			t.Fatalf("unexpected path of %s in %s: %q, %v", c.name, c.folder, rel, ok)
+		}
+	}
+
+	archive := writeTestTarball(t, t.TempDir(), []tarEntry{
+		{header: tar.Header{Name: "repo/custom_components/demo/__init__.py", Typeflag: tar.TypeReg}},
+		{header: tar.Header{Name: "repo/tests/custom_components/fixture/__init__.py", Typeflag: tar.TypeReg}},
+	})
+	names, err := listFolder(archive, integrationFolderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "demo" {
+		t.Fatalf("unexpected folder entries: %v", names)
+	}
+}
@@ -1,11 +1,16 @@
 package hapkg
 
-import "strings"
+import (
+	"slices"
+	"strings"
+)
 
 type PackageDescription struct {
-	FullName string `json:"full_name" yaml:"full_name"`
-	Version  string `json:"version" yaml:"version"`
-	Kind     string `json:"kind" yaml:"kind"`
+	FullName string   `json:"full_name" yaml:"full_name"`
+	Version  string   `json:"version" yaml:"version"`
+	Kind     string   `json:"kind" yaml:"kind"`
+	Include  []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude  []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
 }
 
 func (d PackageDescription) Copy() PackageDescription {
@@ -13,9 +18,30 @@ func (d PackageDescription) Copy() PackageDescription {
 		FullName: d.FullName,
 		Version:  d.Version,
 		Kind:     d.Kind,
+		Include:  append([]string(nil), d.Include...),
+		Exclude:  append([]string(nil), d.Exclude...),
 	}
 }
 
+// HasOptions reports whether description narrows down the exported content.
+func (d PackageDescription) HasOptions() bool {
+	return len(d.Include) > 0 || len(d.Exclude) > 0
+}
+
+// SameOptions reports whether both descriptions have equal export options.
+func (d PackageDescription) SameOptions(other PackageDescription) bool {
+	return slices.Equal(d.Include, other.Include) && slices.Equal(d.Exclude, other.Exclude)
+}
+
+// Allows reports whether the item with the given name passes include
+// and exclude filters.
+func (d PackageDescription) Allows(name string) bool {
+	if len(d.Include) > 0 && !slices.Contains(d.Include, name) {
+		return false
+	}
+	return !slices.Contains(d.Exclude, name)
+}
+
 func (d PackageDescription) ShortName() string {
 	i := strings.LastIndex(d.FullName, "/")
 	if i < 0 {
@@ -145,6 +145,65 @@ func (v Version) Compare(other Version) int {
 	return 0
 }
 
+// CompareRelease compares versions padding release segments with zeros,
+// so 2024.6 equals 2024.6.0. Suffixes are compared only for equal
+// release segments.
+func (v Version) CompareRelease(other Version) int {
+	size := len(v.Value)
+	if len(other.Value) > size {
+		size = len(other.Value)
+	}
+	for i := 0; i < size; i++ {
+		left, right := 0, 0
+		if i < len(v.Value) {
+			left = v.Value[i]
+		}
+		if i < len(other.Value) {
+			right = other.Value[i]
+		}
+		if left != right {
+			if left < right {
+				return -1
+			}
+			return 1
+		}
+	}
+	return Version{Suffix: v.Suffix}.Compare(Version{Suffix: other.Suffix})
+}
+
+// Update levels limit how far a package may be updated.
+const (
+	UpdatePatch = "patch"
+	UpdateMinor = "minor"
+	UpdateMajor = "major"
+)
+
+// WithinLevel reports whether an update from current to the version stays
+// within the level. Patch updates keep major and minor segments, minor
+// updates keep the major one. Missing segments are treated as zeros.
+func (v Version) WithinLevel(current Version, level string) bool {
+	kept := 0
+	switch level {
+	case UpdatePatch:
+		kept = 2
+	case UpdateMinor:
+		kept = 1
+	}
+	for i := 0; i < kept; i++ {
+		if v.segment(i) != current.segment(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v Version) segment(index int) int {
+	if index < len(v.Value) {
+		return v.Value[index]
+	}
+	return 0
+}
+
 func FindLatestVersion(tags []string, stableOnly bool) string {
 	latest, _ := NewVersion("0.0.0")
 	versions := make([]Version, 0, len(tags))
@@ -1,6 +1,9 @@
 package hapkg
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestParseVersion(t *testing.T) {
 	cases := []struct {
@@ -78,6 +81,46 @@ func TestCompareVersions(t *testing.T) {
 	}
 }
 
+func TestCompareRelease(t *testing.T) {
+	cases := []struct {
+		left     string
+		right    string
+		expected int
+	}{
+		{"2024.6", "2024.6.0", 0},
+		{"2024.6.0b1", "2024.6.0", -1},
+		{"2024.10.1", "2024.6.0", 1},
+		{"1", "1.1.0", -1},
+	}
+	for _, tc := range cases {
+		if got := MustNewVersion(tc.left).CompareRelease(MustNewVersion(tc.right)); got != tc.expected {
+			t.Fatalf("unexpected comparison of %s and %s: %d", tc.left, tc.right, got)
+		}
+	}
+}
+
+func TestVersionWithinLevel(t *testing.T) {
+	cases := []struct {
+		current  string
+		next     string
+		level    string
+		expected bool
+	}{
+		{"v1.2.3", "v1.2.4", UpdatePatch, true},
+		{"v1.2.3", "v1.3.0", UpdatePatch, false},
+		{"v1.2", "v1.2.1", UpdatePatch, true},
+		{"v1.2.3", "v1.3.0", UpdateMinor, true},
+		{"v1.2.3", "v2.0.0", UpdateMinor, false},
+		{"v1.2.3", "v2.0.0", UpdateMajor, true},
+	}
+	for _, tc := range cases {
+		got := MustNewVersion(tc.next).WithinLevel(MustNewVersion(tc.current), tc.level)
+		if got != tc.expected {
+			t.Fatalf("unexpected %s update from %s to %s: %v", tc.level, tc.current, tc.next, got)
+		}
+	}
+}
+
 func TestFindLatest(t *testing.T) {
 	tags := []string{"v1.0.0", "bad", "v1.2.0-rc.1", "v1.1.1"}
 	if got := FindLatestVersion(tags, true); got != "v1.1.1" {
@@ -87,3 +130,20 @@ func TestFindLatest(t *testing.T) {
 		t.Fatalf("unexpected latest: %s", got)
 	}
 }
+
+func TestReleasesBetween(t *testing.T) {
+	releases := []Release{
+		{Tag: "v1.1.0"}, {Tag: "v1.4.0"}, {Tag: "nightly"}, {Tag: "v1.2.0"}, {Tag: "v1.5.0"}, {Tag: "v1.3.0-beta"},
+	}
+	got := ReleasesBetween(releases, "v1.1.0", "v1.4.0")
+	tags := make([]string, 0, len(got))
+	for _, release := range got {
+		tags = append(tags, release.Tag)
+	}
+	if strings.Join(tags, " ") != "v1.4.0 v1.3.0-beta v1.2.0" {
+		t.Fatalf("unexpected releases: %v", tags)
+	}
+	if got := ReleasesBetween(releases, "main", "v1.2.0"); len(got) != 2 {
+		t.Fatalf("expected every release up to the target, got %+v", got)
+	}
+}
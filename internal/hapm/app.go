@@ -3,7 +3,9 @@ package hapm
 import (
 	"io"
 	"os"
+	"time"
 
+	"github.com/mishamyrt/hapm/internal/manifest"
 	"github.com/mishamyrt/hapm/internal/report"
 )
 
@@ -18,11 +20,35 @@ type GlobalOptions struct {
 	Manifest string
 	Storage  string
 	Dry      bool
+	// HomeAssistant overrides Home Assistant version from the manifest.
+	HomeAssistant string
+	// NoCache disables the shared artifact cache.
+	NoCache bool
+	// Offline forbids network access, packages are resolved from
+	// the storage and the cache only.
+	Offline bool
+	// Mirror is a vendored directory packages are served from instead
+	// of the network.
+	Mirror string
+	// MaxDownloadSize limits size of a single download in MiB. Zero
+	// disables the limit.
+	MaxDownloadSize int64
+	// Concurrency limits number of packages resolved or downloaded at
+	// the same time. Zero uses the default.
+	Concurrency int
+	// Wait makes commands that change the storage wait for the lock held
+	// by another process instead of failing.
+	Wait bool
+	// WaitTimeout limits waiting for the lock. Zero means no limit.
+	WaitTimeout time.Duration
 }
 
 // SyncOptions describe sync command options.
 type SyncOptions struct {
 	AllowUnstable bool
+	// KeepGoing applies every change that can succeed instead of stopping
+	// at the first failed package.
+	KeepGoing bool
 }
 
 // InstallOptions describe install command options.
@@ -32,17 +58,72 @@ type InstallOptions struct {
 	AllowUnstable bool
 }
 
+// ExportOptions describe export command options.
+type ExportOptions struct {
+	Entries     []string
+	Incremental bool
+	Format      string
+	Prefix      string
+	Resources   string
+	Compress    bool
+}
+
+// RequirementsOptions describe requirements command options.
+type RequirementsOptions struct {
+	Output string
+}
+
+// CachePruneOptions describe cache prune command options.
+type CachePruneOptions struct {
+	All       bool
+	OlderThan time.Duration
+}
+
+// Release notes formats.
+const (
+	ChangelogText     = "text"
+	ChangelogMarkdown = "md"
+)
+
 // UpdatesOptions describe updates command options.
 type UpdatesOptions struct {
 	AllowUnstable bool
+	// Changelog prints release notes of every update.
+	Changelog bool
+	Format    string
+}
+
+// ChangelogOptions describe changelog command options.
+type ChangelogOptions struct {
+	Entries []string
+	// To is the target version. The available update is used if it is empty.
+	To            string
+	Format        string
+	AllowUnstable bool
+}
+
+// UpgradeOptions describe upgrade command options.
+type UpgradeOptions struct {
+	// Entries are names of packages to upgrade. Every package is upgraded
+	// if it is empty.
+	Entries       []string
+	Level         string
+	AllowUnstable bool
+}
+
+// DoctorOptions describe doctor command options.
+type DoctorOptions struct {
+	// Fix downloads broken packages again and removes orphan artifacts.
+	Fix bool
 }
 
 // App coordinates command business logic.
 type App struct {
-	out      io.Writer
-	errOut   io.Writer
-	reporter report.Reporter
-	globals  GlobalOptions
+	out       io.Writer
+	errOut    io.Writer
+	reporter  report.Reporter
+	globals   GlobalOptions
+	downloads *report.Downloads
 }
 
 // DefaultGlobalOptions returns default values for global flags.
@@ -63,10 +144,11 @@ func New(out io.Writer, errOut io.Writer) *App {
 		errOut = os.Stderr
 	}
 	return &App{
-		out:      out,
-		errOut:   errOut,
-		reporter: report.New(out),
-		globals:  DefaultGlobalOptions(),
+		out:       out,
+		errOut:    errOut,
+		reporter:  report.New(out),
+		globals:   DefaultGlobalOptions(),
+		downloads: report.NewDownloads(),
 	}
 }
 
@@ -85,8 +167,20 @@ func (a *App) token() string {
 	return os.Getenv(tokenVar)
 }
 
+// homeAssistantVersion returns Home Assistant version packages must be
+// compatible with. The flag has priority over the manifest.
+func (a *App) homeAssistantVersion(loaded *manifest.Manifest) string {
+	if a.globals.HomeAssistant != "" {
+		return a.globals.HomeAssistant
+	}
+	if loaded != nil {
+		return loaded.HomeAssistant
+	}
+	return ""
+}
+
 func (a *App) warnNoToken() {
-	if a.token() == "" {
+	if a.token() == "" && !a.globals.Offline && a.globals.Mirror == "" {
 		a.reporter.NoToken(tokenVar)
 	}
 }
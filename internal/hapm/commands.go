@@ -1,21 +1,117 @@
 package hapm
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
+	"github.com/mishamyrt/hapm/internal/cache"
+	"github.com/mishamyrt/hapm/internal/github"
+	"github.com/mishamyrt/hapm/internal/hapkg"
 	"github.com/mishamyrt/hapm/internal/manager"
 	"github.com/mishamyrt/hapm/internal/manifest"
+	"github.com/mishamyrt/hapm/internal/mirror"
 	"github.com/mishamyrt/hapm/internal/report"
+	"github.com/mishamyrt/hapm/internal/storagelock"
 )
 
 func (a *App) newManager() (*manager.PackageManager, error) {
-	store, err := manager.New(a.globals.Storage, a.token())
+	var shared *cache.Cache
+	if !a.globals.NoCache {
+		var err error
+		if shared, err = a.openCache(); err != nil {
+			return nil, err
+		}
+	}
+	var upstream hapkg.GitClient
+	if a.globals.Mirror != "" {
+		upstream = mirror.NewClient(a.globals.Mirror)
+	} else {
+		upstream = github.NewClient(a.token())
+	}
+	upstream = hapkg.NewMeteredClient(upstream, hapkg.DownloadOptions{
+		MaxSize:  a.globals.MaxDownloadSize << 20,
+		Progress: a.downloads.Add,
+	})
+	// The mirror is local, so it is used as is in offline mode.
+	offline := a.globals.Offline && a.globals.Mirror == ""
+	var client hapkg.GitClient
+	switch {
+	case offline:
+		client = cache.NewOfflineClient(shared)
+	case shared != nil:
+		client = cache.NewClient(upstream, shared)
+	default:
+		client = upstream
+	}
+	store, err := manager.New(a.globals.Storage, client)
 	if err != nil {
 		return nil, a.handledError("creating package manager", err)
 	}
+	store.SetOffline(offline)
+	store.SetConcurrency(a.globals.Concurrency)
 	return store, nil
 }
 
+// reportOffline reports packages that require network access in offline
+// mode and returns handled error, or nil if err is not caused by it.
+func (a *App) reportOffline(err error) error {
+	var offlineErr *manager.OfflineError
+	if !errors.As(err, &offlineErr) {
+		return nil
+	}
+	a.reporter.Offline(offlineErr.Packages)
+	return HandledError(err)
+}
+
+// reportPackageErrors reports packages that failed and returns handled
+// error, or nil if err doesn't describe failures of single packages.
+func (a *App) reportPackageErrors(action string, err error) error {
+	var resolveErr *manager.ResolveError
+	if !errors.As(err, &resolveErr) {
+		return nil
+	}
+	a.reporter.PackageErrors(action, resolveErr.Packages)
+	return HandledError(err)
+}
+
+// lockStorage takes the storage lock for commands that change the storage
+// and returns function that releases it. Dry runs don't take the lock.
+func (a *App) lockStorage() (func(), error) {
+	if a.globals.Dry {
+		return func() {}, nil
+	}
+	lock, err := storagelock.Acquire(a.globals.Storage, storagelock.Options{})
+	var lockedErr *storagelock.LockedError
+	if errors.As(err, &lockedErr) && a.globals.Wait {
+		a.reporter.WaitingForLock(lockedErr.Holder)
+		lock, err = storagelock.Acquire(a.globals.Storage, storagelock.Options{
+			Wait:    true,
+			Timeout: a.globals.WaitTimeout,
+		})
+	}
+	if errors.As(err, &lockedErr) {
+		a.reporter.StorageLocked(lockedErr.Path, lockedErr.Holder)
+		return nil, HandledError(err)
+	}
+	if err != nil {
+		return nil, a.handledError("locking storage", err)
+	}
+	return func() {
+		if err := lock.Release(); err != nil {
+			a.reporter.Exception("unlocking storage", err)
+		}
+	}, nil
+}
+
+func (a *App) openCache() (*cache.Cache, error) {
+	path, err := cache.DefaultPath()
+	if err != nil {
+		return nil, a.handledError("locating cache", err)
+	}
+	return cache.New(path), nil
+}
+
 // Init creates empty manifest from supported package kinds.
 func (a *App) Init() error {
 	store, err := a.newManager()
@@ -31,15 +127,26 @@ func (a *App) Init() error {
 
 // Sync synchronizes storage with current manifest.
 func (a *App) Sync(opts SyncOptions) error {
+	unlock, err := a.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	store, err := a.newManager()
 	if err != nil {
 		return err
 	}
+	store.SetKeepGoing(opts.KeepGoing)
 	return a.synchronize(store, !opts.AllowUnstable, nil)
 }
 
 // Install adds package locations to manifest and synchronizes.
 func (a *App) Install(opts InstallOptions) error {
+	unlock, err := a.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	store, err := a.newManager()
 	if err != nil {
 		return err
@@ -75,29 +182,309 @@ func (a *App) Install(opts InstallOptions) error {
 
 // PrintUpdates prints list of packages that can be upgraded.
 func (a *App) PrintUpdates(opts UpdatesOptions) error {
+	if err := a.checkChangelogFormat(opts.Format); err != nil {
+		return err
+	}
+	markdown := opts.Changelog && opts.Format == ChangelogMarkdown
 	store, err := a.newManager()
 	if err != nil {
 		return err
 	}
 
 	stableOnly := !opts.AllowUnstable
-	if !stableOnly {
+	if !stableOnly && !markdown {
 		a.reporter.Warning("Search includes unstable versions")
 	}
+	a.configureManager(store, a.optionalManifest())
+	diff, err := a.findUpdates(store, stableOnly, !markdown)
+	if err != nil {
+		return err
+	}
+	available, held := splitHeld(diff)
+	if markdown {
+		return a.printChangelog(store, available, opts.Format)
+	}
+	if len(available) == 0 {
+		a.reporter.UpToDate()
+	} else {
+		a.reporter.Diff(available, true, true)
+	}
+	if len(held) > 0 {
+		a.reporter.HeldUpdates(held)
+	}
+	if opts.Changelog && len(available) > 0 {
+		_, _ = fmt.Fprintln(a.out)
+		return a.printChangelog(store, available, opts.Format)
+	}
+	return nil
+}
+
+// Changelog prints release notes between installed versions of packages
+// and their updates.
+func (a *App) Changelog(opts ChangelogOptions) error {
+	if err := a.checkChangelogFormat(opts.Format); err != nil {
+		return err
+	}
+	if len(opts.Entries) == 0 {
+		return a.handledMessage("changelog requires at least one package")
+	}
+	if opts.To != "" && len(opts.Entries) != 1 {
+		return a.handledMessage("--to requires exactly one package")
+	}
+	store, err := a.newManager()
+	if err != nil {
+		return err
+	}
+	installed := map[string]string{}
+	for _, pkg := range store.Descriptions() {
+		installed[pkg.FullName] = pkg.Version
+	}
+	names := make([]string, 0, len(opts.Entries))
+	selected := make(map[string]bool, len(opts.Entries))
+	for _, entry := range opts.Entries {
+		location, ok := manifest.ParseLocation(entry)
+		if !ok {
+			a.reporter.WrongFormat(entry)
+			return HandledError(fmt.Errorf("wrong location format: %s", entry))
+		}
+		if _, ok := installed[location.FullName]; !ok {
+			return a.handledMessage(fmt.Sprintf("package %s is not installed", location.FullName))
+		}
+		names = append(names, location.FullName)
+		selected[location.FullName] = true
+	}
+
+	markdown := opts.Format == ChangelogMarkdown
+	updates := make([]manager.PackageDiff, 0, len(selected))
+	if opts.To != "" {
+		updates = append(updates, manager.PackageDiff{
+			PackageDescription: hapkg.PackageDescription{FullName: names[0], Version: opts.To},
+			CurrentVersion:     installed[names[0]],
+		})
+	} else {
+		a.configureManager(store, a.optionalManifest())
+		diff, err := a.findUpdates(store, !opts.AllowUnstable, !markdown)
+		if err != nil {
+			return err
+		}
+		available, _ := splitHeld(diff)
+		for _, update := range available {
+			if selected[update.FullName] {
+				updates = append(updates, update)
+			}
+		}
+	}
+	if len(updates) == 0 {
+		if !markdown {
+			a.reporter.UpToDate()
+		}
+		return nil
+	}
+	return a.printChangelog(store, updates, opts.Format)
+}
 
+// findUpdates looks for updates of installed packages and reports
+// packages that failed.
+func (a *App) findUpdates(store *manager.PackageManager, stableOnly bool, showProgress bool) ([]manager.PackageDiff, error) {
 	progress := report.NewProgress(a.reporter.Out())
-	progress.Start("Looking for package updates")
+	if showProgress {
+		progress.Start("Looking for package updates")
+	}
 	diff, err := store.Updates(stableOnly)
+	if showProgress {
+		progress.Stop()
+	}
+	if offlineErr := a.reportOffline(err); offlineErr != nil {
+		return nil, offlineErr
+	}
+	if packagesErr := a.reportPackageErrors("looking for package updates", err); packagesErr != nil {
+		return nil, packagesErr
+	}
+	if err != nil {
+		return nil, a.handledError("looking for package updates", err)
+	}
+	return diff, nil
+}
+
+// printChangelog reads release notes of the updates and prints them in
+// the format.
+func (a *App) printChangelog(store *manager.PackageManager, updates []manager.PackageDiff, format string) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	if a.globals.Offline || a.globals.Mirror != "" {
+		return a.handledMessage("release notes are read from GitHub, run the command without --offline and --mirror")
+	}
+	markdown := format == ChangelogMarkdown
+	progress := report.NewProgress(a.reporter.Out())
+	if !markdown {
+		progress.Start("Reading release notes")
+	}
+	changelogs, err := store.Changelog(github.NewClient(a.token()), updates)
+	if !markdown {
+		progress.Stop()
+	}
+	if packagesErr := a.reportPackageErrors("reading release notes", err); packagesErr != nil {
+		return packagesErr
+	}
+	if err != nil {
+		return a.handledError("reading release notes", err)
+	}
+	if markdown {
+		a.reporter.ChangelogMarkdown(changelogs)
+	} else {
+		a.reporter.Changelog(changelogs)
+	}
+	return nil
+}
+
+func (a *App) checkChangelogFormat(format string) error {
+	switch format {
+	case "", ChangelogText, ChangelogMarkdown:
+		return nil
+	}
+	return a.handledMessage(fmt.Sprintf("unknown changelog format %s, expected %s or %s", format, ChangelogText, ChangelogMarkdown))
+}
+
+// splitHeld separates updates that package policies hold back.
+func splitHeld(updates []manager.PackageDiff) ([]manager.PackageDiff, []manager.PackageDiff) {
+	available := make([]manager.PackageDiff, 0, len(updates))
+	held := make([]manager.PackageDiff, 0)
+	for _, update := range updates {
+		if update.Held != "" {
+			held = append(held, update)
+		} else {
+			available = append(available, update)
+		}
+	}
+	return available, held
+}
+
+// Upgrade bumps manifest versions of installed packages to their updates
+// and synchronizes the storage.
+func (a *App) Upgrade(opts UpgradeOptions) error {
+	unlock, err := a.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store, err := a.newManager()
+	if err != nil {
+		return err
+	}
+	manifestFile := manifest.New(a.globals.Manifest)
+	if err := manifestFile.Load(); err != nil {
+		return a.handledError("parsing manifest", err)
+	}
+	selected, err := a.selectPackages(manifestFile, opts.Entries)
+	if err != nil {
+		return err
+	}
+
+	stableOnly := !opts.AllowUnstable
+	if !stableOnly {
+		a.reporter.Warning("Search includes unstable versions")
+	}
+	a.configureManager(store, manifestFile)
+	progress := report.NewProgress(a.reporter.Out())
+	progress.Start("Looking for package updates")
+	updates, err := store.UpdatesWithin(stableOnly, opts.Level)
 	progress.Stop()
+	if offlineErr := a.reportOffline(err); offlineErr != nil {
+		return offlineErr
+	}
+	if packagesErr := a.reportPackageErrors("looking for package updates", err); packagesErr != nil {
+		return packagesErr
+	}
 	if err != nil {
 		return a.handledError("looking for package updates", err)
 	}
-	if len(diff) == 0 {
+
+	bumps := manifestBumps(manifestFile, updates, selected)
+	if len(bumps) == 0 {
 		a.reporter.UpToDate()
 		return nil
 	}
-	a.reporter.Diff(diff, true, true)
-	return nil
+	if a.globals.Dry {
+		a.reporter.Diff(bumps, true, true)
+		return nil
+	}
+	versions := make(map[string]string, len(bumps))
+	for _, bump := range bumps {
+		versions[bump.FullName] = bump.Version
+	}
+	if err := manifestFile.SetVersions(versions); err != nil {
+		return a.handledError("updating manifest", err)
+	}
+	a.reporter.ManifestBumped(a.globals.Manifest, bumps)
+	return a.synchronize(store, stableOnly, manifestFile)
+}
+
+// selectPackages returns full names of manifest packages matching the
+// entries, or nil if entries are empty.
+func (a *App) selectPackages(manifestFile *manifest.Manifest, entries []string) (map[string]bool, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	selected := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		location, ok := manifest.ParseLocation(entry)
+		if !ok {
+			a.reporter.WrongFormat(entry)
+			return nil, HandledError(fmt.Errorf("wrong location format: %s", entry))
+		}
+		found := false
+		for _, pkg := range manifestFile.Values {
+			if pkg.FullName == location.FullName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, a.handledMessage(fmt.Sprintf("package %s is not in the manifest", location.FullName))
+		}
+		selected[location.FullName] = true
+	}
+	return selected, nil
+}
+
+// manifestBumps returns updates of pinned manifest entries. Entries that
+// track the latest version or are already pinned to a newer one are left
+// as is.
+func manifestBumps(
+	manifestFile *manifest.Manifest,
+	updates []manager.PackageDiff,
+	selected map[string]bool,
+) []manager.PackageDiff {
+	pinned := make(map[string]string, len(manifestFile.Values))
+	for _, pkg := range manifestFile.Values {
+		pinned[pkg.FullName] = pkg.Version
+	}
+	available, _ := splitHeld(updates)
+	bumps := make([]manager.PackageDiff, 0, len(available))
+	for _, update := range available {
+		version, ok := pinned[update.FullName]
+		if !ok || version == "latest" || (selected != nil && !selected[update.FullName]) {
+			continue
+		}
+		if current, err := hapkg.NewVersion(version); err == nil {
+			if hapkg.MustNewVersion(update.Version).Compare(current) <= 0 {
+				continue
+			}
+		}
+		update.CurrentVersion = version
+		bumps = append(bumps, update)
+	}
+	return bumps
+}
+
+// configureManager passes manifest settings, Home Assistant version and
+// update policies, to the package manager.
+func (a *App) configureManager(store *manager.PackageManager, loaded *manifest.Manifest) {
+	store.SetHomeAssistantVersion(a.homeAssistantVersion(loaded))
+	if loaded != nil {
+		store.SetPolicies(loaded.Policies)
+	}
 }
 
 // PrintVersions prints all available versions for a package location.
@@ -119,6 +506,9 @@ func (a *App) PrintVersions(entries []string) error {
 	progress.Start("Looking for package versions")
 	tags, err := store.GetVersions(*location)
 	progress.Stop()
+	if offlineErr := a.reportOffline(err); offlineErr != nil {
+		return offlineErr
+	}
 	if err != nil {
 		return a.handledError("looking for package versions", err)
 	}
@@ -132,29 +522,216 @@ func (a *App) List() error {
 	if err != nil {
 		return err
 	}
-	a.reporter.Packages(store.Descriptions())
+	domains, err := store.Domains()
+	if err != nil {
+		return a.handledError("reading package domains", err)
+	}
+	a.reporter.Packages(store.Descriptions(), domains)
 	return nil
 }
 
 // Export copies installed packages to target directory.
-func (a *App) Export(entries []string) error {
+func (a *App) Export(opts ExportOptions) error {
 	store, err := a.newManager()
 	if err != nil {
 		return err
 	}
-	if len(entries) != 1 {
+	if len(opts.Entries) != 1 {
 		return a.handledMessage("export requires output path")
 	}
-	result, err := store.Export(entries[0])
+	result, err := store.Export(opts.Entries[0], manager.ExportOptions{
+		Incremental: opts.Incremental,
+		Format:      opts.Format,
+		Prefix:      opts.Prefix,
+		Dry:         a.globals.Dry,
+		Resources:   opts.Resources,
+		Compress:    opts.Compress,
+	})
+	var conflictErr *manager.ConflictError
+	if errors.As(err, &conflictErr) {
+		a.reporter.ExportConflicts(conflictErr.Conflicts)
+		return HandledError(err)
+	}
+	var unmanagedErr *manager.UnmanagedError
+	if errors.As(err, &unmanagedErr) {
+		a.reporter.UnmanagedFiles(unmanagedErr.Paths)
+		return HandledError(err)
+	}
 	if err != nil {
 		return a.handledError("exporting packages", err)
 	}
-	if files, ok := result.PostExportFiles["plugins"]; ok {
+	if a.globals.Dry {
+		return a.printExportPlan(result)
+	}
+	if opts.Incremental {
+		a.reporter.ExportChanges(result.Changes)
+	}
+	if result.Archive != nil {
+		a.reporter.Archive(opts.Entries[0], *result.Archive)
+	}
+	if result.Resources != "" {
+		a.reporter.ResourcesWritten(result.Resources, result.PostExportFiles["plugins"])
+	} else if files, ok := result.PostExportFiles["plugins"]; ok {
 		a.reporter.PluginExportHint(files)
 	}
+	if files, ok := result.PostExportFiles["appdaemon"]; ok {
+		a.reporter.AppDaemonExportHint(files)
+	}
+	return nil
+}
+
+// Requirements writes Python requirements of installed integrations.
+func (a *App) Requirements(opts RequirementsOptions) error {
+	store, err := a.newManager()
+	if err != nil {
+		return err
+	}
+	requirements, conflicts, err := store.Requirements()
+	if err != nil {
+		return a.handledError("collecting requirements", err)
+	}
+	if len(conflicts) > 0 {
+		a.reporter.RequirementConflicts(conflicts)
+		return HandledError(errors.New("requirements have conflicting pins"))
+	}
+	content := manager.FormatRequirements(requirements)
+	if opts.Output == "" {
+		_, _ = fmt.Fprint(a.out, content)
+		return nil
+	}
+	if a.globals.Dry {
+		a.reporter.RequirementsWritten(opts.Output, len(requirements))
+		return nil
+	}
+	if err := os.WriteFile(opts.Output, []byte(content), 0o644); err != nil {
+		return a.handledError("writing requirements", err)
+	}
+	a.reporter.RequirementsWritten(opts.Output, len(requirements))
+	return nil
+}
+
+// Vendor writes installed package artifacts to the mirror directory.
+func (a *App) Vendor(entries []string) error {
+	store, err := a.newManager()
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 {
+		return a.handledMessage("vendor requires output path")
+	}
+	if a.globals.Dry {
+		a.reporter.Vendored(entries[0], store.Descriptions())
+		return nil
+	}
+	vendored, err := store.Vendor(entries[0])
+	if err != nil {
+		return a.handledError("vendoring packages", err)
+	}
+	a.reporter.Vendored(entries[0], vendored)
+	return nil
+}
+
+// Doctor verifies stored artifacts against the lockfile and optionally
+// repairs the storage.
+func (a *App) Doctor(opts DoctorOptions) error {
+	fix := opts.Fix && !a.globals.Dry
+	if fix {
+		unlock, err := a.lockStorage()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	store, err := a.newManager()
+	if err != nil {
+		return err
+	}
+	problems, err := store.Doctor(fix)
+	if err != nil {
+		return a.handledError("checking storage", err)
+	}
+	if len(problems) == 0 {
+		a.reporter.StorageHealthy()
+		return nil
+	}
+	a.reporter.StorageProblems(problems, fix)
+	for _, problem := range problems {
+		if !problem.Fixed && !problem.Notice {
+			return HandledError(errors.New("storage has problems"))
+		}
+	}
+	return nil
+}
+
+func (a *App) printExportPlan(result *manager.ExportResult) error {
+	a.reporter.ExportPlan(result.Changes)
+	if len(result.Conflicts) > 0 {
+		a.reporter.ExportConflicts(result.Conflicts)
+	}
+	if len(result.Unmanaged) > 0 {
+		a.reporter.UnmanagedFiles(result.Unmanaged)
+	}
+	if len(result.Conflicts) > 0 || len(result.Unmanaged) > 0 {
+		return HandledError(errors.New("export plan has conflicts"))
+	}
+	return nil
+}
+
+// CacheList prints artifacts of the shared cache.
+func (a *App) CacheList() error {
+	shared, err := a.openCache()
+	if err != nil {
+		return err
+	}
+	entries, err := shared.List()
+	if err != nil {
+		return a.handledError("reading cache", err)
+	}
+	a.reporter.CacheEntries(shared.Path(), entries)
+	return nil
+}
+
+// CachePrune removes unused artifacts from the shared cache.
+func (a *App) CachePrune(opts CachePruneOptions) error {
+	shared, err := a.openCache()
+	if err != nil {
+		return err
+	}
+	result, err := shared.Prune(cache.PruneOptions{All: opts.All, OlderThan: opts.OlderThan})
+	if err != nil {
+		return a.handledError("pruning cache", err)
+	}
+	a.reporter.CachePruned(*result)
+	return nil
+}
+
+// CacheVerify checks artifacts of the shared cache against their checksums.
+func (a *App) CacheVerify() error {
+	shared, err := a.openCache()
+	if err != nil {
+		return err
+	}
+	problems, err := shared.Verify()
+	if err != nil {
+		return a.handledError("verifying cache", err)
+	}
+	if len(problems) > 0 {
+		a.reporter.CacheProblems(problems)
+		return HandledError(errors.New("cache is corrupted"))
+	}
+	a.reporter.CacheValid()
 	return nil
 }
 
+// optionalManifest loads the manifest for commands that can work without it.
+func (a *App) optionalManifest() *manifest.Manifest {
+	loaded := manifest.New(a.globals.Manifest)
+	if err := loaded.Load(); err != nil {
+		return nil
+	}
+	return loaded
+}
+
 func (a *App) synchronize(
 	store *manager.PackageManager,
 	stableOnly bool,
@@ -167,6 +744,7 @@ func (a *App) synchronize(
 		}
 	}
 
+	a.configureManager(store, loadedManifest)
 	progress := report.NewProgress(a.reporter.Out())
 	if len(loadedManifest.HasLatest) > 0 {
 		a.reporter.Latest(loadedManifest.HasLatest)
@@ -176,23 +754,95 @@ func (a *App) synchronize(
 	if len(loadedManifest.HasLatest) > 0 {
 		progress.Stop()
 	}
+	if offlineErr := a.reportOffline(err); offlineErr != nil {
+		return offlineErr
+	}
+	failed := make([]manager.PackageError, 0)
+	var resolveErr *manager.ResolveError
+	if errors.As(err, &resolveErr) && diff != nil {
+		// In keep-going mode packages that can't be resolved are left out
+		// of the diff and reported after the rest is applied.
+		failed = append(failed, resolveErr.Packages...)
+		err = nil
+	}
+	if packagesErr := a.reportPackageErrors("calculating changes", err); packagesErr != nil {
+		return packagesErr
+	}
+	var compatibilityErr *manager.CompatibilityError
+	if errors.As(err, &compatibilityErr) {
+		a.reporter.Incompatible(compatibilityErr.HomeAssistant, compatibilityErr.Packages)
+		return HandledError(err)
+	}
 	if err != nil {
 		return a.handledError("calculating changes", err)
 	}
 	a.reporter.Diff(diff, false, false)
 	if a.globals.Dry {
-		return nil
+		return a.reportFailed(failed)
 	}
-	if len(diff) > 0 {
-		a.warnNoToken()
-		progress := report.NewProgress(a.reporter.Out())
-		progress.Start("Synchronizing the changes")
-		if err := store.Apply(diff); err != nil {
-			progress.Stop()
-			return a.handledError("synchronizing the changes", err)
-		}
-		progress.Stop()
+	if len(diff) == 0 {
+		a.reporter.Summary(diff)
+		return a.reportFailed(failed)
+	}
+	previous, err := store.Domains()
+	if err != nil {
+		return a.handledError("reading package domains", err)
 	}
+	a.warnNoToken()
+	progress = report.NewProgress(a.reporter.Out())
+	progress.SetDetail(a.downloads.String)
+	progress.Start("Synchronizing the changes")
+	err = store.Apply(diff)
+	progress.Stop()
+	var applyErr *manager.ApplyError
+	if errors.As(err, &applyErr) {
+		diff = appliedDiff(diff, applyErr)
+		failed = append(failed, applyErr.Packages...)
+		err = nil
+	}
+	var manifestErr *hapkg.ManifestError
+	if errors.As(err, &manifestErr) {
+		a.reporter.ManifestIssues([]manager.PackageIssues{{
+			Package: manifestErr.FullName,
+			Issues:  manifestErr.Issues,
+		}})
+		return HandledError(err)
+	}
+	if err != nil {
+		return a.handledError("synchronizing the changes", err)
+	}
+	issues, err := store.Validate(diff, previous)
+	if err != nil {
+		return a.handledError("validating packages", err)
+	}
+	a.reporter.ManifestIssues(issues)
 	a.reporter.Summary(diff)
+	if failedErr := a.reportFailed(failed); failedErr != nil {
+		return failedErr
+	}
+	if manager.HasIssueErrors(issues) {
+		return HandledError(errors.New("packages have manifest errors"))
+	}
 	return nil
 }
+
+// reportFailed reports packages that failed in keep-going mode and returns
+// handled error if there are any.
+func (a *App) reportFailed(failed []manager.PackageError) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	a.reporter.PackageErrors("synchronizing the changes", failed)
+	return HandledError(&manager.ResolveError{Packages: failed})
+}
+
+// appliedDiff returns changes of packages that didn't fail.
+func appliedDiff(diff []manager.PackageDiff, applyErr *manager.ApplyError) []manager.PackageDiff {
+	applied := make([]manager.PackageDiff, 0, len(diff))
+	for _, item := range diff {
+		if !applyErr.Failed(item.FullName) {
+			applied = append(applied, item)
+		}
+	}
+	return applied
+}
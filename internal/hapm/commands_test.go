@@ -0,0 +1,88 @@
+package hapm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncReinstallsMissingArtifact(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"foo-bar-abc/custom_components/bar/__init__.py": "",
+		"foo-bar-abc/custom_components/bar/manifest.json": `{
+			"domain": "bar",
+			"name": "Bar",
+			"codeowners": ["@foo"],
+			"documentation": "https://github.com/foo/bar",
+			"issue_tracker": "https://github.com/foo/bar/issues",
+			"version": "1.0.0"
+		}`,
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/foo/bar/tarball/v1.0.0" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(tarball)
+	}))
+	defer server.Close()
+	t.Setenv("HAPM_GITHUB_API_BASE_URL", server.URL)
+	t.Setenv("HAPM_GITHUB_WEB_BASE_URL", server.URL)
+	t.Setenv("HAPM_DISABLE_PROGRESS", "1")
+
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "hapm.yaml")
+	if err := os.WriteFile(manifestPath, []byte("integrations:\n  - foo/bar@v1.0.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	app := New(out, out)
+	app.SetGlobals(GlobalOptions{
+		Manifest: manifestPath,
+		Storage:  filepath.Join(dir, storageDir),
+		NoCache:  true,
+	})
+	if err := app.Sync(SyncOptions{}); err != nil {
+		t.Fatalf("sync failed: %v\n%s", err, out)
+	}
+
+	artifact := filepath.Join(dir, storageDir, "foo-bar@v1.0.0.tar.gz")
+	if err := os.Remove(artifact); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := app.Sync(SyncOptions{}); err != nil {
+		t.Fatalf("sync with missing artifact failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(artifact); err != nil {
+		t.Fatalf("artifact is not reinstalled: %v\n%s", err, out)
+	}
+}
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
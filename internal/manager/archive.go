@@ -0,0 +1,213 @@
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// Export formats.
+const (
+	FormatDir      = "dir"
+	FormatTar      = "tar"
+	FormatTgz      = "tgz"
+	FormatOCILayer = "oci-layer"
+)
+
+const (
+	ociLayerMediaType     = "application/vnd.oci.image.layer.v1.tar"
+	ociLayerGzipMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// archiveModTime is written to every archive entry, so the archive digest
+// depends only on package contents.
+var archiveModTime = time.Unix(0, 0).UTC()
+
+// ArchiveInfo describes the written export archive.
+type ArchiveInfo struct {
+	Format    string
+	MediaType string
+	Size      int64
+	// Digest is sha256 of the archive file.
+	Digest string
+	// DiffID is sha256 of the uncompressed tar stream.
+	DiffID string
+}
+
+// IsArchiveFormat reports whether export format writes a single file.
+func IsArchiveFormat(format string) bool {
+	switch format {
+	case FormatTar, FormatTgz, FormatOCILayer:
+		return true
+	}
+	return false
+}
+
+func validateFormat(format string) error {
+	if format == "" || format == FormatDir || IsArchiveFormat(format) {
+		return nil
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+// writeArchive stores the staged tree as a reproducible archive: entries
+// are sorted, owned by root and have fixed modification time.
+func (p *exportPlan) writeArchive(target string, format string, prefix string) (*ArchiveInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := file.Name()
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	info := &ArchiveInfo{Format: format, MediaType: ociLayerGzipMediaType}
+	digest := sha256.New()
+	counter := &countingWriter{}
+	fileWriter := io.MultiWriter(file, digest, counter)
+
+	diffID := sha256.New()
+	var gz *gzip.Writer
+	var tarStream io.Writer
+	if format == FormatTar {
+		info.MediaType = ociLayerMediaType
+		tarStream = fileWriter
+	} else {
+		gz, err = gzip.NewWriterLevel(fileWriter, gzip.BestCompression)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		tarStream = gz
+	}
+	tw := tar.NewWriter(io.MultiWriter(tarStream, diffID))
+
+	if err := writeTreeEntries(tw, p.tree, prefix); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return nil, err
+	}
+	info.Size = counter.size
+	info.Digest = digestString(digest)
+	info.DiffID = digestString(diffID)
+	return info, nil
+}
+
+func writeTreeEntries(tw *tar.Writer, root string, prefix string) error {
+	prefix = strings.Trim(path.Clean("/"+filepath.ToSlash(prefix)), "/")
+	if prefix != "" {
+		parts := strings.Split(prefix, "/")
+		for i := range parts {
+			header := archiveHeader(strings.Join(parts[:i+1], "/")+"/", tar.TypeDir, 0o755)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+	// WalkDir visits entries in lexical order, which keeps the archive stable.
+	return filepath.WalkDir(root, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return tw.WriteHeader(archiveHeader(name+"/", tar.TypeDir, 0o755))
+		case info.Mode()&fs.ModeSymlink != 0:
+			linkname, err := os.Readlink(current)
+			if err != nil {
+				return err
+			}
+			header := archiveHeader(name, tar.TypeSymlink, 0o777)
+			header.Linkname = linkname
+			return tw.WriteHeader(header)
+		}
+		mode := int64(0o644)
+		if info.Mode().Perm()&0o111 != 0 {
+			mode = 0o755
+		}
+		header := archiveHeader(name, tar.TypeReg, mode)
+		header.Size = info.Size()
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		file, err := os.Open(current)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+}
+
+func archiveHeader(name string, typeflag byte, mode int64) *tar.Header {
+	return &tar.Header{
+		Name:     name,
+		Typeflag: typeflag,
+		Mode:     mode,
+		ModTime:  archiveModTime,
+		Uid:      0,
+		Gid:      0,
+	}
+}
+
+func digestString(h hash.Hash) string {
+	return hapkg.DigestPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+type countingWriter struct {
+	size int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"errors"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// PackageChangelog is release notes of versions between the installed
+// version of a package and its update.
+type PackageChangelog struct {
+	FullName       string
+	CurrentVersion string
+	Version        string
+	// Releases are newest first.
+	Releases []hapkg.Release
+}
+
+// Changelog reads release notes of the updates. Releases of packages are
+// requested in parallel.
+func (m *PackageManager) Changelog(provider hapkg.ReleaseProvider, updates []PackageDiff) ([]PackageChangelog, error) {
+	changelogs := make([]PackageChangelog, len(updates))
+	errs := make([]error, len(updates))
+	m.forEach(len(updates), func(index int) {
+		update := updates[index]
+		releases, err := provider.GetReleases(update.FullName, update.CurrentVersion)
+		if err != nil {
+			errs[index] = err
+			return
+		}
+		changelogs[index] = PackageChangelog{
+			FullName:       update.FullName,
+			CurrentVersion: update.CurrentVersion,
+			Version:        update.Version,
+			Releases:       hapkg.ReleasesBetween(releases, update.CurrentVersion, update.Version),
+		}
+	})
+
+	offline := make([]string, 0)
+	failed := make([]PackageError, 0)
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, hapkg.ErrOffline):
+			offline = append(offline, updates[i].FullName)
+		case err != nil:
+			failed = append(failed, PackageError{FullName: updates[i].FullName, Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return nil, &ResolveError{Packages: failed}
+	}
+	if len(offline) > 0 {
+		return nil, &OfflineError{Packages: offline}
+	}
+	return changelogs, nil
+}
@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// Incompatibility describes a package version that requires a newer
+// Home Assistant.
+type Incompatibility struct {
+	FullName string
+	Version  string
+	Requires string
+}
+
+// CompatibilityError is returned when selected package versions are not
+// compatible with the configured Home Assistant version.
+type CompatibilityError struct {
+	HomeAssistant string
+	Packages      []Incompatibility
+}
+
+func (e *CompatibilityError) Error() string {
+	items := make([]string, 0, len(e.Packages))
+	for _, pkg := range e.Packages {
+		items = append(items, pkg.FullName+"@"+pkg.Version+" requires "+pkg.Requires)
+	}
+	return "packages are not compatible with Home Assistant " + e.HomeAssistant + ": " + strings.Join(items, ", ")
+}
+
+// SetHomeAssistantVersion sets Home Assistant version packages must be
+// compatible with. Empty version disables compatibility checks.
+func (m *PackageManager) SetHomeAssistantVersion(version string) {
+	m.compatMu.Lock()
+	defer m.compatMu.Unlock()
+	m.haVersion = version
+	m.core = map[string]bool{}
+	m.coreRef = ""
+	m.coreErr = nil
+}
+
+// minHomeAssistant returns cached minimal Home Assistant version required
+// by the package version. Requirements are saved to the client cache, so
+// they are known in offline mode.
+func (m *PackageManager) minHomeAssistant(fullName string, version string) (string, error) {
+	key := fullName + "@" + version
+	m.compatMu.Lock()
+	required, ok := m.compat[key]
+	m.compatMu.Unlock()
+	if ok {
+		return required, nil
+	}
+	requirements, cached := m.client.(hapkg.RequirementCache)
+	required, err := hapkg.MinHomeAssistantVersion(m.client, fullName, version)
+	switch {
+	case errors.Is(err, hapkg.ErrOffline) && cached:
+		saved, found := requirements.Requirement(fullName, version)
+		if !found {
+			return "", err
+		}
+		required = saved
+	case err != nil:
+		return "", err
+	case cached:
+		_ = requirements.StoreRequirement(fullName, version, required)
+	}
+	m.compatMu.Lock()
+	m.compat[key] = required
+	m.compatMu.Unlock()
+	return required, nil
+}
+
+// checkCompatibility returns incompatibility of the package version,
+// or nil if the version is compatible.
+func (m *PackageManager) checkCompatibility(fullName string, version string) (*Incompatibility, error) {
+	if m.haVersion == "" {
+		return nil, nil
+	}
+	required, err := m.minHomeAssistant(fullName, version)
+	if err != nil {
+		return nil, err
+	}
+	if hapkg.IsCompatible(required, m.haVersion) {
+		return nil, nil
+	}
+	return &Incompatibility{FullName: fullName, Version: version, Requires: required}, nil
+}
+
+// latestVersion returns the newest version that is compatible with
+// the configured Home Assistant. If no version is compatible, empty version
+// and incompatibility of the newest one are returned.
+func (m *PackageManager) latestVersion(
+	fullName string,
+	tags []string,
+	stableOnly bool,
+) (string, *Incompatibility, error) {
+	latest := hapkg.FindLatestVersion(tags, stableOnly)
+	if m.haVersion == "" {
+		return latest, nil, nil
+	}
+	versions := make([]hapkg.Version, 0, len(tags))
+	for _, tag := range tags {
+		version, err := hapkg.NewVersion(tag)
+		if err != nil || (stableOnly && !version.IsStable()) {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.SliceStable(versions, func(i int, j int) bool {
+		return versions[i].Compare(versions[j]) > 0
+	})
+	var newest *Incompatibility
+	for _, version := range versions {
+		incompatible, err := m.checkCompatibility(fullName, version.Original)
+		if err != nil {
+			return "", nil, err
+		}
+		if incompatible == nil {
+			return version.Original, nil, nil
+		}
+		if newest == nil {
+			newest = incompatible
+		}
+	}
+	if newest == nil {
+		return latest, nil, nil
+	}
+	return "", newest, nil
+}
+
+// constrain passes the configured Home Assistant version to the package,
+// so it fails to set up content that requires a newer one before the
+// installed version is removed.
+func (m *PackageManager) constrain(pkg hapkg.Package) hapkg.Package {
+	if constrained, ok := pkg.(hapkg.HomeAssistantConstrained); ok {
+		constrained.SetHomeAssistantVersion(m.haVersion)
+	}
+	return pkg
+}
@@ -6,4 +6,10 @@ type PackageDiff struct {
 	hapkg.PackageDescription
 	Operation      string `json:"operation"`
 	CurrentVersion string `json:"current_version,omitempty"`
+	// CurrentKind is set when reinstall moves the package to another kind.
+	// Reinstall without it restores a missing artifact.
+	CurrentKind string `json:"current_kind,omitempty"`
+	// Held is the reason an update is held back by the package policy.
+	// Such updates are reported, but never applied.
+	Held string `json:"held,omitempty"`
 }
@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+	"github.com/mishamyrt/hapm/internal/storagelock"
+)
+
+// StorageProblem is a problem of the storage found by Doctor.
+type StorageProblem struct {
+	// Package is the full name of the broken package. It is empty for
+	// files that don't belong to any package.
+	Package string
+	Version string
+	Path    string
+	Message string
+	// Fixable reports whether Doctor can fix the problem.
+	Fixable bool
+	Fixed   bool
+	// FixErr is the error of a failed fix.
+	FixErr error
+	// Notice is set when the artifact is intact and only its digest is
+	// missing in the lockfile, as in lockfiles written by older versions.
+	// It doesn't indicate damage of the storage.
+	Notice bool
+}
+
+// Doctor checks stored artifacts of locked packages against the lockfile
+// and looks for files in the storage that no package owns. With fix,
+// broken packages are downloaded again, missing digests are recorded and
+// orphan artifacts are removed.
+func (m *PackageManager) Doctor(fix bool) ([]StorageProblem, error) {
+	problems := make([]StorageProblem, 0)
+	owned := map[string]bool{}
+	changed := false
+	for _, pkg := range m.sortedPackages() {
+		stored, ok := pkg.(hapkg.StoredPackage)
+		if !ok {
+			continue
+		}
+		owned[filepath.Clean(stored.ArtifactPath())] = true
+		problem := m.checkArtifact(stored, m.digests[pkg.FullName()])
+		if problem == nil {
+			continue
+		}
+		problem.Package = pkg.FullName()
+		problem.Version = pkg.Version()
+		if fix {
+			problem.FixErr = m.repair(pkg, problem)
+			problem.Fixed = problem.FixErr == nil
+			changed = changed || problem.Fixed
+		}
+		problems = append(problems, *problem)
+	}
+
+	orphans, err := m.orphans(owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, orphan := range orphans {
+		if fix && orphan.Fixable {
+			orphan.FixErr = os.Remove(orphan.Path)
+			orphan.Fixed = orphan.FixErr == nil
+		}
+		problems = append(problems, orphan)
+	}
+
+	if changed {
+		if err := m.dumpLock(); err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// checkArtifact returns the problem of the stored artifact or nil if it is
+// intact.
+func (m *PackageManager) checkArtifact(stored hapkg.StoredPackage, expected string) *StorageProblem {
+	path := stored.ArtifactPath()
+	problem := &StorageProblem{Path: path, Fixable: true}
+	digest, _, err := hapkg.FileDigest(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		problem.Message = "artifact is missing"
+		return problem
+	case err != nil:
+		problem.Message = "artifact can't be read: " + err.Error()
+		return problem
+	case expected != "" && digest != expected:
+		problem.Message = "checksum mismatch"
+		return problem
+	}
+	if err := stored.CheckArtifact(); err != nil {
+		problem.Message = "unexpected layout: " + err.Error()
+		return problem
+	}
+	if expected == "" {
+		problem.Message = "checksum is not recorded"
+		problem.Notice = true
+		return problem
+	}
+	return nil
+}
+
+// repair downloads the broken package again or records the digest of
+// an intact artifact.
+func (m *PackageManager) repair(pkg hapkg.Package, problem *StorageProblem) error {
+	if problem.Notice {
+		m.setDigest(pkg.FullName(), artifactDigest(pkg))
+		return nil
+	}
+	constructor, ok := m.registry.Constructors[pkg.Kind()]
+	if !ok {
+		return fmt.Errorf("unsupported package kind: %s", pkg.Kind())
+	}
+	fresh, err := m.reinstall(pkg, constructor, pkg.Description())
+	if err != nil {
+		return err
+	}
+	m.packages[pkg.FullName()] = fresh
+	m.setDigest(pkg.FullName(), artifactDigest(fresh))
+	return nil
+}
+
+// orphans returns files of the storage that don't belong to any package.
+// Only files named like artifacts and unfinished downloads can be removed,
+// other files are reported but never touched.
+func (m *PackageManager) orphans(owned map[string]bool) ([]StorageProblem, error) {
+	entries, err := os.ReadDir(m.path)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{
+		filepath.Base(m.lock.path): true,
+		storagelock.Name:           true,
+	}
+	problems := make([]StorageProblem, 0)
+	for _, entry := range entries {
+		path := filepath.Join(m.path, entry.Name())
+		if known[entry.Name()] || owned[filepath.Clean(path)] {
+			continue
+		}
+		problem := StorageProblem{Path: path}
+		switch {
+		case entry.IsDir():
+			problem.Message = "unknown directory"
+		case strings.HasSuffix(entry.Name(), ".tmp"):
+			problem.Message = "unfinished download"
+			problem.Fixable = true
+		case strings.Contains(entry.Name(), "@"):
+			problem.Message = "artifact of a package that is not locked"
+			problem.Fixable = true
+		default:
+			problem.Message = "unknown file"
+		}
+		problems = append(problems, problem)
+	}
+	return problems, nil
+}
@@ -0,0 +1,465 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// ExportOptions describe how packages are written to the export directory.
+type ExportOptions struct {
+	// Incremental export updates only files recorded in the export state
+	// and keeps every other file of the directory.
+	Incremental bool
+	// Format selects whether packages are written to a directory or
+	// to a single archive file.
+	Format string
+	// Prefix is a directory inside the archive the tree is placed to.
+	Prefix string
+	// Dry export only computes changes and does not touch the target.
+	Dry bool
+	// Resources is the format Lovelace resources of exported plugins are
+	// written in. Resources are not written if it is empty.
+	Resources string
+	// Compress writes gzip-compressed copies of plugin assets.
+	Compress bool
+}
+
+type ExportResult struct {
+	PostExportFiles map[string][]string
+	Changes         []ExportChange
+	Archive         *ArchiveInfo
+	// Resources is the path of generated Lovelace resources relative to
+	// the export root.
+	Resources string
+	// Conflicts and Unmanaged are filled by dry export only, real export
+	// fails with an error instead.
+	Conflicts []ExportConflict
+	Unmanaged []string
+}
+
+// ExportConflict describes a file that is exported by several packages.
+type ExportConflict struct {
+	Path     string
+	Packages []string
+}
+
+// ConflictError is returned when packages export overlapping files.
+type ConflictError struct {
+	Conflicts []ExportConflict
+}
+
+func (e *ConflictError) Error() string {
+	lines := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		lines = append(lines, conflict.Path+" ("+strings.Join(conflict.Packages, ", ")+")")
+	}
+	return "packages export conflicting files: " + strings.Join(lines, "; ")
+}
+
+// exportFile is a file of the staged export tree.
+type exportFile struct {
+	Path    string
+	Package string
+	Kind    string
+}
+
+// exportPlan is an export tree staged in a temporary directory.
+// Packages are exported separately first, so overlapping files can be
+// detected before anything is written to the target.
+type exportPlan struct {
+	root      string
+	tree      string
+	files     []exportFile
+	conflicts []ExportConflict
+	result    *ExportResult
+}
+
+func (m *PackageManager) Export(path string, opts ExportOptions) (*ExportResult, error) {
+	if err := validateFormat(opts.Format); err != nil {
+		return nil, err
+	}
+	archive := IsArchiveFormat(opts.Format)
+	if archive && opts.Incremental {
+		return nil, fmt.Errorf("incremental export is not supported for %s format", opts.Format)
+	}
+	if !archive && opts.Prefix != "" {
+		return nil, fmt.Errorf("prefix is supported only for archive formats")
+	}
+	if err := hapkg.ValidateResourcesFormat(opts.Resources); err != nil {
+		return nil, err
+	}
+
+	plan, err := m.stageExport(opts.Compress)
+	if err != nil {
+		return nil, err
+	}
+	defer plan.cleanup()
+	if opts.Resources != "" {
+		if err := plan.writeResources(opts.Resources, m.pluginVersions()); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Dry {
+		return plan.dryRun(path, opts)
+	}
+	if len(plan.conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: plan.conflicts}
+	}
+	if archive {
+		info, err := plan.writeArchive(path, opts.Format, opts.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		plan.result.Archive = info
+		return plan.result, nil
+	}
+	if opts.Incremental {
+		return plan.exportIncremental(path)
+	}
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := copyTree(plan.tree, path); err != nil {
+		return nil, err
+	}
+	return plan.result, nil
+}
+
+func (p *exportPlan) exportIncremental(path string) (*ExportResult, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	state, err := loadExportState(path)
+	if err != nil {
+		return nil, err
+	}
+	changes, next, unmanaged, err := p.incrementalChanges(path, state)
+	if err != nil {
+		return nil, err
+	}
+	if len(unmanaged) > 0 {
+		return nil, &UnmanagedError{Paths: unmanaged}
+	}
+	if err := p.applyChanges(path, changes); err != nil {
+		return nil, err
+	}
+	if err := next.dump(path); err != nil {
+		return nil, err
+	}
+	p.result.Changes = changes
+	return p.result, nil
+}
+
+// dryRun computes changes the export would make without touching
+// the target.
+func (p *exportPlan) dryRun(path string, opts ExportOptions) (*ExportResult, error) {
+	p.result.Conflicts = p.conflicts
+	switch {
+	case IsArchiveFormat(opts.Format):
+		changes, err := p.archiveChanges()
+		if err != nil {
+			return nil, err
+		}
+		p.result.Changes = changes
+	case opts.Incremental:
+		state, err := loadExportState(path)
+		if err != nil {
+			return nil, err
+		}
+		changes, _, unmanaged, err := p.incrementalChanges(path, state)
+		if err != nil {
+			return nil, err
+		}
+		p.result.Changes = changes
+		p.result.Unmanaged = unmanaged
+	default:
+		changes, err := p.fullChanges(path)
+		if err != nil {
+			return nil, err
+		}
+		p.result.Changes = changes
+	}
+	return p.result, nil
+}
+
+// fullChanges compares the staged tree with the target, which is removed
+// and written from scratch by the full export.
+func (p *exportPlan) fullChanges(path string) ([]ExportChange, error) {
+	owners := p.owners()
+	changes := make([]ExportChange, 0)
+	files, err := listFiles(p.tree)
+	if err != nil {
+		return nil, err
+	}
+	exported := make(map[string]bool, len(files))
+	for _, file := range files {
+		exported[file] = true
+		sum, size, err := hapkg.FileDigest(filepath.Join(p.tree, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		change := ExportChange{Path: file, Package: owners[file], Size: size, Operation: "add"}
+		current, _, err := hapkg.FileDigest(filepath.Join(path, filepath.FromSlash(file)))
+		if err == nil {
+			if current == sum {
+				continue
+			}
+			change.Operation = "update"
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	existing, err := listFiles(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return changes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range existing {
+		if exported[file] {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(path, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, ExportChange{Path: file, Operation: "delete", Size: info.Size()})
+	}
+	sortChanges(changes)
+	return changes, nil
+}
+
+// archiveChanges lists every file of the staged tree, since the archive
+// is always written from scratch.
+func (p *exportPlan) archiveChanges() ([]ExportChange, error) {
+	owners := p.owners()
+	files, err := listFiles(p.tree)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]ExportChange, 0, len(files))
+	for _, file := range files {
+		info, err := os.Lstat(filepath.Join(p.tree, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, ExportChange{Path: file, Package: owners[file], Size: info.Size(), Operation: "add"})
+	}
+	return changes, nil
+}
+
+// stageExport exports every package into its own staging directory,
+// checks them for conflicts and merges them into a single tree.
+func (m *PackageManager) stageExport(compress bool) (*exportPlan, error) {
+	root, err := os.MkdirTemp("", "hapm-export-")
+	if err != nil {
+		return nil, err
+	}
+	plan := &exportPlan{
+		root:   root,
+		tree:   filepath.Join(root, "tree"),
+		result: &ExportResult{PostExportFiles: map[string][]string{}},
+	}
+	if err := plan.stage(m.registry, m.sortedPackages(), compress); err != nil {
+		plan.cleanup()
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (p *exportPlan) stage(registry Registry, packages []hapkg.Package, compress bool) error {
+	owners := map[string][]string{}
+	staged := make([]string, len(packages))
+	for i, pkg := range packages {
+		dir := filepath.Join(p.root, strconv.Itoa(i))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if hook, ok := registry.PreExport[pkg.Kind()]; ok {
+			if err := hook(dir); err != nil {
+				return err
+			}
+		}
+		if err := exportPackage(pkg, dir, compress); err != nil {
+			return fmt.Errorf("exporting %s: %w", pkg.FullName(), err)
+		}
+		files, err := listFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			owners[file] = append(owners[file], pkg.FullName())
+			p.files = append(p.files, exportFile{Path: file, Package: pkg.FullName(), Kind: pkg.Kind()})
+		}
+		staged[i] = dir
+	}
+	p.conflicts = findConflicts(owners)
+
+	if err := os.MkdirAll(p.tree, 0o755); err != nil {
+		return err
+	}
+	kinds := make([]string, 0)
+	for i, pkg := range packages {
+		if len(kinds) == 0 || kinds[len(kinds)-1] != pkg.Kind() {
+			kinds = append(kinds, pkg.Kind())
+			if hook, ok := registry.PreExport[pkg.Kind()]; ok {
+				if err := hook(p.tree); err != nil {
+					return err
+				}
+			}
+		}
+		if err := copyTree(staged[i], p.tree); err != nil {
+			return err
+		}
+	}
+	for _, kind := range kinds {
+		if hook, ok := registry.PostExport[kind]; ok {
+			files, err := hook(p.tree)
+			if err != nil {
+				return err
+			}
+			if len(files) > 0 {
+				p.result.PostExportFiles[kind] = files
+			}
+		}
+	}
+	return nil
+}
+
+// writeResources adds Lovelace resources of exported plugins to the tree.
+func (p *exportPlan) writeResources(format string, versions map[string]string) error {
+	resources := hapkg.PluginResources(p.result.PostExportFiles[hapkg.PluginKind], versions)
+	path, err := hapkg.WriteLovelaceResources(p.tree, format, resources)
+	if err != nil {
+		return err
+	}
+	p.files = append(p.files, exportFile{Path: path})
+	p.result.Resources = path
+	return nil
+}
+
+// pluginVersions returns versions of installed plugins keyed by the name
+// of the folder they are exported to.
+func (m *PackageManager) pluginVersions() map[string]string {
+	versions := map[string]string{}
+	for _, pkg := range m.packages {
+		if pkg.Kind() == hapkg.PluginKind {
+			versions[pkg.Description().ShortName()] = pkg.Version()
+		}
+	}
+	return versions
+}
+
+func exportPackage(pkg hapkg.Package, dir string, compress bool) error {
+	if compressor, ok := pkg.(hapkg.Compressor); ok && compress {
+		return compressor.ExportCompressed(dir)
+	}
+	return pkg.Export(dir)
+}
+
+func (p *exportPlan) cleanup() {
+	_ = os.RemoveAll(p.root)
+}
+
+func findConflicts(owners map[string][]string) []ExportConflict {
+	conflicts := make([]ExportConflict, 0)
+	for path, packages := range owners {
+		if len(packages) > 1 {
+			conflicts = append(conflicts, ExportConflict{Path: path, Packages: packages})
+		}
+	}
+	sort.Slice(conflicts, func(i int, j int) bool {
+		return conflicts[i].Path < conflicts[j].Path
+	})
+	return conflicts
+}
+
+// listFiles returns slash-separated paths of regular files inside root.
+func listFiles(root string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// copyTree copies contents of src directory into dst, keeping file modes.
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case entry.Type()&fs.ModeSymlink != 0:
+			linkname, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return os.Symlink(linkname, target)
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,210 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// ExportStateName is the name of the file that records which files of the
+// export directory are managed by hapm.
+const ExportStateName = ".hapm-export.json"
+
+// ExportChange describes a change of a single exported file.
+type ExportChange struct {
+	Path      string `json:"path"`
+	Operation string `json:"operation"`
+	Package   string `json:"package,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// UnmanagedError is returned when incremental export would overwrite files
+// that were not created by hapm.
+type UnmanagedError struct {
+	Paths []string
+}
+
+func (e *UnmanagedError) Error() string {
+	return "export would overwrite unmanaged files: " + strings.Join(e.Paths, ", ")
+}
+
+type exportState struct {
+	Files map[string]string `json:"files"`
+	// Owners maps files to full names of packages that exported them.
+	Owners map[string]string `json:"owners,omitempty"`
+}
+
+func loadExportState(path string) (*exportState, error) {
+	state := &exportState{Files: map[string]string{}}
+	content, err := os.ReadFile(filepath.Join(path, ExportStateName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", ExportStateName, err)
+	}
+	if state.Files == nil {
+		state.Files = map[string]string{}
+	}
+	for file := range state.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file)) {
+			return nil, fmt.Errorf("%s lists path outside of the export: %s", ExportStateName, file)
+		}
+	}
+	return state, nil
+}
+
+func (s *exportState) dump(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(path, ExportStateName), content, 0o644)
+}
+
+// incrementalChanges compares the staged tree with the export directory.
+// Files listed in the previous state are owned by hapm and may be updated
+// or deleted, any other existing file is left untouched and is not taken
+// over even if its content matches.
+func (p *exportPlan) incrementalChanges(
+	path string,
+	state *exportState,
+) ([]ExportChange, *exportState, []string, error) {
+	owners := p.owners()
+	next := &exportState{Files: map[string]string{}, Owners: map[string]string{}}
+	changes := make([]ExportChange, 0)
+	unmanaged := make([]string, 0)
+
+	files, err := listFiles(p.tree)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, file := range files {
+		source := filepath.Join(p.tree, filepath.FromSlash(file))
+		sum, size, err := hapkg.FileDigest(source)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		change := ExportChange{Path: file, Package: owners[file], Size: size}
+		_, owned := state.Files[file]
+		current, _, err := hapkg.FileDigest(filepath.Join(path, filepath.FromSlash(file)))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			change.Operation = "add"
+		case err != nil:
+			return nil, nil, nil, err
+		case !owned && current == sum:
+			// Unmanaged file with the same content is left alone.
+			continue
+		case !owned:
+			unmanaged = append(unmanaged, file)
+			continue
+		case current != sum:
+			change.Operation = "update"
+		}
+		next.Files[file] = sum
+		if owners[file] != "" {
+			next.Owners[file] = owners[file]
+		}
+		if change.Operation != "" {
+			changes = append(changes, change)
+		}
+	}
+
+	for file := range state.Files {
+		if _, ok := next.Files[file]; ok {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(path, filepath.FromSlash(file)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		changes = append(changes, ExportChange{
+			Path:      file,
+			Operation: "delete",
+			Package:   state.Owners[file],
+			Size:      info.Size(),
+		})
+	}
+	sortChanges(changes)
+	return changes, next, unmanaged, nil
+}
+
+func sortChanges(changes []ExportChange) {
+	sort.SliceStable(changes, func(i int, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+}
+
+// applyChanges writes staged files to the export directory and removes
+// files that are no longer exported.
+func (p *exportPlan) applyChanges(path string, changes []ExportChange) error {
+	for _, change := range changes {
+		target := filepath.Join(path, filepath.FromSlash(change.Path))
+		if change.Operation == "delete" {
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			removeEmptyParents(path, filepath.Dir(target))
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		source := filepath.Join(p.tree, filepath.FromSlash(change.Path))
+		info, err := os.Lstat(source)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			linkname, err := os.Readlink(source)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(linkname, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyFile(source, target, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// owners returns exported files mapped to full names of their packages.
+func (p *exportPlan) owners() map[string]string {
+	owners := make(map[string]string, len(p.files))
+	for _, file := range p.files {
+		owners[file.Path] = file.Package
+	}
+	return owners
+}
+
+// removeEmptyParents removes empty directories starting from dir up to
+// the root, which is kept.
+func removeEmptyParents(root string, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
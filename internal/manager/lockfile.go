@@ -7,6 +7,12 @@ import (
 	"github.com/mishamyrt/hapm/internal/hapkg"
 )
 
+// LockEntry is a locked package with digest of its stored artifact.
+type LockEntry struct {
+	hapkg.PackageDescription
+	Digest string `json:"digest,omitempty"`
+}
+
 type Lockfile struct {
 	path string
 }
@@ -20,23 +26,23 @@ func (l *Lockfile) Exists() bool {
 	return err == nil
 }
 
-func (l *Lockfile) Dump(descriptions []hapkg.PackageDescription) error {
-	content, err := json.Marshal(descriptions)
+func (l *Lockfile) Dump(entries []LockEntry) error {
+	content, err := json.Marshal(entries)
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(l.path, content, 0o644)
 }
 
-func (l *Lockfile) Load() ([]hapkg.PackageDescription, error) {
+func (l *Lockfile) Load() ([]LockEntry, error) {
 	content, err := os.ReadFile(l.path)
 	if err != nil {
 		return nil, err
 	}
 	if len(content) == 0 {
-		return []hapkg.PackageDescription{}, nil
+		return []LockEntry{}, nil
 	}
-	items := []hapkg.PackageDescription{}
+	items := []LockEntry{}
 	if err := json.Unmarshal(content, &items); err != nil {
 		return nil, err
 	}
@@ -2,13 +2,13 @@ package manager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
 
-	"github.com/mishamyrt/hapm/internal/github"
 	"github.com/mishamyrt/hapm/internal/manifest"
 	"github.com/mishamyrt/hapm/internal/hapkg"
 )
@@ -21,10 +21,28 @@ type PackageManager struct {
 	client   hapkg.GitClient
 	registry Registry
 	packages map[string]hapkg.Package
+	// digests of stored artifacts keyed by package full name.
+	digests map[string]string
+
+	haVersion string
+	compatMu  sync.Mutex
+	compat    map[string]string
+	// core caches whether Home Assistant ships the domain, coreRef is
+	// the ref of the core repository domains are looked up at and coreErr
+	// is the lookup failure that stops further lookups.
+	core    map[string]bool
+	coreRef string
+	coreErr error
+	offline bool
+
+	concurrency int
+	keepGoing   bool
+	// policies of manifest entries keyed by package full name.
+	policies map[string]hapkg.UpdatePolicy
 }
 
-func New(path string, token string) (*PackageManager, error) {
-	return NewWith(path, github.NewClient(token), DefaultRegistry(), "_lock.json")
+func New(path string, client hapkg.GitClient) (*PackageManager, error) {
+	return NewWith(path, client, DefaultRegistry(), "_lock.json")
 }
 
 func NewWith(path string, client hapkg.GitClient, registry Registry, lockfileName string) (*PackageManager, error) {
@@ -37,6 +55,11 @@ func NewWith(path string, client hapkg.GitClient, registry Registry, lockfileNam
 		client:   client,
 		registry: registry,
 		packages: map[string]hapkg.Package{},
+		digests:  map[string]string{},
+		compat:   map[string]string{},
+		core:     map[string]bool{},
+
+		concurrency: maxApplyConcurrency,
 	}
 	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
 		if manager.lock.Exists() {
@@ -59,64 +82,181 @@ func (m *PackageManager) SupportedTypes() []string {
 }
 
 func (m *PackageManager) GetVersions(location manifest.PackageLocation) ([]string, error) {
-	return m.client.GetVersions(location.FullName)
+	versions, err := m.client.GetVersions(location.FullName)
+	if errors.Is(err, hapkg.ErrOffline) {
+		return nil, &OfflineError{Packages: []string{location.FullName}}
+	}
+	return versions, err
 }
 
 func (m *PackageManager) bootFromLock() error {
-	descriptions, err := m.lock.Load()
+	entries, err := m.lock.Load()
 	if err != nil {
 		return err
 	}
-	for _, description := range descriptions {
-		constructor, ok := m.registry.Constructors[description.Kind]
+	for _, entry := range entries {
+		constructor, ok := m.registry.Constructors[entry.Kind]
 		if !ok {
-			return fmt.Errorf("unsupported package kind: %s", description.Kind)
+			return fmt.Errorf("unsupported package kind: %s", entry.Kind)
 		}
-		pkg := constructor(description, m.path, m.client)
+		pkg := constructor(entry.PackageDescription, m.path, m.client)
 		m.packages[pkg.FullName()] = pkg
+		if entry.Digest != "" {
+			m.digests[pkg.FullName()] = entry.Digest
+		}
 	}
 	return nil
 }
 
+// diffResult is an outcome of comparing a single manifest entry with
+// the storage.
+type diffResult struct {
+	diff         PackageDiff
+	incompatible *Incompatibility
+	offline      bool
+	err          error
+}
+
+// Diff compares manifest entries with the storage and returns changes that
+// bring the storage to the manifest. In keep-going mode packages that can't
+// be resolved are left out of the diff and returned in ResolveError along
+// with changes of the other packages.
 func (m *PackageManager) Diff(update []hapkg.PackageDescription, stableOnly bool) ([]PackageDiff, error) {
+	results := make([]diffResult, len(update))
+	m.forEach(len(update), func(index int) {
+		results[index] = m.diffEntry(update[index], stableOnly)
+	})
+
 	updateFullNames := map[string]struct{}{}
 	diffs := make([]PackageDiff, 0)
-
-	for _, description := range update {
-		current := description.Copy()
-		if current.Version == "latest" {
-			versions, err := m.client.GetVersions(current.FullName)
-			if err != nil {
-				return nil, err
-			}
-			current.Version = hapkg.FindLatestVersion(versions, stableOnly)
-		}
-		updateFullNames[current.FullName] = struct{}{}
-		diff := PackageDiff{PackageDescription: current}
-		if existing, ok := m.packages[current.FullName]; ok {
-			if existing.Version() != current.Version {
-				diff.CurrentVersion = existing.Version()
-				diff.Operation = "switch"
+	incompatible := make([]Incompatibility, 0)
+	offline := make([]string, 0)
+	failed := make([]PackageError, 0)
+	for i, result := range results {
+		switch {
+		case result.err != nil:
+			// Failed packages stay in the manifest and must not be deleted.
+			updateFullNames[update[i].FullName] = struct{}{}
+			failed = append(failed, PackageError{FullName: update[i].FullName, Err: result.err})
+		case result.offline:
+			offline = append(offline, update[i].FullName)
+		case result.incompatible != nil:
+			incompatible = append(incompatible, *result.incompatible)
+		default:
+			updateFullNames[result.diff.FullName] = struct{}{}
+			if result.diff.Operation != "" {
+				diffs = append(diffs, result.diff)
 			}
-		} else {
-			diff.Operation = "add"
-		}
-		if diff.Operation != "" {
-			diffs = append(diffs, diff)
 		}
 	}
+	if len(failed) > 0 && !m.keepGoing {
+		return nil, &ResolveError{Packages: failed}
+	}
+	if len(offline) > 0 {
+		return nil, &OfflineError{Packages: offline}
+	}
+	if len(incompatible) > 0 {
+		return nil, &CompatibilityError{HomeAssistant: m.haVersion, Packages: incompatible}
+	}
 
-	for fullName, pkg := range m.packages {
-		if _, ok := updateFullNames[fullName]; ok {
+	for _, pkg := range m.sortedPackages() {
+		if _, ok := updateFullNames[pkg.FullName()]; ok {
 			continue
 		}
 		diff := PackageDiff{PackageDescription: pkg.Description(), Operation: "delete"}
 		diffs = append(diffs, diff)
 	}
 
+	if len(failed) > 0 {
+		return diffs, &ResolveError{Packages: failed}
+	}
 	return diffs, nil
 }
 
+// diffEntry resolves the version of the manifest entry and finds
+// the operation that brings the storage to it.
+func (m *PackageManager) diffEntry(description hapkg.PackageDescription, stableOnly bool) diffResult {
+	current := description.Copy()
+	if current.Version == "latest" {
+		latest, incompatibility, err := m.resolveLatest(current.FullName, stableOnly)
+		if errors.Is(err, hapkg.ErrOffline) {
+			return diffResult{offline: true}
+		}
+		if err != nil {
+			return diffResult{err: err}
+		}
+		if incompatibility != nil {
+			return diffResult{incompatible: incompatibility}
+		}
+		current.Version = latest
+	}
+	diff := PackageDiff{PackageDescription: current}
+	if existing, ok := m.packages[current.FullName]; ok {
+		if existing.Version() != current.Version {
+			diff.CurrentVersion = existing.Version()
+		}
+		switch {
+		case existing.Kind() != current.Kind:
+			diff.CurrentKind = existing.Kind()
+			diff.Operation = "reinstall"
+		case artifactMissing(existing):
+			diff.Operation = "reinstall"
+		case diff.CurrentVersion != "":
+			diff.Operation = "switch"
+		case !existing.Description().SameOptions(current):
+			diff.Operation = "configure"
+		}
+	} else {
+		diff.Operation = "add"
+	}
+	if diff.Operation == "add" || diff.Operation == "switch" || diff.Operation == "reinstall" {
+		if m.offline && !m.hasArtifact(current) {
+			return diffResult{offline: true}
+		}
+		incompatibility, err := m.checkCompatibility(current.FullName, current.Version)
+		if errors.Is(err, hapkg.ErrOffline) {
+			return diffResult{offline: true}
+		}
+		if err != nil {
+			return diffResult{err: err}
+		}
+		if incompatibility != nil {
+			return diffResult{incompatible: incompatibility}
+		}
+	}
+	return diffResult{diff: diff}
+}
+
+// ApplyError is returned by Apply in keep-going mode when some packages
+// failed. Changes of other packages are applied and locked.
+type ApplyError struct {
+	Packages []PackageError
+}
+
+func (e *ApplyError) Error() string {
+	return (&ResolveError{Packages: e.Packages}).Error()
+}
+
+func (e *ApplyError) Unwrap() []error {
+	return (&ResolveError{Packages: e.Packages}).Unwrap()
+}
+
+// Failed reports whether the package failed.
+func (e *ApplyError) Failed(fullName string) bool {
+	for _, pkg := range e.Packages {
+		if pkg.FullName == fullName {
+			return true
+		}
+	}
+	return false
+}
+
+// SetKeepGoing makes Diff and Apply continue after a package fails instead
+// of cancelling the remaining changes.
+func (m *PackageManager) SetKeepGoing(keepGoing bool) {
+	m.keepGoing = keepGoing
+}
+
 func (m *PackageManager) Apply(diffs []PackageDiff) error {
 	type applyJob struct {
 		index       int
@@ -129,6 +269,7 @@ func (m *PackageManager) Apply(diffs []PackageDiff) error {
 		operation string
 		fullName  string
 		pkg       hapkg.Package
+		digest    string
 		err       error
 	}
 
@@ -152,20 +293,40 @@ func (m *PackageManager) Apply(diffs []PackageDiff) error {
 			if !ok {
 				return fmt.Errorf("package is not installed: %s", diff.FullName)
 			}
-			jobs = append(jobs, applyJob{index: i, diff: diff, pkg: pkg})
+			constructor, ok := m.registry.Constructors[diff.Kind]
+			if !ok {
+				return fmt.Errorf("unsupported package kind: %s", diff.Kind)
+			}
+			jobs = append(jobs, applyJob{index: i, diff: diff, pkg: pkg, constructor: constructor})
+		case "configure":
+			if _, ok := m.packages[diff.FullName]; !ok {
+				return fmt.Errorf("package is not installed: %s", diff.FullName)
+			}
+			constructor, ok := m.registry.Constructors[diff.Kind]
+			if !ok {
+				return fmt.Errorf("unsupported package kind: %s", diff.Kind)
+			}
+			jobs = append(jobs, applyJob{index: i, diff: diff, constructor: constructor})
+		case "reinstall":
+			pkg, ok := m.packages[diff.FullName]
+			if !ok {
+				return fmt.Errorf("package is not installed: %s", diff.FullName)
+			}
+			constructor, ok := m.registry.Constructors[diff.Kind]
+			if !ok {
+				return fmt.Errorf("unsupported package kind: %s", diff.Kind)
+			}
+			jobs = append(jobs, applyJob{index: i, diff: diff, pkg: pkg, constructor: constructor})
 		default:
 			return fmt.Errorf("unsupported operation: %s", diff.Operation)
 		}
 	}
 
 	if len(jobs) == 0 {
-		return m.lock.Dump(m.Descriptions())
+		return m.dumpLock()
 	}
 
-	workers := len(jobs)
-	if workers > maxApplyConcurrency {
-		workers = maxApplyConcurrency
-	}
+	workers := m.workers(len(jobs))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -192,19 +353,33 @@ func (m *PackageManager) Apply(diffs []PackageDiff) error {
 				}
 				switch job.diff.Operation {
 				case "add":
-					pkg := job.constructor(job.diff.PackageDescription, m.path, m.client)
+					pkg := m.constrain(job.constructor(job.diff.PackageDescription, m.path, m.client))
 					if err := pkg.Setup(); err != nil {
 						result.err = err
 					} else {
 						result.pkg = pkg
+						result.digest = artifactDigest(pkg)
 					}
 				case "delete":
 					result.err = job.pkg.Destroy()
 				case "switch":
-					result.err = job.pkg.Switch(job.diff.Version)
+					// Switch only changes the version, so the package is built
+					// again from the description to pick up changed options.
+					result.err = m.constrain(job.pkg).Switch(job.diff.Version)
+					if result.err == nil {
+						result.pkg = job.constructor(job.diff.PackageDescription, m.path, m.client)
+						result.digest = artifactDigest(result.pkg)
+					}
+				case "configure":
+					result.pkg = job.constructor(job.diff.PackageDescription, m.path, m.client)
+				case "reinstall":
+					result.pkg, result.err = m.reinstall(job.pkg, job.constructor, job.diff.PackageDescription)
+					if result.err == nil {
+						result.digest = artifactDigest(result.pkg)
+					}
 				}
 				resultCh <- result
-				if result.err != nil {
+				if result.err != nil && !m.keepGoing {
 					cancel()
 					return
 				}
@@ -244,102 +419,260 @@ func (m *PackageManager) Apply(diffs []PackageDiff) error {
 			firstErr = result.err
 		}
 	}
-	if firstErr != nil {
+	if firstErr != nil && !m.keepGoing {
 		return firstErr
 	}
 
 	sort.Slice(results, func(i int, j int) bool {
 		return results[i].index < results[j].index
 	})
+	failed := make([]PackageError, 0)
 	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, PackageError{FullName: result.fullName, Err: result.err})
+			continue
+		}
 		switch result.operation {
-		case "add":
+		case "add", "reinstall", "switch":
+			m.packages[result.fullName] = result.pkg
+			m.setDigest(result.fullName, result.digest)
+		case "configure":
 			m.packages[result.fullName] = result.pkg
 		case "delete":
 			delete(m.packages, result.fullName)
+			delete(m.digests, result.fullName)
 		}
 	}
 
-	return m.lock.Dump(m.Descriptions())
-}
-
-type ExportResult struct {
-	PostExportFiles map[string][]string
+	if err := m.dumpLock(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &ApplyError{Packages: failed}
+	}
+	return nil
 }
 
-func (m *PackageManager) Export(path string) (*ExportResult, error) {
-	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
-		if err := os.RemoveAll(path); err != nil {
+// reinstall sets the package up again from the description and then
+// removes the previous artifact, which may be already missing. If both
+// artifacts are stored at the same path, the previous one is moved aside
+// and put back when setup fails. Packages without a known artifact path
+// are destroyed before setup.
+func (m *PackageManager) reinstall(
+	pkg hapkg.Package,
+	constructor Constructor,
+	description hapkg.PackageDescription,
+) (hapkg.Package, error) {
+	fresh := m.constrain(constructor(description, m.path, m.client))
+	previous, stored := artifactPath(pkg)
+	next, freshStored := artifactPath(fresh)
+	switch {
+	case !stored || !freshStored:
+		if err := pkg.Destroy(); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return nil, err
 		}
-	}
-	if err := os.MkdirAll(path, 0o755); err != nil {
-		return nil, err
-	}
-	kindsUsed := map[string]bool{}
-	for _, pkg := range m.packages {
-		if !kindsUsed[pkg.Kind()] {
-			if hook, ok := m.registry.PreExport[pkg.Kind()]; ok {
-				if err := hook(path); err != nil {
-					return nil, err
-				}
-			}
-			kindsUsed[pkg.Kind()] = true
+		if err := fresh.Setup(); err != nil {
+			return nil, err
 		}
-		if err := pkg.Export(path); err != nil {
+	case previous != next:
+		if err := fresh.Setup(); err != nil {
 			return nil, err
 		}
-	}
-	kinds := make([]string, 0, len(kindsUsed))
-	for kind := range kindsUsed {
-		kinds = append(kinds, kind)
-	}
-	sort.Strings(kinds)
-	result := &ExportResult{PostExportFiles: map[string][]string{}}
-	for _, kind := range kinds {
-		if hook, ok := m.registry.PostExport[kind]; ok {
-			files, err := hook(path)
-			if err != nil {
-				return nil, err
-			}
-			if len(files) > 0 {
-				result.PostExportFiles[kind] = files
+		if err := pkg.Destroy(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	default:
+		backup := fmt.Sprintf("%s.%d.tmp", previous, os.Getpid())
+		if err := os.Rename(previous, backup); errors.Is(err, os.ErrNotExist) {
+			backup = ""
+		} else if err != nil {
+			return nil, err
+		}
+		if err := fresh.Setup(); err != nil {
+			if backup != "" {
+				_ = os.Rename(backup, previous)
 			}
+			return nil, err
 		}
+		// A backup that can't be removed is reported by doctor as an
+		// unfinished download.
+		if backup != "" {
+			_ = os.Remove(backup)
+		}
+	}
+	return fresh, nil
+}
+
+// artifactPath returns the stored artifact location of the package and
+// reports whether the package keeps one.
+func artifactPath(pkg hapkg.Package) (string, bool) {
+	stored, ok := pkg.(hapkg.StoredPackage)
+	if !ok {
+		return "", false
+	}
+	return filepath.Clean(stored.ArtifactPath()), true
+}
+
+// artifactMissing reports whether the stored artifact of the package is
+// gone from the storage.
+func artifactMissing(pkg hapkg.Package) bool {
+	stored, ok := pkg.(hapkg.StoredPackage)
+	if !ok {
+		return false
 	}
-	return result, nil
+	_, err := os.Stat(stored.ArtifactPath())
+	return errors.Is(err, os.ErrNotExist)
 }
 
 func (m *PackageManager) Updates(stableOnly bool) ([]PackageDiff, error) {
+	return m.UpdatesWithin(stableOnly, hapkg.UpdateMajor)
+}
+
+// UpdatesWithin returns updates of installed packages that stay within
+// the update level, like patch releases only. Newer versions that package
+// policies hold back are returned too, with Held set.
+func (m *PackageManager) UpdatesWithin(stableOnly bool, level string) ([]PackageDiff, error) {
+	packages := m.sortedPackages()
+	latest := make([]packageUpdate, len(packages))
+	errs := make([]error, len(packages))
+	m.forEach(len(packages), func(index int) {
+		latest[index], errs[index] = m.packageUpdate(packages[index], stableOnly, level)
+	})
+
 	updates := make([]PackageDiff, 0)
-	for _, pkg := range m.packages {
-		latest, err := pkg.LatestVersion(stableOnly)
-		if err != nil {
-			return nil, err
+	offline := make([]string, 0)
+	failed := make([]PackageError, 0)
+	for i, pkg := range packages {
+		if errors.Is(errs[i], hapkg.ErrOffline) {
+			offline = append(offline, pkg.FullName())
+			continue
 		}
-		latestVersion, err := hapkg.NewVersion(latest)
-		if err != nil {
+		if errs[i] != nil {
+			failed = append(failed, PackageError{FullName: pkg.FullName(), Err: errs[i]})
 			continue
 		}
 		currentVersion, err := hapkg.NewVersion(pkg.Version())
 		if err != nil {
 			continue
 		}
-		if latestVersion.Compare(currentVersion) > 0 {
+		newest := currentVersion
+		if latestVersion, err := hapkg.NewVersion(latest[i].version); err == nil && latestVersion.Compare(currentVersion) > 0 {
+			newest = latestVersion
 			updates = append(updates, PackageDiff{
-				PackageDescription: hapkg.PackageDescription{FullName: pkg.FullName(), Kind: pkg.Kind(), Version: latest},
+				PackageDescription: hapkg.PackageDescription{FullName: pkg.FullName(), Kind: pkg.Kind(), Version: latest[i].version},
 				CurrentVersion:     pkg.Version(),
 				Operation:          "switch",
 			})
 		}
+		if heldVersion, err := hapkg.NewVersion(latest[i].held); err == nil && heldVersion.Compare(newest) > 0 {
+			updates = append(updates, PackageDiff{
+				PackageDescription: hapkg.PackageDescription{FullName: pkg.FullName(), Kind: pkg.Kind(), Version: latest[i].held},
+				CurrentVersion:     pkg.Version(),
+				Operation:          "switch",
+				Held:               latest[i].reason,
+			})
+		}
+	}
+	if len(failed) > 0 && !m.keepGoing {
+		return nil, &ResolveError{Packages: failed}
+	}
+	if len(offline) > 0 {
+		return nil, &OfflineError{Packages: offline}
 	}
 	return updates, nil
 }
 
+// packageLatestVersion returns the newest version of the installed package
+// within the update level that is compatible with the configured Home
+// Assistant.
+func (m *PackageManager) packageLatestVersion(pkg hapkg.Package, stableOnly bool, level string) (string, error) {
+	if m.haVersion == "" && level == hapkg.UpdateMajor {
+		return pkg.LatestVersion(stableOnly)
+	}
+	versions, err := m.client.GetVersions(pkg.FullName())
+	if err != nil {
+		return "", err
+	}
+	versions = hapkg.UpdatePolicy{}.Filter(pkg.Version(), versions, level)
+	latest, _, err := m.latestVersion(pkg.FullName(), versions, stableOnly)
+	return latest, err
+}
+
 func (m *PackageManager) Descriptions() []hapkg.PackageDescription {
-	descriptions := make([]hapkg.PackageDescription, 0, len(m.packages))
-	for _, pkg := range m.packages {
+	packages := m.sortedPackages()
+	descriptions := make([]hapkg.PackageDescription, 0, len(packages))
+	for _, pkg := range packages {
 		descriptions = append(descriptions, pkg.Description())
 	}
 	return descriptions
 }
+
+// dumpLock writes installed packages and digests of their artifacts to
+// the lockfile.
+func (m *PackageManager) dumpLock() error {
+	packages := m.sortedPackages()
+	entries := make([]LockEntry, 0, len(packages))
+	for _, pkg := range packages {
+		entries = append(entries, LockEntry{
+			PackageDescription: pkg.Description(),
+			Digest:             m.digests[pkg.FullName()],
+		})
+	}
+	return m.lock.Dump(entries)
+}
+
+func (m *PackageManager) setDigest(fullName string, digest string) {
+	if digest == "" {
+		delete(m.digests, fullName)
+		return
+	}
+	m.digests[fullName] = digest
+}
+
+// artifactDigest returns digest of the stored artifact of the package, or
+// empty string if it can't be calculated.
+func artifactDigest(pkg hapkg.Package) string {
+	stored, ok := pkg.(hapkg.StoredPackage)
+	if !ok {
+		return ""
+	}
+	digest, _, err := hapkg.FileDigest(stored.ArtifactPath())
+	if err != nil {
+		return ""
+	}
+	return digest
+}
+
+// sortedPackages returns installed packages ordered by kind and full name.
+func (m *PackageManager) sortedPackages() []hapkg.Package {
+	packages := make([]hapkg.Package, 0, len(m.packages))
+	for _, pkg := range m.packages {
+		packages = append(packages, pkg)
+	}
+	sort.Slice(packages, func(i int, j int) bool {
+		if packages[i].Kind() != packages[j].Kind() {
+			return packages[i].Kind() < packages[j].Kind()
+		}
+		return packages[i].FullName() < packages[j].FullName()
+	})
+	return packages
+}
+
+// Domains returns integration domains provided by installed packages
+// keyed by package full name. Packages whose artifact is missing are
+// skipped, sync reinstalls them.
+func (m *PackageManager) Domains() (map[string][]string, error) {
+	domains := map[string][]string{}
+	for fullName, pkg := range m.packages {
+		provider, ok := pkg.(hapkg.DomainProvider)
+		if !ok || artifactMissing(pkg) {
+			continue
+		}
+		items, err := provider.Domains()
+		if err != nil {
+			return nil, fmt.Errorf("reading domains of %s: %w", fullName, err)
+		}
+		domains[fullName] = items
+	}
+	return domains, nil
+}
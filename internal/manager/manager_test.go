@@ -1,9 +1,12 @@
 package manager
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,6 +20,8 @@ import (
 
 type fakeClient struct {
 	versions map[string][]string
+	// files are keyed by full name, version and path, like foo/bar@v1.0.0/hacs.json.
+	files map[string]string
 }
 
 func (f fakeClient) GetVersions(fullName string) ([]string, error) {
@@ -26,15 +31,22 @@ func (f fakeClient) GetVersions(fullName string) ([]string, error) {
 	return nil, errors.New("versions not found")
 }
 
-func (f fakeClient) GetTreeFile(string, string, string) ([]byte, error) {
+func (f fakeClient) GetTreeFile(fullName string, branch string, filePath string) ([]byte, error) {
+	if content, ok := f.files[fullName+"@"+branch+"/"+filePath]; ok {
+		return []byte(content), nil
+	}
+	return nil, fmt.Errorf("%s: %w", filePath, os.ErrNotExist)
+}
+
+func (f fakeClient) GetReleaseFile(string, string, string) (io.ReadCloser, error) {
 	return nil, errors.New("not implemented")
 }
 
-func (f fakeClient) GetReleaseFile(string, string, string) ([]byte, error) {
+func (f fakeClient) GetReleaseAssets(string, string) ([]string, error) {
 	return nil, errors.New("not implemented")
 }
 
-func (f fakeClient) GetTarball(string, string) ([]byte, error) {
+func (f fakeClient) GetTarball(string, string) (io.ReadCloser, error) {
 	return nil, errors.New("not implemented")
 }
 
@@ -42,16 +54,19 @@ type fakePackage struct {
 	desc   hapkg.PackageDescription
 	root   string
 	latest string
+	// client is used to look for the latest version if it is set.
+	client hapkg.GitClient
 
 	setupFn   func(*fakePackage) error
 	switchFn  func(*fakePackage, string) error
 	destroyFn func(*fakePackage) error
+	exportFn  func(*fakePackage, string) error
 }
 
 func (p *fakePackage) Description() hapkg.PackageDescription { return p.desc }
-func (p *fakePackage) FullName() string                        { return p.desc.FullName }
-func (p *fakePackage) Version() string                         { return p.desc.Version }
-func (p *fakePackage) Kind() string                            { return p.desc.Kind }
+func (p *fakePackage) FullName() string                      { return p.desc.FullName }
+func (p *fakePackage) Version() string                       { return p.desc.Version }
+func (p *fakePackage) Kind() string                          { return p.desc.Kind }
 
 func (p *fakePackage) Setup() error {
 	if p.setupFn != nil {
@@ -82,6 +97,9 @@ func (p *fakePackage) Destroy() error {
 }
 
 func (p *fakePackage) Export(path string) error {
+	if p.exportFn != nil {
+		return p.exportFn(p, path)
+	}
 	if err := os.MkdirAll(filepath.Join(path, p.desc.Kind), 0o755); err != nil {
 		return err
 	}
@@ -89,7 +107,14 @@ func (p *fakePackage) Export(path string) error {
 	return os.WriteFile(filepath.Join(path, p.desc.Kind, name), []byte(p.desc.Version), 0o644)
 }
 
-func (p *fakePackage) LatestVersion(bool) (string, error) {
+func (p *fakePackage) LatestVersion(stableOnly bool) (string, error) {
+	if p.client != nil {
+		versions, err := p.client.GetVersions(p.desc.FullName)
+		if err != nil {
+			return "", err
+		}
+		return hapkg.FindLatestVersion(versions, stableOnly), nil
+	}
 	return p.latest, nil
 }
 
@@ -98,18 +123,44 @@ func (p *fakePackage) filePath(version string) string {
 	return filepath.Join(p.root, name+"@"+version+".pkg")
 }
 
+// fakeIntegration is a package that provides integration domains.
+type fakeIntegration struct {
+	fakePackage
+	manifests []hapkg.IntegrationManifest
+	issues    []hapkg.ManifestIssue
+}
+
+func (p *fakeIntegration) Domains() ([]string, error) {
+	domains := make([]string, 0, len(p.manifests))
+	for _, manifest := range p.manifests {
+		domains = append(domains, manifest.Folder)
+	}
+	return domains, nil
+}
+
+func (p *fakeIntegration) Manifests() ([]hapkg.IntegrationManifest, error) {
+	return p.manifests, nil
+}
+
+func (p *fakeIntegration) Validate() ([]hapkg.ManifestIssue, error) {
+	return p.issues, nil
+}
+
 func TestLockfileRoundTrip(t *testing.T) {
 	tmp := t.TempDir()
 	lock := NewLockfile(filepath.Join(tmp, "_lock.json"))
-	descriptions := []hapkg.PackageDescription{{FullName: "foo/bar", Version: "v1.0.0", Kind: "integrations"}}
-	if err := lock.Dump(descriptions); err != nil {
+	entries := []LockEntry{{
+		PackageDescription: hapkg.PackageDescription{FullName: "foo/bar", Version: "v1.0.0", Kind: "integrations"},
+		Digest:             "sha256:abc",
+	}}
+	if err := lock.Dump(entries); err != nil {
 		t.Fatal(err)
 	}
 	items, err := lock.Load()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(items) != 1 || items[0].FullName != "foo/bar" {
+	if len(items) != 1 || items[0].FullName != "foo/bar" || items[0].Digest != "sha256:abc" {
 		t.Fatalf("unexpected items: %+v", items)
 	}
 }
@@ -166,7 +217,7 @@ func TestManagerDiffApplyUpdatesAndExport(t *testing.T) {
 	}
 
 	exportPath := filepath.Join(tmp, "export")
-	result, err := manager.Export(exportPath)
+	result, err := manager.Export(exportPath, ExportOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -186,169 +237,1568 @@ func TestManagerDiffApplyUpdatesAndExport(t *testing.T) {
 	}
 }
 
-func TestManagerApplyLimitsConcurrencyTo20(t *testing.T) {
+func TestManagerDiffConfigure(t *testing.T) {
 	tmp := t.TempDir()
-	started := make(chan struct{}, 64)
-	release := make(chan struct{})
-	var mu sync.Mutex
-	active := 0
-	maxActive := 0
-
 	registry := Registry{
 		Constructors: map[string]Constructor{
 			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
-				return &fakePackage{
-					desc: description,
-					root: rootPath,
-					setupFn: func(_ *fakePackage) error {
-						mu.Lock()
-						active++
-						if active > maxActive {
-							maxActive = active
-						}
-						mu.Unlock()
-
-						started <- struct{}{}
-						<-release
-
-						mu.Lock()
-						active--
-						mu.Unlock()
-						return nil
-					},
-				}
+				return &fakePackage{desc: description, root: rootPath}
 			},
 		},
 	}
-
 	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	diffs := make([]PackageDiff, 0, 30)
-	for i := 0; i < 30; i++ {
-		diffs = append(diffs, PackageDiff{
-			PackageDescription: hapkg.PackageDescription{
-				FullName: fmt.Sprintf("foo/pkg-%02d", i),
-				Kind:     "integrations",
-				Version:  "v1.0.0",
-			},
-			Operation: "add",
-		})
+	description := hapkg.PackageDescription{FullName: "foo/bar", Version: "v1.0.0", Kind: "integrations"}
+	if err := manager.Apply([]PackageDiff{{PackageDescription: description, Operation: "add"}}); err != nil {
+		t.Fatal(err)
 	}
 
-	applyErr := make(chan error, 1)
-	go func() {
-		applyErr <- manager.Apply(diffs)
-	}()
-
-	for i := 0; i < maxApplyConcurrency; i++ {
-		select {
-		case <-started:
-		case <-time.After(2 * time.Second):
-			t.Fatalf("timed out waiting for started task #%d", i+1)
-		}
+	description.Include = []string{"bar"}
+	diff, err := manager.Diff([]hapkg.PackageDescription{description}, true)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	select {
-	case <-started:
-		t.Fatal("expected at most 20 concurrent tasks before release")
-	case <-time.After(150 * time.Millisecond):
+	if len(diff) != 1 || diff[0].Operation != "configure" {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	descriptions := manager.Descriptions()
+	if len(descriptions) != 1 || len(descriptions[0].Include) != 1 {
+		t.Fatalf("unexpected descriptions: %+v", descriptions)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "foo-bar@v1.0.0.pkg")); err != nil {
+		t.Fatalf("expected stored package to be kept: %v", err)
 	}
 
-	close(release)
+	diff, err = manager.Diff([]hapkg.PackageDescription{description}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+}
 
-	select {
-	case err := <-applyErr:
-		if err != nil {
-			t.Fatal(err)
-		}
-	case <-time.After(2 * time.Second):
-		t.Fatal("timed out waiting for apply completion")
+func TestManagerDiffSwitchWithOptions(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	description := hapkg.PackageDescription{FullName: "foo/bar", Version: "v1.0.0", Kind: "integrations"}
+	if err := manager.Apply([]PackageDiff{{PackageDescription: description, Operation: "add"}}); err != nil {
+		t.Fatal(err)
 	}
 
-	mu.Lock()
-	gotMax := maxActive
-	gotActive := active
-	mu.Unlock()
-	if gotMax != maxApplyConcurrency {
-		t.Fatalf("unexpected max concurrency: got %d, want %d", gotMax, maxApplyConcurrency)
+	description.Version = "v1.1.0"
+	description.Include = []string{"bar"}
+	description.Exclude = []string{"baz"}
+	diff, err := manager.Diff([]hapkg.PackageDescription{description}, true)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if gotActive != 0 {
-		t.Fatalf("unexpected active tasks after apply: %d", gotActive)
+	if len(diff) != 1 || diff[0].Operation != "switch" {
+		t.Fatalf("unexpected diff: %+v", diff)
 	}
-	if len(manager.Descriptions()) != 30 {
-		t.Fatalf("unexpected package count: %d", len(manager.Descriptions()))
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	descriptions := manager.Descriptions()
+	if len(descriptions) != 1 || descriptions[0].Version != "v1.1.0" || !descriptions[0].SameOptions(description) {
+		t.Fatalf("unexpected descriptions: %+v", descriptions)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "foo-bar@v1.1.0.pkg")); err != nil {
+		t.Fatalf("expected switched package to be stored: %v", err)
+	}
+
+	diff, err = manager.Diff([]hapkg.PackageDescription{description}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Fatalf("unexpected diff: %+v", diff)
 	}
 }
 
-func TestManagerApplyCancelOnFirstError(t *testing.T) {
+func TestManagerExportConflicts(t *testing.T) {
 	tmp := t.TempDir()
-	sentinelErr := errors.New("boom")
-	release := make(chan struct{})
-	var releaseOnce sync.Once
-	var started atomic.Int32
-
 	registry := Registry{
 		Constructors: map[string]Constructor{
 			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
 				return &fakePackage{
 					desc: description,
 					root: rootPath,
-					setupFn: func(p *fakePackage) error {
-						started.Add(1)
-						if p.desc.FullName == "foo/pkg-00" {
-							releaseOnce.Do(func() {
-								close(release)
-							})
-							return sentinelErr
+					exportFn: func(p *fakePackage, path string) error {
+						dir := filepath.Join(path, "custom_components", "shared")
+						if err := os.MkdirAll(dir, 0o755); err != nil {
+							return err
 						}
-						<-release
-						return nil
+						own := filepath.Join(dir, strings.ReplaceAll(p.desc.FullName, "/", "-")+".py")
+						if err := os.WriteFile(own, nil, 0o644); err != nil {
+							return err
+						}
+						return os.WriteFile(filepath.Join(dir, "__init__.py"), []byte(p.desc.FullName), 0o644)
 					},
 				}
 			},
 		},
 	}
-
 	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
 	if err != nil {
 		t.Fatal(err)
 	}
+	diffs := []PackageDiff{
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/b", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/a", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
+	}
+	if err := manager.Apply(diffs); err != nil {
+		t.Fatal(err)
+	}
 
-	lockPath := filepath.Join(tmp, "_lock.json")
-	originalLock := []byte(`[{"full_name":"seed/pkg","kind":"integrations","version":"v1.0.0"}]`)
-	if err := os.WriteFile(lockPath, originalLock, 0o644); err != nil {
+	exportPath := filepath.Join(tmp, "export")
+	keepPath := filepath.Join(exportPath, "keep.txt")
+	if err := os.MkdirAll(exportPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keepPath, nil, 0o644); err != nil {
 		t.Fatal(err)
 	}
 
-	diffs := make([]PackageDiff, 0, 30)
-	for i := 0; i < 30; i++ {
-		diffs = append(diffs, PackageDiff{
-			PackageDescription: hapkg.PackageDescription{
-				FullName: fmt.Sprintf("foo/pkg-%02d", i),
-				Kind:     "integrations",
-				Version:  "v1.0.0",
-			},
-			Operation: "add",
-		})
+	result, err := manager.Export(exportPath, ExportOptions{Dry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("expected dry export to report conflicts: %+v", result.Conflicts)
 	}
 
-	err = manager.Apply(diffs)
-	if !errors.Is(err, sentinelErr) {
+	_, err = manager.Export(exportPath, ExportOptions{})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if len(conflictErr.Conflicts) != 1 {
+		t.Fatalf("unexpected conflicts: %+v", conflictErr.Conflicts)
+	}
+	conflict := conflictErr.Conflicts[0]
+	if conflict.Path != "custom_components/shared/__init__.py" || strings.Join(conflict.Packages, ",") != "foo/a,foo/b" {
+		t.Fatalf("unexpected conflict: %+v", conflict)
+	}
+	if _, err := os.Stat(keepPath); err != nil {
+		t.Fatalf("export target was changed: %v", err)
+	}
+}
 
-	if got := int(started.Load()); got >= len(diffs) {
-		t.Fatalf("expected cancellation before all jobs started, got %d started of %d", got, len(diffs))
+func TestManagerExportIncremental(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := hapkg.PackageDescription{FullName: "foo/a", Kind: "integrations", Version: "v1.0.0"}
+	second := hapkg.PackageDescription{FullName: "foo/b", Kind: "integrations", Version: "v1.0.0"}
+	if err := manager.Apply([]PackageDiff{
+		{PackageDescription: first, Operation: "add"},
+		{PackageDescription: second, Operation: "add"},
+	}); err != nil {
+		t.Fatal(err)
 	}
 
-	after, err := os.ReadFile(lockPath)
+	exportPath := filepath.Join(tmp, "export")
+	unmanaged := filepath.Join(exportPath, "integrations", "mine.txt")
+	if err := os.MkdirAll(filepath.Dir(unmanaged), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unmanaged, []byte("mine"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := manager.Export(exportPath, ExportOptions{Incremental: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(after, originalLock) {
-		t.Fatalf("lockfile was unexpectedly changed: %s", string(after))
+	if len(result.Changes) != 2 || result.Changes[0].Operation != "add" || result.Changes[0].Package != "foo/a" {
+		t.Fatalf("unexpected changes: %+v", result.Changes)
+	}
+
+	result, err = manager.Export(exportPath, ExportOptions{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 0 {
+		t.Fatalf("unexpected changes on repeated export: %+v", result.Changes)
+	}
+
+	if err := manager.Apply([]PackageDiff{
+		{PackageDescription: second, Operation: "delete"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/a", Kind: "integrations", Version: "v2.0.0"}, Operation: "switch"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = manager.Export(exportPath, ExportOptions{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations := make([]string, 0, len(result.Changes))
+	for _, change := range result.Changes {
+		operations = append(operations, change.Path+":"+change.Operation)
+	}
+	if strings.Join(operations, ",") != "integrations/foo-a.txt:update,integrations/foo-b.txt:delete" {
+		t.Fatalf("unexpected changes: %+v", operations)
+	}
+	if content, err := os.ReadFile(unmanaged); err != nil || string(content) != "mine" {
+		t.Fatalf("unmanaged file was changed: %q, %v", string(content), err)
+	}
+	if _, err := os.Stat(filepath.Join(exportPath, "integrations", "foo-b.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected removed package file to be deleted: %v", err)
+	}
+
+	foreign := filepath.Join(exportPath, "integrations", "foo-b.txt")
+	if err := os.WriteFile(foreign, []byte("foreign"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply([]PackageDiff{{PackageDescription: second, Operation: "add"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = manager.Export(exportPath, ExportOptions{Incremental: true})
+	var unmanagedErr *UnmanagedError
+	if !errors.As(err, &unmanagedErr) || len(unmanagedErr.Paths) != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content, err := os.ReadFile(foreign); err != nil || string(content) != "foreign" {
+		t.Fatalf("unmanaged file was changed: %q, %v", string(content), err)
+	}
+
+	if err := os.WriteFile(foreign, []byte("v1.0.0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = manager.Export(exportPath, ExportOptions{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 0 {
+		t.Fatalf("unexpected changes for identical unmanaged file: %+v", result.Changes)
+	}
+	if err := manager.Apply([]PackageDiff{{PackageDescription: second, Operation: "delete"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = manager.Export(exportPath, ExportOptions{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 0 {
+		t.Fatalf("identical unmanaged file was taken over: %+v", result.Changes)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Fatalf("identical unmanaged file was deleted: %v", err)
+	}
+
+	outside := filepath.Join(tmp, "outside.txt")
+	if err := os.WriteFile(outside, []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := `{"files": {"../outside.txt": "0000"}}`
+	if err := os.WriteFile(filepath.Join(exportPath, ExportStateName), []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Export(exportPath, ExportOptions{Incremental: true}); err == nil {
+		t.Fatal("expected error for state path outside of the export")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Fatalf("file outside of the export was deleted: %v", err)
+	}
+}
+
+func TestManagerExportResources(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			hapkg.PluginKind: func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath}
+			},
+		},
+		PostExport: map[string]func(path string) ([]string, error){
+			hapkg.PluginKind: func(_ string) ([]string, error) {
+				return []string{"card/card.js"}, nil
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply([]PackageDiff{
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/card", Kind: hapkg.PluginKind, Version: "v1.0.0"}, Operation: "add"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exportPath := filepath.Join(tmp, "export")
+	if _, err := manager.Export(exportPath, ExportOptions{Resources: "toml"}); err == nil {
+		t.Fatalf("expected unsupported resources format error")
+	}
+	result, err := manager.Export(exportPath, ExportOptions{Resources: hapkg.ResourcesYAML, Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resources != "lovelace_resources.yaml" || len(result.Changes) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	content, err := os.ReadFile(filepath.Join(exportPath, result.Resources))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "url: /local/custom_lovelace/card/card.js?v=v1.0.0") {
+		t.Fatalf("unexpected resources: %s", content)
+	}
+}
+
+func TestManagerExportDryRun(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply([]PackageDiff{
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/a", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/b", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exportPath := filepath.Join(tmp, "export")
+	files := map[string]string{
+		"integrations/foo-a.txt": "v1.0.0",
+		"integrations/foo-b.txt": "v0.9.0",
+		"stale.txt":              "stale",
+	}
+	for name, content := range files {
+		path := filepath.Join(exportPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := manager.Export(exportPath, ExportOptions{Dry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations := make([]string, 0, len(result.Changes))
+	for _, change := range result.Changes {
+		operations = append(operations, change.Path+":"+change.Operation+":"+change.Package)
+	}
+	if strings.Join(operations, ",") != "integrations/foo-b.txt:update:foo/b,stale.txt:delete:" {
+		t.Fatalf("unexpected plan: %+v", operations)
+	}
+
+	result, err = manager.Export(exportPath, ExportOptions{Dry: true, Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unmanaged) != 1 || result.Unmanaged[0] != "integrations/foo-b.txt" {
+		t.Fatalf("unexpected unmanaged files: %+v", result.Unmanaged)
+	}
+
+	for name, content := range files {
+		current, err := os.ReadFile(filepath.Join(exportPath, filepath.FromSlash(name)))
+		if err != nil || string(current) != content {
+			t.Fatalf("dry export changed %s: %q, %v", name, string(current), err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(exportPath, ExportStateName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("dry export wrote state: %v", err)
+	}
+}
+
+func TestManagerExportArchiveIsReproducible(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply([]PackageDiff{
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/b", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/a", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := make([][]byte, 0, 2)
+	for _, name := range []string{"first.tar.gz", "second.tar.gz"} {
+		path := filepath.Join(tmp, name)
+		result, err := manager.Export(path, ExportOptions{Format: FormatOCILayer, Prefix: "config"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Archive == nil || !strings.HasPrefix(result.Archive.Digest, "sha256:") {
+			t.Fatalf("unexpected archive info: %+v", result.Archive)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, content)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !bytes.Equal(contents[0], contents[1]) {
+		t.Fatalf("archives differ")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(contents[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := tar.NewReader(gz)
+	names := make([]string, 0)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !header.ModTime.Equal(time.Unix(0, 0)) || header.Uid != 0 || header.Gid != 0 {
+			t.Fatalf("unexpected header: %+v", header)
+		}
+		names = append(names, header.Name)
+	}
+	expected := "config/,config/integrations/,config/integrations/foo-a.txt,config/integrations/foo-b.txt"
+	if strings.Join(names, ",") != expected {
+		t.Fatalf("unexpected entries: %+v", names)
+	}
+
+	if _, err := manager.Export(filepath.Join(tmp, "bad"), ExportOptions{Format: "zip"}); err == nil {
+		t.Fatalf("expected unsupported format error")
+	}
+	if _, err := manager.Export(filepath.Join(tmp, "bad"), ExportOptions{Format: FormatTar, Incremental: true}); err == nil {
+		t.Fatalf("expected incremental archive error")
+	}
+}
+
+func TestManagerValidate(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				pkg := &fakeIntegration{fakePackage: fakePackage{desc: description, root: rootPath}}
+				switch description.FullName {
+				case "foo/base":
+					pkg.manifests = []hapkg.IntegrationManifest{{Folder: "base", Domain: "base"}}
+				case "foo/app":
+					pkg.manifests = []hapkg.IntegrationManifest{{
+						Folder:            "app",
+						Domain:            "app",
+						Dependencies:      []string{"base", "http"},
+						AfterDependencies: []string{"base"},
+					}}
+					pkg.issues = []hapkg.ManifestIssue{{Domain: "app", Severity: hapkg.IssueWarning, Message: "required key name is missing"}}
+				case "foo/orphan":
+					pkg.manifests = []hapkg.IntegrationManifest{{
+						Folder:       "orphan",
+						Domain:       "orphan",
+						Dependencies: []string{"http", "never_installed"},
+					}}
+				}
+				return pkg
+			},
+		},
+	}
+	client := fakeClient{files: map[string]string{
+		"home-assistant/core@dev/homeassistant/components/http/manifest.json": `{"domain": "http"}`,
+	}}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := manager.Diff([]hapkg.PackageDescription{
+		{FullName: "foo/base", Version: "v1.0.0", Kind: "integrations"},
+		{FullName: "foo/app", Version: "v1.0.0", Kind: "integrations"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := manager.Validate(diff, map[string][]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Package != "foo/app" || len(issues[0].Issues) != 1 || HasIssueErrors(issues) {
+		t.Fatalf("unexpected issues: %+v", issues)
+	}
+
+	previous, err := manager.Domains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err = manager.Diff([]hapkg.PackageDescription{{FullName: "foo/app", Version: "v1.0.0", Kind: "integrations"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	issues, err = manager.Validate(diff, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || len(issues[0].Issues) != 2 || !HasIssueErrors(issues) {
+		t.Fatalf("unexpected issues: %+v", issues)
+	}
+	if issues[0].Issues[0].Message != "depends on base, which is no longer provided by foo/base" {
+		t.Fatalf("unexpected dependency issue: %+v", issues[0].Issues[0])
+	}
+
+	diff, err = manager.Diff([]hapkg.PackageDescription{
+		{FullName: "foo/app", Version: "v1.0.0", Kind: "integrations"},
+		{FullName: "foo/orphan", Version: "v1.0.0", Kind: "integrations"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	issues, err = manager.Validate(diff, map[string][]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Package != "foo/orphan" || len(issues[0].Issues) != 1 {
+		t.Fatalf("unexpected issues: %+v", issues)
+	}
+	if issues[0].Issues[0].Message != "depends on never_installed, which is not provided by any package or Home Assistant" {
+		t.Fatalf("unexpected dependency issue: %+v", issues[0].Issues[0])
+	}
+}
+
+// rateLimitedClient fails every lookup in the Home Assistant repository.
+type rateLimitedClient struct {
+	fakeClient
+	requests int
+}
+
+func (c *rateLimitedClient) GetTreeFile(fullName string, branch string, filePath string) ([]byte, error) {
+	if fullName != coreRepository {
+		return c.fakeClient.GetTreeFile(fullName, branch, filePath)
+	}
+	c.requests++
+	return nil, errors.New("http status: 403: rate limit exceeded")
+}
+
+func TestManagerValidateCoreLookupFailure(t *testing.T) {
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				pkg := &fakeIntegration{fakePackage: fakePackage{desc: description, root: rootPath}}
+				pkg.manifests = []hapkg.IntegrationManifest{{
+					Folder:       "app",
+					Domain:       "app",
+					Dependencies: []string{"http", "zeroconf"},
+				}}
+				return pkg
+			},
+		},
+	}
+	client := &rateLimitedClient{}
+	manager, err := NewWith(t.TempDir(), client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := []PackageDiff{{
+		PackageDescription: hapkg.PackageDescription{FullName: "foo/app", Version: "v1.0.0", Kind: "integrations"},
+		Operation:          "add",
+	}}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := manager.Validate(diff, map[string][]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || len(issues[0].Issues) != 2 || HasIssueErrors(issues) {
+		t.Fatalf("expected lookup warnings, got %+v", issues)
+	}
+	if message := issues[0].Issues[0].Message; !strings.Contains(message, "can't check dependency http") ||
+		!strings.Contains(message, "rate limit") {
+		t.Fatalf("unexpected lookup warning: %s", message)
+	}
+	if client.requests != 1 {
+		t.Fatalf("expected failed lookup to be cached, got %d requests", client.requests)
+	}
+}
+
+func TestManagerHomeAssistantCompatibility(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath, latest: "v2.0.0"}
+			},
+		},
+	}
+	client := fakeClient{
+		versions: map[string][]string{"foo/bar": {"v0.9.0", "v1.0.0", "v2.0.0"}},
+		files: map[string]string{
+			"foo/bar@v1.0.0/hacs.json": `{"homeassistant": "2023.1.0"}`,
+			"foo/bar@v2.0.0/hacs.json": `{"homeassistant": "2024.6.0"}`,
+		},
+	}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetHomeAssistantVersion("2024.1")
+
+	_, err = manager.Diff([]hapkg.PackageDescription{{FullName: "foo/bar", Version: "v2.0.0", Kind: "integrations"}}, true)
+	var compatibilityErr *CompatibilityError
+	if !errors.As(err, &compatibilityErr) || len(compatibilityErr.Packages) != 1 || compatibilityErr.Packages[0].Requires != "2024.6.0" {
+		t.Fatalf("expected compatibility error, got %v", err)
+	}
+
+	diff, err := manager.Diff([]hapkg.PackageDescription{{FullName: "foo/bar", Version: "v0.9.0", Kind: "integrations"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := manager.Updates(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Version != "v1.0.0" {
+		t.Fatalf("unexpected updates: %+v", updates)
+	}
+	diff, err = manager.Diff([]hapkg.PackageDescription{{FullName: "foo/bar", Version: "latest", Kind: "integrations"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff[0].Version != "v1.0.0" {
+		t.Fatalf("unexpected latest diff: %+v", diff)
+	}
+
+	manager.SetHomeAssistantVersion("2022.1")
+	diff, err = manager.Diff([]hapkg.PackageDescription{{FullName: "foo/bar", Version: "latest", Kind: "integrations"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Fatalf("expected installed version without requirements to stay, got %+v", diff)
+	}
+}
+
+// offlineClient serves only cached tags and artifacts.
+type offlineClient struct {
+	fakeClient
+	artifacts map[hapkg.ArtifactKey]bool
+	// requirements are keyed by full name and version, like foo/bar@v1.0.0.
+	requirements map[string]string
+}
+
+func (c offlineClient) GetTreeFile(fullName string, branch string, _ string) ([]byte, error) {
+	return nil, fmt.Errorf("%s@%s: %w", fullName, branch, hapkg.ErrOffline)
+}
+
+func (c offlineClient) GetVersions(fullName string) ([]string, error) {
+	if versions, ok := c.versions[fullName]; ok {
+		return versions, nil
+	}
+	return nil, fmt.Errorf("%s: %w", fullName, hapkg.ErrOffline)
+}
+
+func (c offlineClient) RestoreArtifact(hapkg.ArtifactKey, string) (bool, error) { return false, nil }
+func (c offlineClient) StoreArtifact(hapkg.ArtifactKey, string, string) error   { return nil }
+func (c offlineClient) HasArtifact(key hapkg.ArtifactKey) bool                  { return c.artifacts[key] }
+func (c offlineClient) StoreRequirement(string, string, string) error           { return nil }
+
+func (c offlineClient) Requirement(fullName string, version string) (string, bool) {
+	required, ok := c.requirements[fullName+"@"+version]
+	return required, ok
+}
+
+func TestManagerOffline(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, client hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath, client: client}
+			},
+		},
+	}
+	client := offlineClient{
+		fakeClient: fakeClient{versions: map[string][]string{"foo/cached": {"v1.0.0"}}},
+		artifacts: map[hapkg.ArtifactKey]bool{
+			{FullName: "foo/cached", Version: "v1.0.0", Kind: "integrations"}: true,
+		},
+		requirements: map[string]string{"foo/cached@v1.0.0": "2023.1.0"},
+	}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetOffline(true)
+	manager.SetHomeAssistantVersion("2024.1.0")
+
+	_, err = manager.Diff([]hapkg.PackageDescription{
+		{FullName: "foo/cached", Version: "latest", Kind: "integrations"},
+		{FullName: "foo/remote", Version: "latest", Kind: "integrations"},
+		{FullName: "foo/pinned", Version: "v1.0.0", Kind: "integrations"},
+	}, true)
+	var offlineErr *OfflineError
+	if !errors.As(err, &offlineErr) || strings.Join(offlineErr.Packages, ",") != "foo/remote,foo/pinned" {
+		t.Fatalf("expected offline error, got %v", err)
+	}
+
+	diff, err := manager.Diff([]hapkg.PackageDescription{{FullName: "foo/cached", Version: "latest", Kind: "integrations"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff[0].Version != "v1.0.0" {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	if err := manager.Apply(append(diff, PackageDiff{
+		PackageDescription: hapkg.PackageDescription{FullName: "foo/remote", Version: "v1.0.0", Kind: "integrations"},
+		Operation:          "add",
+	})); err != nil {
+		t.Fatal(err)
+	}
+	_, err = manager.Updates(true)
+	if !errors.As(err, &offlineErr) || strings.Join(offlineErr.Packages, ",") != "foo/remote" {
+		t.Fatalf("expected offline updates error, got %v", err)
+	}
+}
+
+func TestRequirementConflicts(t *testing.T) {
+	requirement := func(spec string, pkg string) Requirement {
+		return Requirement{Name: requirementName(spec), Spec: spec, Package: pkg, Domain: "domain"}
+	}
+	cases := []struct {
+		specs    []string
+		conflict bool
+	}{
+		{[]string{"requests==2.31.0", "Requests==2.31.0"}, false},
+		{[]string{"requests==2.31", "requests==2.31.0"}, false},
+		{[]string{"requests==2.31.0", "requests==2.30.0"}, true},
+		{[]string{"requests==2.31.0", "requests>=2.0,<3"}, false},
+		{[]string{"requests==2.31.0", "requests<2.31"}, true},
+		{[]string{"requests==2.31.0", "requests~=2.30"}, false},
+		{[]string{"requests==2.31.0", "requests~=2.30.0"}, true},
+		{[]string{"requests>=2.0", "requests<1.0"}, false},
+		{[]string{"py_foo==1.0", "py-foo==2.0"}, true},
+		{[]string{"git+https://example.com/foo.git", "foo==1.0"}, false},
+	}
+	for i, tc := range cases {
+		requirements := make([]Requirement, 0, len(tc.specs))
+		for j, spec := range tc.specs {
+			requirements = append(requirements, requirement(spec, fmt.Sprintf("foo/pkg%d", j)))
+		}
+		conflicts := findRequirementConflicts(requirements)
+		if (len(conflicts) > 0) != tc.conflict {
+			t.Fatalf("unexpected conflicts for case %d: %+v", i, conflicts)
+		}
+	}
+}
+
+func TestFormatRequirements(t *testing.T) {
+	requirements := []Requirement{
+		{Name: "requests", Spec: "requests==2.31.0", Package: "foo/b", Domain: "b"},
+		{Name: "aiohttp", Spec: "aiohttp>=3.8", Package: "foo/a", Domain: "a"},
+		{Name: "requests", Spec: "Requests == 2.31.0", Package: "foo/a", Domain: "a"},
+		{Name: "requests", Spec: "requests==2.31.0; python_version < '3.13'", Package: "foo/c", Domain: "c"},
+	}
+	expected := "# Generated by hapm from installed integrations\n" +
+		"# foo/a (a)\n" +
+		"aiohttp>=3.8\n" +
+		"# foo/b (b), foo/a (a), foo/c (c)\n" +
+		"requests==2.31.0\n"
+	if content := FormatRequirements(requirements); content != expected {
+		t.Fatalf("unexpected requirements:\n%s", content)
+	}
+}
+
+func TestManagerApplyLimitsConcurrencyTo20(t *testing.T) {
+	tmp := t.TempDir()
+	started := make(chan struct{}, 64)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	active := 0
+	maxActive := 0
+
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{
+					desc: description,
+					root: rootPath,
+					setupFn: func(_ *fakePackage) error {
+						mu.Lock()
+						active++
+						if active > maxActive {
+							maxActive = active
+						}
+						mu.Unlock()
+
+						started <- struct{}{}
+						<-release
+
+						mu.Lock()
+						active--
+						mu.Unlock()
+						return nil
+					},
+				}
+			},
+		},
+	}
+
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := make([]PackageDiff, 0, 30)
+	for i := 0; i < 30; i++ {
+		diffs = append(diffs, PackageDiff{
+			PackageDescription: hapkg.PackageDescription{
+				FullName: fmt.Sprintf("foo/pkg-%02d", i),
+				Kind:     "integrations",
+				Version:  "v1.0.0",
+			},
+			Operation: "add",
+		})
+	}
+
+	applyErr := make(chan error, 1)
+	go func() {
+		applyErr <- manager.Apply(diffs)
+	}()
+
+	for i := 0; i < maxApplyConcurrency; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for started task #%d", i+1)
+		}
+	}
+
+	select {
+	case <-started:
+		t.Fatal("expected at most 20 concurrent tasks before release")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case err := <-applyErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for apply completion")
+	}
+
+	mu.Lock()
+	gotMax := maxActive
+	gotActive := active
+	mu.Unlock()
+	if gotMax != maxApplyConcurrency {
+		t.Fatalf("unexpected max concurrency: got %d, want %d", gotMax, maxApplyConcurrency)
+	}
+	if gotActive != 0 {
+		t.Fatalf("unexpected active tasks after apply: %d", gotActive)
+	}
+	if len(manager.Descriptions()) != 30 {
+		t.Fatalf("unexpected package count: %d", len(manager.Descriptions()))
+	}
+}
+
+func TestManagerApplyCancelOnFirstError(t *testing.T) {
+	tmp := t.TempDir()
+	sentinelErr := errors.New("boom")
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	var started atomic.Int32
+
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakePackage{
+					desc: description,
+					root: rootPath,
+					setupFn: func(p *fakePackage) error {
+						started.Add(1)
+						if p.desc.FullName == "foo/pkg-00" {
+							releaseOnce.Do(func() {
+								close(release)
+							})
+							return sentinelErr
+						}
+						<-release
+						return nil
+					},
+				}
+			},
+		},
+	}
+
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := filepath.Join(tmp, "_lock.json")
+	originalLock := []byte(`[{"full_name":"seed/pkg","kind":"integrations","version":"v1.0.0"}]`)
+	if err := os.WriteFile(lockPath, originalLock, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := make([]PackageDiff, 0, 30)
+	for i := 0; i < 30; i++ {
+		diffs = append(diffs, PackageDiff{
+			PackageDescription: hapkg.PackageDescription{
+				FullName: fmt.Sprintf("foo/pkg-%02d", i),
+				Kind:     "integrations",
+				Version:  "v1.0.0",
+			},
+			Operation: "add",
+		})
+	}
+
+	err = manager.Apply(diffs)
+	if !errors.Is(err, sentinelErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := int(started.Load()); got >= len(diffs) {
+		t.Fatalf("expected cancellation before all jobs started, got %d started of %d", got, len(diffs))
+	}
+
+	after, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, originalLock) {
+		t.Fatalf("lockfile was unexpectedly changed: %s", string(after))
+	}
+}
+
+// slowClient tracks how many version lookups run at the same time.
+type slowClient struct {
+	fakeClient
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (c *slowClient) GetVersions(fullName string) ([]string, error) {
+	c.mu.Lock()
+	c.active++
+	c.maxActive = max(c.maxActive, c.active)
+	c.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+	return c.fakeClient.GetVersions(fullName)
+}
+
+func TestManagerResolveInParallel(t *testing.T) {
+	tmp := t.TempDir()
+	versions := map[string][]string{}
+	update := make([]hapkg.PackageDescription, 0, 12)
+	for i := 0; i < 12; i++ {
+		fullName := fmt.Sprintf("foo/pkg-%02d", i)
+		if i != 4 && i != 9 {
+			versions[fullName] = []string{"v1.0.0", fmt.Sprintf("v1.%d.0", i)}
+		}
+		update = append(update, hapkg.PackageDescription{FullName: fullName, Version: "latest", Kind: "integrations"})
+	}
+	client := &slowClient{fakeClient: fakeClient{versions: versions}}
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, client hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath, client: client}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetConcurrency(3)
+
+	_, err = manager.Diff(update, true)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("expected resolve error, got %v", err)
+	}
+	if len(resolveErr.Packages) != 2 ||
+		resolveErr.Packages[0].FullName != "foo/pkg-04" ||
+		resolveErr.Packages[1].FullName != "foo/pkg-09" {
+		t.Fatalf("unexpected failed packages: %+v", resolveErr.Packages)
+	}
+	if client.maxActive != 3 {
+		t.Fatalf("unexpected max concurrency: got %d, want 3", client.maxActive)
+	}
+
+	update = append(update[:9:9], update[10:]...)
+	update = append(update[:4:4], update[5:]...)
+	diff, err := manager.Diff(update, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != len(update) {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+	for i, item := range diff {
+		if item.FullName != update[i].FullName || item.Operation != "add" {
+			t.Fatalf("diff order differs from manifest at %d: %+v", i, diff)
+		}
+	}
+	if err := manager.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(client.versions, "foo/pkg-01")
+	updates, err := manager.Updates(true)
+	if !errors.As(err, &resolveErr) || len(resolveErr.Packages) != 1 || resolveErr.Packages[0].FullName != "foo/pkg-01" {
+		t.Fatalf("expected resolve error of foo/pkg-01, got %v", err)
+	}
+	if updates != nil {
+		t.Fatalf("unexpected updates: %+v", updates)
+	}
+}
+
+func TestManagerApplyKeepGoing(t *testing.T) {
+	tmp := t.TempDir()
+	broken := map[string]bool{"foo/pkg-01": true, "foo/pkg-03": true}
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				setup := func(p *fakePackage) error {
+					if broken[p.desc.FullName] {
+						return errors.New("upstream is broken")
+					}
+					return os.WriteFile(p.filePath(p.desc.Version), []byte(p.desc.Version), 0o644)
+				}
+				return &fakePackage{
+					desc:    description,
+					root:    rootPath,
+					setupFn: setup,
+					switchFn: func(p *fakePackage, version string) error {
+						if broken[p.desc.FullName] {
+							return errors.New("upstream is broken")
+						}
+						p.desc.Version = version
+						return nil
+					},
+				}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetKeepGoing(true)
+
+	diffs := make([]PackageDiff, 0, 5)
+	for i := 0; i < 5; i++ {
+		diffs = append(diffs, PackageDiff{
+			PackageDescription: hapkg.PackageDescription{
+				FullName: fmt.Sprintf("foo/pkg-%02d", i),
+				Kind:     "integrations",
+				Version:  "v1.0.0",
+			},
+			Operation: "add",
+		})
+	}
+	err = manager.Apply(diffs)
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected apply error, got %v", err)
+	}
+	if len(applyErr.Packages) != 2 || applyErr.Packages[0].FullName != "foo/pkg-01" || applyErr.Packages[1].FullName != "foo/pkg-03" {
+		t.Fatalf("unexpected failed packages: %+v", applyErr.Packages)
+	}
+	if !applyErr.Failed("foo/pkg-03") || applyErr.Failed("foo/pkg-00") {
+		t.Fatal("unexpected Failed result")
+	}
+	locked, err := NewLockfile(filepath.Join(tmp, "_lock.json")).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 3 || locked[0].FullName != "foo/pkg-00" || locked[2].FullName != "foo/pkg-04" {
+		t.Fatalf("unexpected lockfile: %+v", locked)
+	}
+
+	broken = map[string]bool{"foo/pkg-00": true}
+	err = manager.Apply([]PackageDiff{
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/pkg-00", Kind: "integrations", Version: "v2.0.0"}, Operation: "switch", CurrentVersion: "v1.0.0"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/pkg-02", Kind: "integrations", Version: "v2.0.0"}, Operation: "switch", CurrentVersion: "v1.0.0"},
+	})
+	if !errors.As(err, &applyErr) || len(applyErr.Packages) != 1 {
+		t.Fatalf("expected failure of foo/pkg-00, got %v", err)
+	}
+	versions := map[string]string{}
+	for _, description := range manager.Descriptions() {
+		versions[description.FullName] = description.Version
+	}
+	if versions["foo/pkg-00"] != "v1.0.0" || versions["foo/pkg-02"] != "v2.0.0" {
+		t.Fatalf("unexpected versions: %v", versions)
+	}
+}
+
+func TestManagerDiffKeepGoing(t *testing.T) {
+	tmp := t.TempDir()
+	client := fakeClient{versions: map[string][]string{"foo/ok": {"v1.0.0"}}}
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, client hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath, client: client}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.Apply([]PackageDiff{{
+		PackageDescription: hapkg.PackageDescription{FullName: "foo/broken", Kind: "integrations", Version: "v1.0.0"},
+		Operation:          "add",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := []hapkg.PackageDescription{
+		{FullName: "foo/broken", Kind: "integrations", Version: "latest"},
+		{FullName: "foo/ok", Kind: "integrations", Version: "latest"},
+	}
+	if diff, err := manager.Diff(update, true); err == nil || diff != nil {
+		t.Fatalf("expected resolve error without diff, got %+v, %v", diff, err)
+	}
+
+	manager.SetKeepGoing(true)
+	diff, err := manager.Diff(update, true)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || len(resolveErr.Packages) != 1 || resolveErr.Packages[0].FullName != "foo/broken" {
+		t.Fatalf("expected resolve error of foo/broken, got %v", err)
+	}
+	if len(diff) != 1 || diff[0].FullName != "foo/ok" || diff[0].Operation != "add" {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+}
+
+// fakeStoredPackage is a package with a single stored artifact.
+type fakeStoredPackage struct {
+	fakePackage
+}
+
+func (p *fakeStoredPackage) ArtifactPath() string {
+	return p.filePath(p.desc.Version)
+}
+
+func (p *fakeStoredPackage) CheckArtifact() error {
+	content, err := os.ReadFile(p.ArtifactPath())
+	if err != nil {
+		return err
+	}
+	if string(content) != p.desc.Version {
+		return errors.New("unexpected content")
+	}
+	return nil
+}
+
+func TestManagerDoctor(t *testing.T) {
+	tmp := t.TempDir()
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+				return &fakeStoredPackage{fakePackage{desc: description, root: rootPath}}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs := make([]PackageDiff, 0, 3)
+	for _, name := range []string{"foo/intact", "foo/missing", "foo/corrupted"} {
+		diffs = append(diffs, PackageDiff{
+			PackageDescription: hapkg.PackageDescription{FullName: name, Kind: "integrations", Version: "v1.0.0"},
+			Operation:          "add",
+		})
+	}
+	if err := manager.Apply(diffs); err != nil {
+		t.Fatal(err)
+	}
+	locked, err := NewLockfile(filepath.Join(tmp, "_lock.json")).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range locked {
+		if !strings.HasPrefix(entry.Digest, hapkg.DigestPrefix) {
+			t.Fatalf("digest of %s is not recorded: %+v", entry.FullName, entry)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(tmp, "foo-missing@v1.0.0.pkg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "foo-corrupted@v1.0.0.pkg"), []byte("v1.0.1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orphans := map[string]bool{
+		"foo-old@v0.1.0.pkg":   true,
+		"foo-bar@v1.0.0.1.tmp": true,
+		"notes.txt":            false,
+	}
+	for name := range orphans {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte("orphan"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Lockfiles written by older versions have no digests.
+	delete(manager.digests, "foo/intact")
+
+	problems, err := manager.Doctor(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[string]string{}
+	for _, problem := range problems {
+		key := problem.Package
+		if key == "" {
+			key = filepath.Base(problem.Path)
+		}
+		messages[key] = problem.Message
+		if problem.Fixed {
+			t.Fatalf("problem is fixed without --fix: %+v", problem)
+		}
+		if problem.Notice != (key == "foo/intact") {
+			t.Fatalf("unexpected notice flag: %+v", problem)
+		}
+	}
+	expected := map[string]string{
+		"foo/intact":           "checksum is not recorded",
+		"foo/missing":          "artifact is missing",
+		"foo/corrupted":        "checksum mismatch",
+		"foo-old@v0.1.0.pkg":   "artifact of a package that is not locked",
+		"foo-bar@v1.0.0.1.tmp": "unfinished download",
+		"notes.txt":            "unknown file",
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+	for key, message := range expected {
+		if messages[key] != message {
+			t.Fatalf("unexpected problem of %s: %q", key, messages[key])
+		}
+	}
+
+	problems, err = manager.Doctor(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if problem.Fixed != problem.Fixable {
+			t.Fatalf("unexpected fix result: %+v", problem)
+		}
+	}
+	for name, removed := range orphans {
+		_, err := os.Stat(filepath.Join(tmp, name))
+		if removed != errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("unexpected state of %s: %v", name, err)
+		}
+	}
+	problems, err = manager.Doctor(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Message != "unknown file" {
+		t.Fatalf("storage is not repaired: %+v", problems)
+	}
+}
+
+func TestManagerDiffReinstall(t *testing.T) {
+	tmp := t.TempDir()
+	failSetup := false
+	constructor := func(description hapkg.PackageDescription, rootPath string, _ hapkg.GitClient) hapkg.Package {
+		pkg := &fakeStoredPackage{fakePackage{desc: description, root: rootPath}}
+		if failSetup {
+			pkg.setupFn = func(*fakePackage) error {
+				return errors.New("download failed")
+			}
+		}
+		return pkg
+	}
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": constructor,
+			"plugins":      constructor,
+		},
+	}
+	manager, err := NewWith(tmp, fakeClient{}, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := []hapkg.PackageDescription{
+		{FullName: "foo/card", Kind: "plugins", Version: "v1.0.0"},
+		{FullName: "foo/lost", Kind: "integrations", Version: "v1.0.0"},
+	}
+	diffs, err := manager.Diff(manifest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diffs); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = manager.Diff(manifest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("unexpected diff of synchronized storage: %+v", diffs)
+	}
+
+	if err := os.Remove(filepath.Join(tmp, "foo-lost@v1.0.0.pkg")); err != nil {
+		t.Fatal(err)
+	}
+	manifest[0].Kind = "integrations"
+	diffs, err = manager.Diff(manifest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("unexpected diff: %+v", diffs)
+	}
+	if diffs[0].Operation != "reinstall" || diffs[0].CurrentKind != "plugins" || diffs[0].CurrentVersion != "" {
+		t.Fatalf("unexpected kind change diff: %+v", diffs[0])
+	}
+	if diffs[1].Operation != "reinstall" || diffs[1].CurrentKind != "" {
+		t.Fatalf("unexpected missing artifact diff: %+v", diffs[1])
+	}
+	if err := manager.Apply(diffs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "foo-lost@v1.0.0.pkg")); err != nil {
+		t.Fatalf("artifact is not restored: %v", err)
+	}
+	locked, err := NewLockfile(filepath.Join(tmp, "_lock.json")).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 2 || locked[0].Kind != "integrations" || locked[1].Digest == "" {
+		t.Fatalf("unexpected lockfile: %+v", locked)
+	}
+
+	failSetup = true
+	manifest[0].Kind = "plugins"
+	diffs, err = manager.Diff(manifest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diffs); err == nil {
+		t.Fatal("expected reinstall to fail")
+	}
+	if content, err := os.ReadFile(filepath.Join(tmp, "foo-card@v1.0.0.pkg")); err != nil || string(content) != "v1.0.0" {
+		t.Fatalf("previous artifact is not kept after failed reinstall: %q, %v", string(content), err)
+	}
+	leftovers, err := filepath.Glob(filepath.Join(tmp, "*.tmp"))
+	if err != nil || len(leftovers) != 0 {
+		t.Fatalf("unexpected leftovers: %v, %v", leftovers, err)
+	}
+}
+
+func TestManagerUpdatesWithin(t *testing.T) {
+	tmp := t.TempDir()
+	client := fakeClient{versions: map[string][]string{
+		"foo/bar":    {"v1.2.3", "v1.2.5", "v1.3.0", "v2.0.0", "v2.1.0-beta"},
+		"foo/branch": {"v1.0.0"},
+	}}
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, client hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath, client: client}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.Apply([]PackageDiff{
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/bar", Kind: "integrations", Version: "v1.2.3"}, Operation: "add"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/branch", Kind: "integrations", Version: "main"}, Operation: "add"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		level    string
+		expected string
+	}{
+		{hapkg.UpdatePatch, "v1.2.5"},
+		{hapkg.UpdateMinor, "v1.3.0"},
+		{hapkg.UpdateMajor, "v2.0.0"},
+	}
+	for _, tc := range cases {
+		updates, err := manager.UpdatesWithin(true, tc.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(updates) != 1 || updates[0].Version != tc.expected || updates[0].CurrentVersion != "v1.2.3" {
+			t.Fatalf("unexpected %s updates: %+v", tc.level, updates)
+		}
+	}
+}
+
+func TestManagerPolicies(t *testing.T) {
+	tmp := t.TempDir()
+	tags := []string{"v1.0.0", "v1.0.1", "v1.1.0", "v2.0.0", "v2.1.0-beta"}
+	client := fakeClient{versions: map[string][]string{
+		"foo/held":     tags,
+		"foo/minor":    tags,
+		"foo/ignored":  tags,
+		"foo/unstable": tags,
+	}}
+	registry := Registry{
+		Constructors: map[string]Constructor{
+			"integrations": func(description hapkg.PackageDescription, rootPath string, client hapkg.GitClient) hapkg.Package {
+				return &fakePackage{desc: description, root: rootPath, client: client}
+			},
+		},
+	}
+	manager, err := NewWith(tmp, client, registry, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetPolicies(map[string]hapkg.UpdatePolicy{
+		"foo/held":     {Hold: true},
+		"foo/minor":    {Allow: hapkg.UpdateMinor},
+		"foo/ignored":  {IgnoreVersions: []string{"v2.*"}},
+		"foo/unstable": {AllowUnstable: true},
+	})
+	manifest := make([]hapkg.PackageDescription, 0, 4)
+	for _, name := range []string{"foo/held", "foo/ignored", "foo/minor", "foo/unstable"} {
+		manifest = append(manifest, hapkg.PackageDescription{FullName: name, Kind: "integrations", Version: "v1.0.0"})
+	}
+	diffs, err := manager.Diff(manifest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Apply(diffs); err != nil {
+		t.Fatal(err)
+	}
+
+	updates, err := manager.Updates(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(updates))
+	for _, update := range updates {
+		got = append(got, update.FullName+"@"+update.Version+" "+update.Held)
+	}
+	expected := []string{
+		"foo/held@v2.0.0 held",
+		"foo/ignored@v1.1.0 ",
+		"foo/ignored@v2.0.0 ignored",
+		"foo/minor@v1.1.0 ",
+		"foo/minor@v2.0.0 allows minor updates",
+		"foo/unstable@v2.1.0-beta ",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected updates:\n%s", strings.Join(got, "\n"))
+	}
+
+	for i := range manifest {
+		manifest[i].Version = "latest"
+	}
+	diffs, err = manager.Diff(manifest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, diff := range diffs {
+		got = append(got, diff.FullName+"@"+diff.Version)
+	}
+	expected = []string{"foo/ignored@v1.1.0", "foo/minor@v1.1.0", "foo/unstable@v2.1.0-beta"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected latest versions: %s", strings.Join(got, " "))
+	}
+}
+
+type fakeReleases map[string][]hapkg.Release
+
+func (f fakeReleases) GetReleases(fullName string, _ string) ([]hapkg.Release, error) {
+	releases, ok := f[fullName]
+	if !ok {
+		return nil, errors.New("releases not found")
+	}
+	return releases, nil
+}
+
+func TestManagerChangelog(t *testing.T) {
+	manager, err := NewWith(t.TempDir(), fakeClient{}, Registry{}, "_lock.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := fakeReleases{"foo/bar": {{Tag: "v1.0.0"}, {Tag: "v1.1.0"}, {Tag: "v1.2.0"}, {Tag: "v2.0.0"}}}
+	updates := []PackageDiff{{
+		PackageDescription: hapkg.PackageDescription{FullName: "foo/bar", Version: "v1.2.0"},
+		CurrentVersion:     "v1.0.0",
+	}}
+	changelogs, err := manager.Changelog(provider, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changelogs) != 1 || len(changelogs[0].Releases) != 2 || changelogs[0].Releases[0].Tag != "v1.2.0" {
+		t.Fatalf("unexpected changelog: %+v", changelogs)
+	}
+
+	updates = append(updates, PackageDiff{PackageDescription: hapkg.PackageDescription{FullName: "foo/missing", Version: "v1.0.0"}})
+	_, err = manager.Changelog(provider, updates)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || len(resolveErr.Packages) != 1 || resolveErr.Packages[0].FullName != "foo/missing" {
+		t.Fatalf("expected failure of foo/missing, got %v", err)
 	}
 }
@@ -0,0 +1,37 @@
+package manager
+
+import (
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+// OfflineError is returned in offline mode when packages can't be resolved
+// from the lockfile, storage and cache.
+type OfflineError struct {
+	Packages []string
+}
+
+func (e *OfflineError) Error() string {
+	return "network access is required for: " + strings.Join(e.Packages, ", ")
+}
+
+// SetOffline makes the manager check that changed packages can be
+// installed from the cache before anything is applied.
+func (m *PackageManager) SetOffline(offline bool) {
+	m.offline = offline
+}
+
+// hasArtifact reports whether the package version can be installed
+// without network access.
+func (m *PackageManager) hasArtifact(description hapkg.PackageDescription) bool {
+	cache, ok := m.client.(hapkg.ArtifactCache)
+	if !ok {
+		return false
+	}
+	return cache.HasArtifact(hapkg.ArtifactKey{
+		FullName: description.FullName,
+		Version:  description.Version,
+		Kind:     description.Kind,
+	})
+}
@@ -0,0 +1,78 @@
+package manager
+
+import "github.com/mishamyrt/hapm/internal/hapkg"
+
+// SetPolicies sets update policies of manifest entries keyed by package
+// full name.
+func (m *PackageManager) SetPolicies(policies map[string]hapkg.UpdatePolicy) {
+	m.policies = policies
+}
+
+// packageUpdate is the newest version an installed package may be updated
+// to and the newest version its policy holds back.
+type packageUpdate struct {
+	version string
+	held    string
+	reason  string
+}
+
+func (m *PackageManager) packageUpdate(pkg hapkg.Package, stableOnly bool, level string) (packageUpdate, error) {
+	policy := m.policies[pkg.FullName()]
+	if policy.IsZero() {
+		latest, err := m.packageLatestVersion(pkg, stableOnly, level)
+		return packageUpdate{version: latest}, err
+	}
+	tags, err := m.client.GetVersions(pkg.FullName())
+	if err != nil {
+		return packageUpdate{}, err
+	}
+	update := packageUpdate{}
+	if !policy.Hold {
+		allowed := policy.Filter(pkg.Version(), tags, level)
+		if update.version, _, err = m.latestVersion(pkg.FullName(), allowed, policy.StableOnly(stableOnly)); err != nil {
+			return packageUpdate{}, err
+		}
+	}
+	available := hapkg.UpdatePolicy{}.Filter(pkg.Version(), tags, level)
+	if update.held, _, err = m.latestVersion(pkg.FullName(), available, stableOnly); err != nil {
+		return packageUpdate{}, err
+	}
+	update.reason = heldReason(policy, update.held)
+	return update, nil
+}
+
+// heldReason describes why the policy holds the version back.
+func heldReason(policy hapkg.UpdatePolicy, version string) string {
+	switch {
+	case policy.Hold:
+		return "held"
+	case policy.Ignores(version):
+		return "ignored"
+	case policy.Allow != "":
+		return "allows " + policy.Allow + " updates"
+	}
+	return "filtered"
+}
+
+// resolveLatest returns the newest version of the manifest entry allowed by
+// its policy. A held package keeps the installed version, as well as
+// a package that has no allowed versions.
+func (m *PackageManager) resolveLatest(fullName string, stableOnly bool) (string, *Incompatibility, error) {
+	policy := m.policies[fullName]
+	installed := ""
+	if existing, ok := m.packages[fullName]; ok {
+		installed = existing.Version()
+	}
+	if policy.Hold && installed != "" {
+		return installed, nil, nil
+	}
+	tags, err := m.client.GetVersions(fullName)
+	if err != nil {
+		return "", nil, err
+	}
+	tags = policy.Filter(installed, tags, hapkg.UpdateMajor)
+	if len(tags) == 0 && installed != "" {
+		return installed, nil, nil
+	}
+	return m.latestVersion(fullName, tags, policy.StableOnly(stableOnly))
+}
@@ -27,14 +27,24 @@ func DefaultRegistry() Registry {
 			hapkg.PluginKind: func(d hapkg.PackageDescription, r string, c hapkg.GitClient) hapkg.Package {
 				return hapkg.NewPluginPackage(d, r, c)
 			},
+			hapkg.PythonScriptKind: func(d hapkg.PackageDescription, r string, c hapkg.GitClient) hapkg.Package {
+				return hapkg.NewPythonScriptPackage(d, r, c)
+			},
+			hapkg.AppDaemonKind: func(d hapkg.PackageDescription, r string, c hapkg.GitClient) hapkg.Package {
+				return hapkg.NewAppDaemonPackage(d, r, c)
+			},
 		},
 		PreExport: map[string]func(path string) error{
-			hapkg.IntegrationKind: hapkg.IntegrationPreExport,
-			hapkg.PluginKind:      hapkg.PluginPreExport,
+			hapkg.IntegrationKind:  hapkg.IntegrationPreExport,
+			hapkg.PluginKind:       hapkg.PluginPreExport,
+			hapkg.PythonScriptKind: hapkg.PythonScriptPreExport,
+			hapkg.AppDaemonKind:    hapkg.AppDaemonPreExport,
 		},
 		PostExport: map[string]func(path string) ([]string, error){
-			hapkg.IntegrationKind: hapkg.IntegrationPostExport,
-			hapkg.PluginKind:      hapkg.PluginPostExport,
+			hapkg.IntegrationKind:  hapkg.IntegrationPostExport,
+			hapkg.PluginKind:       hapkg.PluginPostExport,
+			hapkg.PythonScriptKind: hapkg.PythonScriptPostExport,
+			hapkg.AppDaemonKind:    hapkg.AppDaemonPostExport,
 		},
 	}
 }
@@ -0,0 +1,258 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+var (
+	requirementNameRe      = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*(.*)$`)
+	requirementSeparatorRe = regexp.MustCompile(`[-_.]+`)
+	requirementClauseRe    = regexp.MustCompile(`^(===|==|!=|~=|>=|<=|>|<)\s*(.+)$`)
+)
+
+// Requirement is a pip requirement declared by an integration.
+type Requirement struct {
+	// Name is the normalized distribution name. It is empty for
+	// requirements that are not plain distribution specifiers, like VCS URLs.
+	Name    string
+	Spec    string
+	Package string
+	Domain  string
+}
+
+// RequirementConflict lists requirements of the same distribution that
+// cannot be satisfied together.
+type RequirementConflict struct {
+	Name         string
+	Requirements []Requirement
+}
+
+// Requirements collects pip requirements of installed integrations
+// and checks them for conflicting pins.
+func (m *PackageManager) Requirements() ([]Requirement, []RequirementConflict, error) {
+	requirements := make([]Requirement, 0)
+	for _, pkg := range m.sortedPackages() {
+		provider, ok := pkg.(hapkg.ManifestProvider)
+		if !ok {
+			continue
+		}
+		manifests, err := provider.Manifests()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading manifests of %s: %w", pkg.FullName(), err)
+		}
+		for _, manifest := range manifests {
+			domain := manifest.Domain
+			if domain == "" {
+				domain = manifest.Folder
+			}
+			for _, spec := range manifest.Requirements {
+				spec = strings.TrimSpace(spec)
+				if spec == "" {
+					continue
+				}
+				requirements = append(requirements, Requirement{
+					Name:    requirementName(spec),
+					Spec:    spec,
+					Package: pkg.FullName(),
+					Domain:  domain,
+				})
+			}
+		}
+	}
+	return requirements, findRequirementConflicts(requirements), nil
+}
+
+// FormatRequirements renders requirements as requirements.txt content.
+// Equal specifiers are merged and annotated with their sources.
+func FormatRequirements(requirements []Requirement) string {
+	type line struct {
+		key     string
+		spec    string
+		sources []string
+	}
+	lines := map[string]*line{}
+	for _, requirement := range requirements {
+		spec := normalizeSpec(requirement.Spec)
+		current, ok := lines[spec]
+		if !ok {
+			key := requirement.Name
+			if key == "" {
+				key = spec
+			}
+			current = &line{key: key, spec: requirement.Spec}
+			lines[spec] = current
+		}
+		source := requirement.Package + " (" + requirement.Domain + ")"
+		if !slices.Contains(current.sources, source) {
+			current.sources = append(current.sources, source)
+		}
+	}
+	sorted := make([]*line, 0, len(lines))
+	for _, item := range lines {
+		sorted = append(sorted, item)
+	}
+	sort.Slice(sorted, func(i int, j int) bool {
+		if sorted[i].key != sorted[j].key {
+			return sorted[i].key < sorted[j].key
+		}
+		return sorted[i].spec < sorted[j].spec
+	})
+	var builder strings.Builder
+	builder.WriteString("# Generated by hapm from installed integrations\n")
+	for _, item := range sorted {
+		builder.WriteString("# " + strings.Join(item.sources, ", ") + "\n")
+		builder.WriteString(item.spec + "\n")
+	}
+	return builder.String()
+}
+
+// requirementName returns normalized distribution name of the requirement.
+func requirementName(spec string) string {
+	match := requirementNameRe.FindStringSubmatch(spec)
+	if match == nil || strings.Contains(spec, "://") {
+		return ""
+	}
+	return strings.ToLower(requirementSeparatorRe.ReplaceAllString(match[1], "-"))
+}
+
+// normalizeSpec drops whitespace and environment markers and lowercases
+// the name, so equal requirements are written once.
+func normalizeSpec(spec string) string {
+	spec = strings.ReplaceAll(spec, " ", "")
+	name := requirementName(spec)
+	if name == "" {
+		return spec
+	}
+	match := requirementNameRe.FindStringSubmatch(spec)
+	clauses, _, _ := strings.Cut(match[3], ";")
+	return name + match[2] + clauses
+}
+
+// requirementClauses returns version clauses of the requirement,
+// like ["==1.0.0"] or [">=1.0", "<2"].
+func requirementClauses(spec string) []string {
+	match := requirementNameRe.FindStringSubmatch(spec)
+	if match == nil {
+		return nil
+	}
+	constraint := match[3]
+	if idx := strings.Index(constraint, ";"); idx >= 0 {
+		constraint = constraint[:idx]
+	}
+	clauses := make([]string, 0)
+	for _, clause := range strings.Split(constraint, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses
+}
+
+func findRequirementConflicts(requirements []Requirement) []RequirementConflict {
+	byName := map[string][]Requirement{}
+	names := make([]string, 0)
+	for _, requirement := range requirements {
+		if requirement.Name == "" {
+			continue
+		}
+		if _, ok := byName[requirement.Name]; !ok {
+			names = append(names, requirement.Name)
+		}
+		byName[requirement.Name] = append(byName[requirement.Name], requirement)
+	}
+	sort.Strings(names)
+
+	conflicts := make([]RequirementConflict, 0)
+	for _, name := range names {
+		if !requirementsCompatible(byName[name]) {
+			conflicts = append(conflicts, RequirementConflict{Name: name, Requirements: byName[name]})
+		}
+	}
+	return conflicts
+}
+
+// requirementsCompatible checks that exact pins of the distribution agree
+// with each other and with version ranges of other requirements.
+// Requirements without exact pins are left to pip.
+func requirementsCompatible(requirements []Requirement) bool {
+	pins := map[string]bool{}
+	for _, requirement := range requirements {
+		for _, clause := range requirementClauses(requirement.Spec) {
+			match := requirementClauseRe.FindStringSubmatch(clause)
+			if match != nil && (match[1] == "==" || match[1] == "===") && !strings.Contains(match[2], "*") {
+				pins[strings.TrimSpace(match[2])] = true
+			}
+		}
+	}
+	for pin := range pins {
+		version, err := hapkg.NewVersion(pin)
+		if err != nil {
+			// Pins that are not comparable must match literally.
+			return len(pins) == 1
+		}
+		for _, requirement := range requirements {
+			for _, clause := range requirementClauses(requirement.Spec) {
+				if !clauseAllows(clause, version) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// clauseAllows reports whether version satisfies a single version clause.
+// Clauses that cannot be parsed are considered satisfied.
+func clauseAllows(clause string, version hapkg.Version) bool {
+	match := requirementClauseRe.FindStringSubmatch(clause)
+	if match == nil {
+		return true
+	}
+	operand := strings.TrimSpace(match[2])
+	if strings.HasSuffix(operand, ".*") {
+		prefix := strings.TrimSuffix(operand, ".*")
+		matches := version.Original == prefix || strings.HasPrefix(version.Original, prefix+".")
+		if match[1] == "!=" {
+			return !matches
+		}
+		return matches
+	}
+	expected, err := hapkg.NewVersion(operand)
+	if err != nil {
+		return true
+	}
+	cmp := version.CompareRelease(expected)
+	switch match[1] {
+	case "==", "===":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "~=":
+		if cmp < 0 || len(expected.Value) < 2 {
+			return cmp >= 0
+		}
+		prefix := expected.Value[:len(expected.Value)-1]
+		for i := range prefix {
+			if i >= len(version.Value) || version.Value[i] != prefix[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
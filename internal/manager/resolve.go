@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"strings"
+	"sync"
+)
+
+// PackageError is a failure of a single package.
+type PackageError struct {
+	FullName string
+	Err      error
+}
+
+// ResolveError is returned when versions of some packages can't be
+// resolved. Other packages are resolved anyway.
+type ResolveError struct {
+	Packages []PackageError
+}
+
+func (e *ResolveError) Error() string {
+	messages := make([]string, 0, len(e.Packages))
+	for _, pkg := range e.Packages {
+		messages = append(messages, pkg.FullName+": "+pkg.Err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ResolveError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Packages))
+	for _, pkg := range e.Packages {
+		errs = append(errs, pkg.Err)
+	}
+	return errs
+}
+
+// SetConcurrency limits number of packages that are resolved or applied
+// at the same time. Non-positive values restore the default.
+func (m *PackageManager) SetConcurrency(concurrency int) {
+	if concurrency <= 0 {
+		concurrency = maxApplyConcurrency
+	}
+	m.concurrency = concurrency
+}
+
+// workers returns number of workers for the jobs.
+func (m *PackageManager) workers(jobs int) int {
+	return min(jobs, m.concurrency)
+}
+
+// forEach calls fn for every index below count using a bounded pool of
+// workers. Callers keep results by index, so their order doesn't depend
+// on scheduling.
+func (m *PackageManager) forEach(count int, fn func(index int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < m.workers(count); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				fn(index)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+const (
+	coreRepository = "home-assistant/core"
+	coreDevBranch  = "dev"
+	coreComponents = "homeassistant/components"
+	coreConstants  = "homeassistant/const.py"
+)
+
+// PackageIssues lists manifest problems found in a single package.
+type PackageIssues struct {
+	Package string
+	Issues  []hapkg.ManifestIssue
+}
+
+// HasIssueErrors reports whether any of the issues is an error.
+func HasIssueErrors(packages []PackageIssues) bool {
+	for _, pkg := range packages {
+		for _, issue := range pkg.Issues {
+			if issue.Severity == hapkg.IssueError {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks manifests of packages changed by diffs and dependencies
+// of every installed integration. Previous maps package full names to
+// domains they provided before diffs were applied, so dependencies on
+// domains that are no longer installed can be reported.
+func (m *PackageManager) Validate(diffs []PackageDiff, previous map[string][]string) ([]PackageIssues, error) {
+	changed := map[string]bool{}
+	for _, diff := range diffs {
+		if diff.Operation != "delete" {
+			changed[diff.FullName] = true
+		}
+	}
+	current, err := m.Domains()
+	if err != nil {
+		return nil, err
+	}
+	provided := map[string]bool{}
+	for _, domains := range current {
+		for _, domain := range domains {
+			provided[domain] = true
+		}
+	}
+	removed := map[string]string{}
+	for fullName, domains := range previous {
+		for _, domain := range domains {
+			if !provided[domain] {
+				removed[domain] = fullName
+			}
+		}
+	}
+
+	result := make([]PackageIssues, 0)
+	for _, pkg := range m.sortedPackages() {
+		issues := make([]hapkg.ManifestIssue, 0)
+		if validator, ok := pkg.(hapkg.Validator); ok && changed[pkg.FullName()] {
+			items, err := validator.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("validating %s: %w", pkg.FullName(), err)
+			}
+			issues = append(issues, items...)
+		}
+		if provider, ok := pkg.(hapkg.ManifestProvider); ok && (len(removed) > 0 || changed[pkg.FullName()]) {
+			manifests, err := provider.Manifests()
+			if err != nil {
+				return nil, fmt.Errorf("reading manifests of %s: %w", pkg.FullName(), err)
+			}
+			issues = append(issues, dependencyIssues(manifests, removed)...)
+			if changed[pkg.FullName()] {
+				issues = append(issues, m.unknownDependencies(manifests, provided, removed)...)
+			}
+		}
+		if len(issues) > 0 {
+			result = append(result, PackageIssues{Package: pkg.FullName(), Issues: issues})
+		}
+	}
+	return result, nil
+}
+
+// dependencyIssues reports dependencies on custom domains that were
+// provided by packages which no longer export them.
+func dependencyIssues(manifests []hapkg.IntegrationManifest, removed map[string]string) []hapkg.ManifestIssue {
+	issues := make([]hapkg.ManifestIssue, 0)
+	for _, manifest := range manifests {
+		for _, dependency := range manifest.Dependencies {
+			if owner, ok := removed[dependency]; ok {
+				issues = append(issues, hapkg.ManifestIssue{
+					Domain:   manifest.Folder,
+					Severity: hapkg.IssueError,
+					Message:  fmt.Sprintf("depends on %s, which is no longer provided by %s", dependency, owner),
+				})
+			}
+		}
+		for _, dependency := range manifest.AfterDependencies {
+			if owner, ok := removed[dependency]; ok {
+				issues = append(issues, hapkg.ManifestIssue{
+					Domain:   manifest.Folder,
+					Severity: hapkg.IssueWarning,
+					Message:  fmt.Sprintf("loads after %s, which is no longer provided by %s", dependency, owner),
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// unknownDependencies reports dependencies on domains that no installed
+// package provides and Home Assistant doesn't ship. Dependencies that
+// can't be looked up are reported as warnings, except in offline mode.
+func (m *PackageManager) unknownDependencies(
+	manifests []hapkg.IntegrationManifest,
+	provided map[string]bool,
+	removed map[string]string,
+) []hapkg.ManifestIssue {
+	issues := make([]hapkg.ManifestIssue, 0)
+	for _, manifest := range manifests {
+		for _, dependency := range manifest.Dependencies {
+			if _, ok := removed[dependency]; ok || provided[dependency] {
+				continue
+			}
+			core, err := m.coreDomain(dependency)
+			switch {
+			case errors.Is(err, hapkg.ErrOffline):
+				// Home Assistant integrations can't be looked up offline.
+			case err != nil:
+				issues = append(issues, hapkg.ManifestIssue{
+					Domain:   manifest.Folder,
+					Severity: hapkg.IssueWarning,
+					Message:  fmt.Sprintf("can't check dependency %s: %v", dependency, err),
+				})
+			case !core:
+				issues = append(issues, hapkg.ManifestIssue{
+					Domain:   manifest.Folder,
+					Severity: hapkg.IssueError,
+					Message:  fmt.Sprintf("depends on %s, which is not provided by any package or Home Assistant", dependency),
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// coreDomain reports whether the domain is a Home Assistant integration.
+// It is looked up in the core repository at the configured version, or at
+// the development branch if the version is not set or not tagged. Results
+// are cached for the run, the first lookup failure is returned for every
+// later lookup without requesting the repository again.
+func (m *PackageManager) coreDomain(domain string) (bool, error) {
+	m.compatMu.Lock()
+	defer m.compatMu.Unlock()
+	if known, ok := m.core[domain]; ok {
+		return known, nil
+	}
+	if m.coreErr != nil {
+		return false, m.coreErr
+	}
+	if m.coreRef == "" {
+		ref := coreDevBranch
+		if m.haVersion != "" {
+			_, err := m.client.GetTreeFile(coreRepository, m.haVersion, coreConstants)
+			switch {
+			case err == nil:
+				ref = m.haVersion
+			case !errors.Is(err, os.ErrNotExist):
+				m.coreErr = err
+				return false, err
+			}
+		}
+		m.coreRef = ref
+	}
+	_, err := m.client.GetTreeFile(coreRepository, m.coreRef, path.Join(coreComponents, domain, "manifest.json"))
+	switch {
+	case err == nil:
+		m.core[domain] = true
+	case errors.Is(err, os.ErrNotExist):
+		m.core[domain] = false
+	default:
+		m.coreErr = err
+		return false, err
+	}
+	return m.core[domain], nil
+}
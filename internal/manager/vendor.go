@@ -0,0 +1,29 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+	"github.com/mishamyrt/hapm/internal/mirror"
+)
+
+// Vendor writes artifacts of installed packages to the mirror directory,
+// so the storage can be rebuilt from it without network access.
+func (m *PackageManager) Vendor(path string) ([]hapkg.PackageDescription, error) {
+	vendored := make([]hapkg.PackageDescription, 0, len(m.packages))
+	for _, pkg := range m.sortedPackages() {
+		provider, ok := pkg.(hapkg.SourceProvider)
+		if !ok {
+			return nil, fmt.Errorf("%s can't be vendored", pkg.FullName())
+		}
+		source, err := provider.Source()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s@%s: %w", pkg.FullName(), pkg.Version(), err)
+		}
+		if err := mirror.Write(path, pkg.FullName(), pkg.Version(), source); err != nil {
+			return nil, fmt.Errorf("vendoring %s@%s: %w", pkg.FullName(), pkg.Version(), err)
+		}
+		vendored = append(vendored, pkg.Description())
+	}
+	return vendored, nil
+}
@@ -6,7 +6,35 @@ import (
 	"github.com/mishamyrt/hapm/internal/hapkg"
 )
 
+const (
+	locationKey       = "location"
+	includeKey        = "include"
+	excludeKey        = "exclude"
+	holdKey           = "hold"
+	ignoreVersionsKey = "ignore_versions"
+	allowKey          = "allow"
+	allowUnstableKey  = "allow_unstable"
+)
+
+// entry is a parsed package entry of the manifest.
+type entry struct {
+	description hapkg.PackageDescription
+	policy      hapkg.UpdatePolicy
+}
+
 func ParseCategory(manifest map[string]any, key string) ([]hapkg.PackageDescription, error) {
+	entries, err := parseCategory(manifest, key)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]hapkg.PackageDescription, 0, len(entries))
+	for _, item := range entries {
+		items = append(items, item.description)
+	}
+	return items, nil
+}
+
+func parseCategory(manifest map[string]any, key string) ([]entry, error) {
 	value, ok := manifest[key]
 	if !ok {
 		return nil, fmt.Errorf("key %s is not found in repo", key)
@@ -15,21 +43,101 @@ func ParseCategory(manifest map[string]any, key string) ([]hapkg.PackageDescript
 	if !ok {
 		return nil, fmt.Errorf("category %s must be a list", key)
 	}
-	items := make([]hapkg.PackageDescription, 0, len(entries))
-	for _, entry := range entries {
-		item, ok := entry.(string)
+	items := make([]entry, 0, len(entries))
+	for _, value := range entries {
+		item, err := parseEntry(value)
+		if err != nil {
+			return nil, err
+		}
+		item.description.Kind = key
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseEntry parses a package entry. Entry is either a location string
+// or a mapping with location, export options and update policy.
+func parseEntry(value any) (entry, error) {
+	switch value := value.(type) {
+	case string:
+		description, err := parseEntryLocation(value)
+		return entry{description: description}, err
+	case map[string]any:
+		raw, ok := value[locationKey].(string)
 		if !ok {
-			return nil, fmt.Errorf("wrong entity: %v", entry)
+			return entry{}, fmt.Errorf("wrong entity: %v", value)
+		}
+		description, err := parseEntryLocation(raw)
+		if err != nil {
+			return entry{}, err
 		}
-		location, ok := ParseLocation(item)
-		if !ok || location.FullName == "" {
-			return nil, fmt.Errorf("wrong entity: %s", item)
+		item := entry{description: description}
+		for option, optionValue := range value {
+			switch option {
+			case locationKey:
+			case includeKey:
+				item.description.Include, err = parseStringList(raw, option, optionValue)
+			case excludeKey:
+				item.description.Exclude, err = parseStringList(raw, option, optionValue)
+			case holdKey:
+				item.policy.Hold, err = parseBool(raw, option, optionValue)
+			case ignoreVersionsKey:
+				item.policy.IgnoreVersions, err = parseStringList(raw, option, optionValue)
+			case allowKey:
+				item.policy.Allow, err = parseLevel(raw, optionValue)
+			case allowUnstableKey:
+				item.policy.AllowUnstable, err = parseBool(raw, option, optionValue)
+			default:
+				err = fmt.Errorf("unknown option %s of %s", option, raw)
+			}
+			if err != nil {
+				return item, err
+			}
 		}
-		items = append(items, hapkg.PackageDescription{
-			FullName: location.FullName,
-			Version:  location.Version,
-			Kind:     key,
-		})
+		return item, nil
+	}
+	return entry{}, fmt.Errorf("wrong entity: %v", value)
+}
+
+func parseEntryLocation(raw string) (hapkg.PackageDescription, error) {
+	location, ok := ParseLocation(raw)
+	if !ok || location.FullName == "" {
+		return hapkg.PackageDescription{}, fmt.Errorf("wrong entity: %s", raw)
+	}
+	return hapkg.PackageDescription{
+		FullName: location.FullName,
+		Version:  location.Version,
+	}, nil
+}
+
+func parseStringList(location string, option string, value any) ([]string, error) {
+	entries, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("option %s of %s must be a list", option, location)
+	}
+	items := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		item, ok := entry.(string)
+		if !ok || item == "" {
+			return nil, fmt.Errorf("wrong %s value of %s: %v", option, location, entry)
+		}
+		items = append(items, item)
 	}
 	return items, nil
 }
+
+func parseBool(location string, option string, value any) (bool, error) {
+	flag, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("option %s of %s must be a boolean", option, location)
+	}
+	return flag, nil
+}
+
+func parseLevel(location string, value any) (string, error) {
+	level, _ := value.(string)
+	if err := hapkg.ValidateLevel(level); err != nil {
+		return "", fmt.Errorf("option %s of %s: %w", allowKey, location, err)
+	}
+	return level, nil
+}
@@ -9,14 +9,28 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// homeAssistantKey is the manifest key of Home Assistant version packages
+// must be compatible with.
+const homeAssistantKey = "homeassistant"
+
 type Manifest struct {
 	Path      string
 	Values    []hapkg.PackageDescription
 	HasLatest []string
+	// HomeAssistant is the Home Assistant version of the installation.
+	HomeAssistant string
+	// Policies are update policies of entries keyed by package full name.
+	// Entries without a policy are missing.
+	Policies map[string]hapkg.UpdatePolicy
 }
 
 func New(path string) *Manifest {
-	return &Manifest{Path: path, Values: make([]hapkg.PackageDescription, 0), HasLatest: make([]string, 0)}
+	return &Manifest{
+		Path:      path,
+		Values:    make([]hapkg.PackageDescription, 0),
+		HasLatest: make([]string, 0),
+		Policies:  map[string]hapkg.UpdatePolicy{},
+	}
 }
 
 func (m *Manifest) Set(fullName string, version string, kind string) error {
@@ -45,11 +59,38 @@ func (m *Manifest) Init(types []string) error {
 	return os.WriteFile(m.Path, content, 0o644)
 }
 
+type entryValue struct {
+	Location       string   `yaml:"location"`
+	Include        []string `yaml:"include,omitempty"`
+	Exclude        []string `yaml:"exclude,omitempty"`
+	Hold           bool     `yaml:"hold,omitempty"`
+	IgnoreVersions []string `yaml:"ignore_versions,omitempty"`
+	Allow          string   `yaml:"allow,omitempty"`
+	AllowUnstable  bool     `yaml:"allow_unstable,omitempty"`
+}
+
 func (m *Manifest) Dump() error {
-	content := map[string][]string{}
+	content := map[string]any{}
+	if m.HomeAssistant != "" {
+		content[homeAssistantKey] = m.HomeAssistant
+	}
 	for _, pkg := range m.Values {
 		location := pkg.FullName + "@" + pkg.Version
-		content[pkg.Kind] = append(content[pkg.Kind], location)
+		entries, _ := content[pkg.Kind].([]any)
+		policy := m.Policies[pkg.FullName]
+		if !pkg.HasOptions() && policy.IsZero() {
+			content[pkg.Kind] = append(entries, location)
+			continue
+		}
+		content[pkg.Kind] = append(entries, entryValue{
+			Location:       location,
+			Include:        pkg.Include,
+			Exclude:        pkg.Exclude,
+			Hold:           policy.Hold,
+			IgnoreVersions: policy.IgnoreVersions,
+			Allow:          policy.Allow,
+			AllowUnstable:  policy.AllowUnstable,
+		})
 	}
 	data, err := yaml.Marshal(content)
 	if err != nil {
@@ -73,24 +114,39 @@ func (m *Manifest) Load() error {
 	if raw == nil {
 		return fmt.Errorf("manifest is empty")
 	}
+	settings := struct {
+		HomeAssistant string `yaml:"homeassistant"`
+	}{}
+	if err := yaml.Unmarshal(stream, &settings); err != nil {
+		return fmt.Errorf("%s must be a version: %w", homeAssistantKey, err)
+	}
+	m.HomeAssistant = settings.HomeAssistant
 	m.Values = m.Values[:0]
 	m.HasLatest = m.HasLatest[:0]
+	m.Policies = map[string]hapkg.UpdatePolicy{}
 	keys := make([]string, 0, len(raw))
 	for key := range raw {
+		if key == homeAssistantKey {
+			continue
+		}
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
 	for _, key := range keys {
-		values, err := ParseCategory(raw, key)
+		entries, err := parseCategory(raw, key)
 		if err != nil {
 			return err
 		}
-		for _, value := range values {
+		for _, entry := range entries {
+			value := entry.description
 			if value.Version == "latest" {
 				m.HasLatest = append(m.HasLatest, value.FullName)
 			}
+			if !entry.policy.IsZero() {
+				m.Policies[value.FullName] = entry.policy
+			}
+			m.Values = append(m.Values, value)
 		}
-		m.Values = append(m.Values, values...)
 	}
 	return nil
 }
@@ -49,6 +49,37 @@ func TestParseCategory(t *testing.T) {
 	}
 }
 
+func TestParseCategoryOptions(t *testing.T) {
+	manifestContent := map[string]any{"integrations": []any{
+		map[string]any{
+			"location": "foo/multi@v1.0.0",
+			"include":  []any{"alpha", "beta"},
+		},
+		map[string]any{
+			"location": "foo/other@v1.0.0",
+			"exclude":  []any{"gamma"},
+		},
+	}}
+	items, err := ParseCategory(manifestContent, "integrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || len(items[0].Include) != 2 || items[1].Exclude[0] != "gamma" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+
+	invalid := []map[string]any{
+		{"include": []any{"alpha"}},
+		{"location": "foo/bar@v1.0.0", "include": "alpha"},
+		{"location": "foo/bar@v1.0.0", "unknown": true},
+	}
+	for _, entry := range invalid {
+		if _, err := ParseCategory(map[string]any{"integrations": []any{entry}}, "integrations"); err == nil {
+			t.Fatalf("expected error for %+v", entry)
+		}
+	}
+}
+
 func TestManifestInitLoadDumpSet(t *testing.T) {
 	tmp := t.TempDir()
 	path := filepath.Join(tmp, "hapm.yaml")
@@ -73,6 +104,7 @@ func TestManifestInitLoadDumpSet(t *testing.T) {
 	if err := manifest.Set("foo/plugin", "latest", "plugins"); err != nil {
 		t.Fatal(err)
 	}
+	manifest.Values[0].Include = []string{"bar"}
 	if err := manifest.Dump(); err != nil {
 		t.Fatal(err)
 	}
@@ -84,11 +116,40 @@ func TestManifestInitLoadDumpSet(t *testing.T) {
 	if len(loaded.Values) != 2 {
 		t.Fatalf("expected 2 values, got %d", len(loaded.Values))
 	}
+	if len(loaded.Values[0].Include) != 1 || loaded.Values[0].Include[0] != "bar" {
+		t.Fatalf("unexpected options: %+v", loaded.Values[0])
+	}
 	if len(loaded.HasLatest) != 1 || loaded.HasLatest[0] != "foo/plugin" {
 		t.Fatalf("unexpected latest list: %+v", loaded.HasLatest)
 	}
 }
 
+func TestManifestHomeAssistantVersion(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "hapm.yaml")
+	content := "homeassistant: 2024.10\nintegrations:\n  - foo/bar@v1.0.0\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := New(path)
+	if err := manifest.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.HomeAssistant != "2024.10" || len(manifest.Values) != 1 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if err := manifest.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.HomeAssistant != "2024.10" {
+		t.Fatalf("unexpected Home Assistant version after dump: %q", loaded.HomeAssistant)
+	}
+}
+
 func TestManifestSetRequiresKind(t *testing.T) {
 	manifest := New("unused")
 	if err := manifest.Set("foo/bar", "v1.0.0", ""); err == nil {
@@ -96,6 +157,59 @@ func TestManifestSetRequiresKind(t *testing.T) {
 	}
 }
 
+func TestManifestPolicies(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "hapm.yaml")
+	content := `integrations:
+  - location: foo/held@v1.0.0
+    hold: true
+  - location: foo/careful@latest
+    allow: minor
+    ignore_versions: [v1.5.0, "v2.*"]
+    allow_unstable: true
+  - foo/plain@v1.0.0
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := New(path)
+	if err := manifest.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Policies) != 2 || !manifest.Policies["foo/held"].Hold {
+		t.Fatalf("unexpected policies: %+v", manifest.Policies)
+	}
+	careful := manifest.Policies["foo/careful"]
+	if careful.Allow != "minor" || !careful.AllowUnstable || len(careful.IgnoreVersions) != 2 {
+		t.Fatalf("unexpected policy: %+v", careful)
+	}
+	if manifest.Values[0].HasOptions() {
+		t.Fatalf("policy is parsed as export options: %+v", manifest.Values[0])
+	}
+
+	if err := manifest.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Policies["foo/held"].Hold || loaded.Policies["foo/careful"].Allow != "minor" {
+		t.Fatalf("policies are lost on dump: %+v", loaded.Policies)
+	}
+
+	invalid := []map[string]any{
+		{"location": "foo/bar@v1.0.0", "hold": "yes"},
+		{"location": "foo/bar@v1.0.0", "allow": "breaking"},
+		{"location": "foo/bar@v1.0.0", "ignore_versions": "v1.0.0"},
+	}
+	for _, entry := range invalid {
+		if _, err := ParseCategory(map[string]any{"integrations": []any{entry}}, "integrations"); err == nil {
+			t.Fatalf("expected error for %+v", entry)
+		}
+	}
+}
+
 func TestManifestLoadErrors(t *testing.T) {
 	tmp := t.TempDir()
 	path := filepath.Join(tmp, "hapm.yaml")
@@ -107,3 +221,67 @@ func TestManifestLoadErrors(t *testing.T) {
 		t.Fatalf("expected empty manifest error")
 	}
 }
+
+func TestManifestSetVersionsKeepsFormatting(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "hapm.yaml")
+	content := `# Home Assistant packages
+homeassistant: 2024.10
+integrations:
+    - foo/bar@v1.0.0   # pinned on purpose
+    - "foo/quoted@v0.1.0"
+    - location: foo/multi@v2.0.0
+      include: [multi]
+    - foo/latest
+plugins: [https://github.com/foo/card/releases/tag/v1.2.0, 'foo/other@v3.0.0']
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := New(path)
+	if err := manifest.Load(); err != nil {
+		t.Fatal(err)
+	}
+	err := manifest.SetVersions(map[string]string{
+		"foo/bar":    "v1.0.1",
+		"foo/quoted": "v0.2.0",
+		"foo/multi":  "v2.1.0",
+		"foo/latest": "v9.0.0",
+		"foo/card":   "v1.3.0",
+		"foo/other":  "v3.0.10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Home Assistant packages
+homeassistant: 2024.10
+integrations:
+    - foo/bar@v1.0.1   # pinned on purpose
+    - "foo/quoted@v0.2.0"
+    - location: foo/multi@v2.1.0
+      include: [multi]
+    - foo/latest
+plugins: [https://github.com/foo/card/releases/tag/v1.3.0, 'foo/other@v3.0.10']
+`
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != expected {
+		t.Fatalf("unexpected manifest:\n%s", raw)
+	}
+
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	versions := map[string]string{}
+	for _, value := range loaded.Values {
+		versions[value.FullName] = value.Version
+	}
+	for _, value := range manifest.Values {
+		if versions[value.FullName] != value.Version {
+			t.Fatalf("loaded manifest differs for %s: %s", value.FullName, versions[value.FullName])
+		}
+	}
+}
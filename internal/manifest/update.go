@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const releaseTagPath = "/releases/tag/"
+
+// locationEdit replaces a location scalar of the manifest source.
+type locationEdit struct {
+	node     *yaml.Node
+	location string
+}
+
+// SetVersions changes versions of the pinned package entries, keyed by full
+// name, and writes the manifest. Only the locations are rewritten, so
+// comments, order and formatting of the file are kept.
+func (m *Manifest) SetVersions(versions map[string]string) error {
+	content, err := os.ReadFile(m.Path)
+	if err != nil {
+		return err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return err
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("manifest must be a mapping")
+	}
+	edits := make([]locationEdit, 0, len(versions))
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == homeAssistantKey || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, entry := range root.Content[i+1].Content {
+			node := locationNode(entry)
+			if node == nil {
+				continue
+			}
+			location, ok := ParseLocation(node.Value)
+			if !ok || location.Version == "latest" {
+				continue
+			}
+			version, ok := versions[location.FullName]
+			if !ok {
+				continue
+			}
+			edits = append(edits, locationEdit{node: node, location: replaceVersion(node.Value, version)})
+		}
+	}
+	updated, err := applyEdits(string(content), edits)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.Path, []byte(updated), 0o644); err != nil {
+		return err
+	}
+	for i := range m.Values {
+		if version, ok := versions[m.Values[i].FullName]; ok && m.Values[i].Version != "latest" {
+			m.Values[i].Version = version
+		}
+	}
+	return nil
+}
+
+// locationNode returns the location scalar of the entry, which is either
+// the entry itself or its location option.
+func locationNode(entry *yaml.Node) *yaml.Node {
+	if entry.Kind == yaml.ScalarNode {
+		return entry
+	}
+	if entry.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(entry.Content); i += 2 {
+		if entry.Content[i].Value == locationKey && entry.Content[i+1].Kind == yaml.ScalarNode {
+			return entry.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// replaceVersion returns the pinned location with another version, keeping
+// its form.
+func replaceVersion(location string, version string) string {
+	if index := strings.LastIndex(location, releaseTagPath); index >= 0 {
+		return location[:index+len(releaseTagPath)] + version
+	}
+	if index := strings.LastIndex(location, "@"); index >= 0 {
+		return location[:index+1] + version
+	}
+	return location + "@" + version
+}
+
+// applyEdits replaces scalars in the source at their positions. Quoted
+// scalars keep their quotes.
+func applyEdits(source string, edits []locationEdit) (string, error) {
+	sort.Slice(edits, func(i int, j int) bool {
+		if edits[i].node.Line != edits[j].node.Line {
+			return edits[i].node.Line < edits[j].node.Line
+		}
+		return edits[i].node.Column > edits[j].node.Column
+	})
+	lines := strings.SplitAfter(source, "\n")
+	for _, edit := range edits {
+		node := edit.node
+		if node.Line < 1 || node.Line > len(lines) {
+			return "", fmt.Errorf("location %s is out of the manifest", node.Value)
+		}
+		line := []rune(lines[node.Line-1])
+		quote := ""
+		switch node.Style {
+		case yaml.DoubleQuotedStyle:
+			quote = `"`
+		case yaml.SingleQuotedStyle:
+			quote = "'"
+		}
+		old := []rune(quote + node.Value + quote)
+		start := node.Column - 1
+		if start < 0 || start+len(old) > len(line) || string(line[start:start+len(old)]) != string(old) {
+			return "", fmt.Errorf("can't update location %s in place", node.Value)
+		}
+		replaced := string(line[:start]) + quote + edit.location + quote + string(line[start+len(old):])
+		lines[node.Line-1] = replaced
+	}
+	return strings.Join(lines, ""), nil
+}
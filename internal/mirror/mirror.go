@@ -0,0 +1,233 @@
+// Package mirror implements a directory layout of vendored package
+// artifacts and a git client that serves packages from it.
+//
+// Every repository has its own folder:
+//
+//	<owner>/<repo>/tags.json
+//	<owner>/<repo>/<version>/source.tar.gz
+//	<owner>/<repo>/<version>/assets/<name>
+package mirror
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+const (
+	tagsName    = "tags.json"
+	tarballName = "source.tar.gz"
+	assetsDir   = "assets"
+)
+
+type tagsFile struct {
+	Versions []string `json:"versions"`
+}
+
+// Write stores the package version source in the mirror and adds the
+// version to repository tags.
+func Write(root string, fullName string, version string, source hapkg.Source) error {
+	dir := versionPath(root, fullName, version)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if source.Tarball != "" {
+		if err := copyFile(source.Tarball, filepath.Join(dir, tarballName)); err != nil {
+			return err
+		}
+	}
+	for name, content := range source.Assets {
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("unsafe asset name: %s", name)
+		}
+		target := filepath.Join(dir, assetsDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return addVersion(root, fullName, version)
+}
+
+// Client is a git client that serves repositories from the mirror
+// directory and never accesses the network.
+type Client struct {
+	root string
+}
+
+func NewClient(root string) *Client {
+	return &Client{root: root}
+}
+
+func (c *Client) GetVersions(fullName string) ([]string, error) {
+	versions, err := readVersions(c.root, fullName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s is not mirrored", fullName)
+	}
+	return versions, err
+}
+
+// GetTreeFile reads the file from the mirrored repository tarball. A file
+// missing in the tarball and a version vendored with release assets only
+// are reported as os.ErrNotExist, a version that is not mirrored is not.
+func (c *Client) GetTreeFile(fullName string, branch string, filePath string) ([]byte, error) {
+	dir := versionPath(c.root, fullName, branch)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s@%s is not mirrored", fullName, branch)
+	}
+	archivePath := filepath.Join(dir, tarballName)
+	if _, err := os.Stat(archivePath); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s@%s has no mirrored source: %w", fullName, branch, os.ErrNotExist)
+	}
+	content, err := readTarballFile(archivePath, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", fullName, branch, err)
+	}
+	return content, nil
+}
+
+func (c *Client) GetReleaseFile(fullName string, branch string, filename string) (io.ReadCloser, error) {
+	if !filepath.IsLocal(filename) {
+		return nil, fmt.Errorf("unsafe asset name: %s", filename)
+	}
+	return os.Open(filepath.Join(versionPath(c.root, fullName, branch), assetsDir, filepath.FromSlash(filename)))
+}
+
+func (c *Client) GetReleaseAssets(fullName string, branch string) ([]string, error) {
+	root := filepath.Join(versionPath(c.root, fullName, branch), assetsDir)
+	names := make([]string, 0)
+	err := filepath.WalkDir(root, func(current string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && current == root {
+			return fs.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *Client) GetTarball(fullName string, branch string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(versionPath(c.root, fullName, branch), tarballName))
+}
+
+func versionPath(root string, fullName string, version string) string {
+	return filepath.Join(root, filepath.FromSlash(fullName), url.PathEscape(version))
+}
+
+func readVersions(root string, fullName string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(fullName), tagsName))
+	if err != nil {
+		return nil, err
+	}
+	var file tagsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing mirrored tags of %s: %w", fullName, err)
+	}
+	return file.Versions, nil
+}
+
+func addVersion(root string, fullName string, version string) error {
+	versions, err := readVersions(root, fullName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, existing := range versions {
+		if existing == version {
+			return nil
+		}
+	}
+	versions = append(versions, version)
+	sort.Strings(versions)
+	content, err := json.MarshalIndent(tagsFile{Versions: versions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, filepath.FromSlash(fullName), tagsName), content, 0o644)
+}
+
+// readTarballFile returns the file located at filePath relative to the
+// top-level folder of the repository tarball.
+func readTarballFile(archivePath string, filePath string) ([]byte, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	filePath = path.Clean(filePath)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: %w", filePath, os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(filepath.ToSlash(header.Name), "./"), "/", 2)
+		if len(parts) == 2 && parts[1] == filePath {
+			return io.ReadAll(reader)
+		}
+	}
+}
+
+func copyFile(source string, target string) (err error) {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+	output, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := output.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(output, input)
+	return err
+}
@@ -0,0 +1,159 @@
+package mirror
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mishamyrt/hapm/internal/hapkg"
+)
+
+func TestMirrorClient(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "vendor")
+	tarball := filepath.Join(tmp, "source.tar.gz")
+	if err := os.WriteFile(tarball, makeTarball(t, map[string]string{
+		"foo-bar-abc/hacs.json":                           `{"homeassistant":"2024.1.0"}`,
+		"foo-bar-abc/custom_components/bar/manifest.json": testManifest("bar"),
+	}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(root, "foo/bar", "v1.1.0", hapkg.Source{Tarball: tarball}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(root, "foo/bar", "v1.0.0", hapkg.Source{Tarball: tarball}); err != nil {
+		t.Fatal(err)
+	}
+	assets := map[string][]byte{"card.js": []byte("card"), "assets/icon.svg": []byte("<svg/>")}
+	if err := Write(root, "foo/card", "v2.0.0", hapkg.Source{Assets: assets}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(root)
+	versions, err := client.GetVersions("foo/bar")
+	if err != nil || !reflect.DeepEqual(versions, []string{"v1.0.0", "v1.1.0"}) {
+		t.Fatalf("unexpected versions: %v, %v", versions, err)
+	}
+	if _, err := client.GetVersions("foo/missing"); err == nil {
+		t.Fatal("expected error for repository that is not mirrored")
+	}
+	content, err := client.GetTreeFile("foo/bar", "v1.0.0", "hacs.json")
+	if err != nil || string(content) != `{"homeassistant":"2024.1.0"}` {
+		t.Fatalf("unexpected tree file: %q, %v", content, err)
+	}
+	if _, err := client.GetTreeFile("foo/bar", "v1.0.0", "missing.json"); err == nil {
+		t.Fatal("expected error for missing tree file")
+	}
+	names, err := client.GetReleaseAssets("foo/card", "v2.0.0")
+	if err != nil || !reflect.DeepEqual(names, []string{"assets/icon.svg", "card.js"}) {
+		t.Fatalf("unexpected assets: %v, %v", names, err)
+	}
+	if names, err := client.GetReleaseAssets("foo/bar", "v1.0.0"); err != nil || len(names) != 0 {
+		t.Fatalf("unexpected assets of tarball package: %v, %v", names, err)
+	}
+	if _, err := client.GetReleaseFile("foo/card", "v2.0.0", "../../bar/tags.json"); err == nil {
+		t.Fatal("expected error for unsafe asset name")
+	}
+	if _, err := client.GetTreeFile("foo/missing", "v1.0.0", "hacs.json"); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected error for version that is not mirrored, got %v", err)
+	}
+}
+
+func TestMirrorCompatibilityOfReleaseAssets(t *testing.T) {
+	root := t.TempDir()
+	if err := Write(root, "foo/card", "v1.0.0", hapkg.Source{Assets: map[string][]byte{"card.js": []byte("card")}}); err != nil {
+		t.Fatal(err)
+	}
+	required, err := hapkg.MinHomeAssistantVersion(NewClient(root), "foo/card", "v1.0.0")
+	if err != nil || required != "" {
+		t.Fatalf("unexpected requirement of release assets: %q, %v", required, err)
+	}
+}
+
+func TestMirrorRoundTrip(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "vendor")
+	tarball := filepath.Join(tmp, "source.tar.gz")
+	if err := os.WriteFile(tarball, makeTarball(t, map[string]string{
+		"foo-bar-abc/custom_components/bar/manifest.json": testManifest("bar"),
+	}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	assets := map[string][]byte{"card.js": []byte("card"), "card.css": []byte("css")}
+	if err := Write(root, "foo/bar", "v1.0.0", hapkg.Source{Tarball: tarball}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(root, "foo/card", "v2.0.0", hapkg.Source{Assets: assets}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(root)
+	storage := filepath.Join(tmp, "storage")
+	if err := os.MkdirAll(storage, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	integration := hapkg.NewIntegrationPackage(hapkg.PackageDescription{
+		FullName: "foo/bar", Kind: hapkg.IntegrationKind, Version: "v1.0.0",
+	}, storage, client)
+	plugin := hapkg.NewPluginPackage(hapkg.PackageDescription{
+		FullName: "foo/card", Kind: hapkg.PluginKind, Version: "v2.0.0",
+	}, storage, client)
+	for _, pkg := range []hapkg.Package{integration, plugin} {
+		if err := pkg.Setup(); err != nil {
+			t.Fatalf("setup %s: %v", pkg.FullName(), err)
+		}
+	}
+
+	source, err := integration.(hapkg.SourceProvider).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(source.Tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := os.ReadFile(tarball)
+	if !bytes.Equal(stored, original) {
+		t.Fatal("integration tarball differs from the mirrored one")
+	}
+	source, err = plugin.(hapkg.SourceProvider).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(source.Assets, assets) {
+		t.Fatalf("unexpected plugin files: %v", source.Assets)
+	}
+}
+
+func testManifest(domain string) string {
+	return fmt.Sprintf(`{"domain":%q,"name":"Test","version":"1.0.0","codeowners":["@test"]}`, domain)
+}
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("write header %s: %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("write content %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
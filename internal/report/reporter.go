@@ -8,10 +8,13 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
+	"github.com/mishamyrt/hapm/internal/cache"
 	"github.com/mishamyrt/hapm/internal/hapkg"
 	"github.com/mishamyrt/hapm/internal/manager"
+	"github.com/mishamyrt/hapm/internal/storagelock"
 )
 
 const tokenGenerateLink = "https://github.com/settings/tokens"
@@ -98,7 +101,75 @@ func (r Reporter) Diff(diff []manager.PackageDiff, fullName bool, updatesOnly bo
 	_, _ = fmt.Fprint(r.out, builder.String()+"\r")
 }
 
-func (r Reporter) Packages(packages []hapkg.PackageDescription) {
+// HeldUpdates prints updates that package policies hold back.
+func (r Reporter) HeldUpdates(updates []manager.PackageDiff) {
+	var builder strings.Builder
+	builder.WriteString(paint("\nHeld back by package policies:", color.Faint) + "\n")
+	for _, update := range updates {
+		line := update.FullName + "@" + update.Version + " (" + update.CurrentVersion + ") " + update.Held
+		builder.WriteString("  " + paint(line, color.Faint) + "\n")
+	}
+	_, _ = fmt.Fprint(r.out, builder.String())
+}
+
+// Changelog prints release notes of package updates.
+func (r Reporter) Changelog(changelogs []manager.PackageChangelog) {
+	var builder strings.Builder
+	for i, changelog := range changelogs {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		title := changelog.FullName + " " + changelog.CurrentVersion + " → " + changelog.Version
+		builder.WriteString(paint(title, color.Bold) + "\n")
+		if len(changelog.Releases) == 0 {
+			builder.WriteString("  " + paint("No release notes found", color.Faint) + "\n")
+		}
+		for _, release := range changelog.Releases {
+			builder.WriteString("  " + paint(release.Tag, color.FgYellow))
+			if details := releaseDetails(release); details != "" {
+				builder.WriteString(" " + paint(details, color.Faint))
+			}
+			builder.WriteString("\n")
+			body := releaseBody(release)
+			if body == "" {
+				builder.WriteString("    " + paint("No description", color.Faint) + "\n")
+				continue
+			}
+			for _, line := range strings.Split(body, "\n") {
+				builder.WriteString(strings.TrimRight("    "+line, " ") + "\n")
+			}
+		}
+	}
+	_, _ = fmt.Fprint(r.out, builder.String())
+}
+
+// ChangelogMarkdown prints release notes of package updates as markdown,
+// which can be pasted to pull request descriptions.
+func (r Reporter) ChangelogMarkdown(changelogs []manager.PackageChangelog) {
+	var builder strings.Builder
+	for i, changelog := range changelogs {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		_, _ = fmt.Fprintf(&builder, "## %s %s → %s\n", changelog.FullName, changelog.CurrentVersion, changelog.Version)
+		if len(changelog.Releases) == 0 {
+			builder.WriteString("\n_No release notes found._\n")
+		}
+		for _, release := range changelog.Releases {
+			builder.WriteString("\n### " + release.Tag)
+			if details := releaseDetails(release); details != "" {
+				builder.WriteString(" " + details)
+			}
+			builder.WriteString("\n")
+			if body := releaseBody(release); body != "" {
+				builder.WriteString("\n" + body + "\n")
+			}
+		}
+	}
+	_, _ = fmt.Fprint(r.out, builder.String())
+}
+
+func (r Reporter) Packages(packages []hapkg.PackageDescription, domains map[string][]string) {
 	groups := groupPackagesByKind(packages)
 	keys := make([]string, 0, len(groups))
 	for kind := range groups {
@@ -109,7 +180,7 @@ func (r Reporter) Packages(packages []hapkg.PackageDescription) {
 	for _, kind := range keys {
 		builder.WriteString(formatKind(kind) + "\n")
 		for _, pkg := range groups[kind] {
-			builder.WriteString(formatPackage(pkg) + "\n")
+			builder.WriteString(formatPackage(pkg, domains[pkg.FullName]) + "\n")
 		}
 	}
 	_, _ = fmt.Fprint(r.out, builder.String()+"\r")
@@ -131,6 +202,8 @@ func (r Reporter) Summary(diff []manager.PackageDiff) {
 	adds := 0
 	deletes := 0
 	switches := 0
+	configures := 0
+	reinstalls := 0
 	for _, pkg := range diff {
 		switch pkg.Operation {
 		case "add":
@@ -139,6 +212,10 @@ func (r Reporter) Summary(diff []manager.PackageDiff) {
 			deletes++
 		case "switch":
 			switches++
+		case "configure":
+			configures++
+		case "reinstall":
+			reinstalls++
 		}
 	}
 	parts := make([]string, 0)
@@ -151,6 +228,12 @@ func (r Reporter) Summary(diff []manager.PackageDiff) {
 	if switches > 0 {
 		parts = append(parts, fmt.Sprintf("switched %s", paint(switches, color.FgHiCyan)))
 	}
+	if configures > 0 {
+		parts = append(parts, fmt.Sprintf("reconfigured %s", paint(configures, color.FgHiCyan)))
+	}
+	if reinstalls > 0 {
+		parts = append(parts, fmt.Sprintf("reinstalled %s", paint(reinstalls, color.FgHiCyan)))
+	}
 	_, _ = fmt.Fprintf(r.out, "\nDone: %s\n", strings.Join(parts, ", "))
 }
 
@@ -165,14 +248,314 @@ func (r Reporter) PluginExportHint(files []string) {
 	_, _ = fmt.Fprintln(r.out, paint(heading, color.FgYellow))
 	prefix := paint("*", color.Faint)
 	for _, file := range files {
-		_, _ = fmt.Fprintf(r.out, "%s /local/custom_lovelace/%s\n", prefix, file)
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", prefix, hapkg.PluginURL(file))
 	}
 	_, _ = fmt.Fprintln(r.out, paint("Resources URL: "+resourcesRedirectURL, color.Faint))
 }
 
+func (r Reporter) ResourcesWritten(path string, files []string) {
+	_, _ = fmt.Fprintf(r.out, "Lovelace resources are written to %s (%s)\n", path, paint(len(files), color.FgHiCyan))
+}
+
+func (r Reporter) ExportConflicts(conflicts []manager.ExportConflict) {
+	groups := map[string][]string{}
+	keys := make([]string, 0)
+	for _, conflict := range conflicts {
+		key := strings.Join(conflict.Packages, " and ")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], conflict.Path)
+	}
+	sort.Strings(keys)
+	r.Error("Packages export conflicting files")
+	prefix := paint("*", color.Faint)
+	for _, key := range keys {
+		_, _ = fmt.Fprintln(r.out, paint(key+" export the same files:", color.FgYellow))
+		for _, path := range groups[key] {
+			_, _ = fmt.Fprintf(r.out, "%s %s\n", prefix, path)
+		}
+	}
+}
+
+func (r Reporter) UnmanagedFiles(paths []string) {
+	r.Error("Export would overwrite files that are not managed by hapm")
+	prefix := paint("*", color.Faint)
+	for _, path := range paths {
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", prefix, path)
+	}
+}
+
+func (r Reporter) ExportChanges(changes []manager.ExportChange) {
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(r.out, "Export is up to date")
+		return
+	}
+	counts := map[string]int{}
+	for _, change := range changes {
+		counts[change.Operation]++
+		_, _ = fmt.Fprintln(r.out, formatChange(change))
+	}
+	parts := make([]string, 0)
+	if counts["add"] > 0 {
+		parts = append(parts, fmt.Sprintf("added %s", paint(counts["add"], color.FgHiCyan)))
+	}
+	if counts["update"] > 0 {
+		parts = append(parts, fmt.Sprintf("updated %s", paint(counts["update"], color.FgHiCyan)))
+	}
+	if counts["delete"] > 0 {
+		parts = append(parts, fmt.Sprintf("removed %s", paint(counts["delete"], color.FgHiCyan)))
+	}
+	_, _ = fmt.Fprintf(r.out, "\nDone: %s\n", strings.Join(parts, ", "))
+}
+
+func (r Reporter) Archive(path string, info manager.ArchiveInfo) {
+	_, _ = fmt.Fprintf(r.out, "Packages are exported to %s (%s, %d bytes)\n", path, info.Format, info.Size)
+	_, _ = fmt.Fprintln(r.out, paint("Media type: "+info.MediaType, color.Faint))
+	_, _ = fmt.Fprintln(r.out, paint("Digest:     "+info.Digest, color.Faint))
+	_, _ = fmt.Fprintln(r.out, paint("Diff ID:    "+info.DiffID, color.Faint))
+}
+
+func (r Reporter) ExportPlan(changes []manager.ExportChange) {
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(r.out, "Export is up to date")
+		return
+	}
+	groups := map[string][]manager.ExportChange{}
+	keys := make([]string, 0)
+	for _, change := range changes {
+		if _, ok := groups[change.Package]; !ok {
+			keys = append(keys, change.Package)
+		}
+		groups[change.Package] = append(groups[change.Package], change)
+	}
+	sort.Slice(keys, func(i int, j int) bool {
+		if keys[i] == "" || keys[j] == "" {
+			return keys[j] == ""
+		}
+		return keys[i] < keys[j]
+	})
+	var builder strings.Builder
+	counts := map[string]int{}
+	sizes := map[string]int64{}
+	for _, key := range keys {
+		title := key
+		if title == "" {
+			title = "Other files"
+		}
+		builder.WriteString(paint(title+":", color.Faint) + "\n")
+		for _, change := range groups[key] {
+			counts[change.Operation]++
+			sizes[change.Operation] += change.Size
+			builder.WriteString("  " + formatChange(change) + " " + paint(formatSize(change.Size), color.Faint) + "\n")
+		}
+	}
+	_, _ = fmt.Fprint(r.out, builder.String())
+
+	parts := make([]string, 0)
+	labels := [][2]string{{"add", "add"}, {"update", "update"}, {"delete", "remove"}}
+	for _, label := range labels {
+		if counts[label[0]] == 0 {
+			continue
+		}
+		noun := "files"
+		if counts[label[0]] == 1 {
+			noun = "file"
+		}
+		parts = append(parts, fmt.Sprintf(
+			"%s %s %s (%s)",
+			label[1],
+			paint(counts[label[0]], color.FgHiCyan),
+			noun,
+			formatSize(sizes[label[0]]),
+		))
+	}
+	_, _ = fmt.Fprintf(r.out, "\nWould %s\n", strings.Join(parts, ", "))
+}
+
+func (r Reporter) Offline(packages []string) {
+	r.Error("Network access is disabled, but these packages can't be resolved locally:")
+	prefix := paint("*", color.Faint)
+	for _, pkg := range packages {
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", prefix, pkg)
+	}
+	_, _ = fmt.Fprintln(r.out, paint("Run the command without --offline to download them to the cache", color.Faint))
+}
+
+// PackageErrors prints a table of packages that failed and their errors.
+func (r Reporter) PackageErrors(action string, packages []manager.PackageError) {
+	r.Error("Error while " + action + ":")
+	width := 0
+	for _, pkg := range packages {
+		width = max(width, len(pkg.FullName))
+	}
+	for _, pkg := range packages {
+		name := pkg.FullName + strings.Repeat(" ", width-len(pkg.FullName))
+		_, _ = fmt.Fprintf(r.out, "%s %s  %s\n", paint("*", color.Faint), name, paint(pkg.Err.Error(), color.FgRed))
+	}
+}
+
+func (r Reporter) WaitingForLock(holder storagelock.Holder) {
+	r.Warning(fmt.Sprintf("Waiting for process %d on %s to release the storage", holder.PID, holder.Host))
+}
+
+func (r Reporter) StorageLocked(path string, holder storagelock.Holder) {
+	r.Error(fmt.Sprintf(
+		"Storage is locked by process %d on %s since %s",
+		holder.PID,
+		holder.Host,
+		holder.StartedAt.Local().Format(time.DateTime),
+	))
+	hint := "Use --wait to wait for it. If the process is gone, remove " + path
+	_, _ = fmt.Fprintln(r.out, paint(hint, color.Faint))
+}
+
+func (r Reporter) Incompatible(haVersion string, packages []manager.Incompatibility) {
+	r.Error("Packages are not compatible with Home Assistant " + haVersion)
+	for _, pkg := range packages {
+		requires := paint("requires "+pkg.Requires, color.Faint)
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", formatVersion(pkg.FullName, pkg.Version), requires)
+	}
+}
+
+func (r Reporter) CacheEntries(path string, entries []cache.Entry) {
+	_, _ = fmt.Fprintln(r.out, paint("Cache: "+path, color.Faint))
+	if len(entries) == 0 {
+		_, _ = fmt.Fprintln(r.out, "Cache is empty")
+		return
+	}
+	total := int64(0)
+	for _, entry := range entries {
+		total += entry.Size
+		details := paint(fmt.Sprintf("%s, %s, %s", entry.Kind, formatSize(entry.Size), shortDigest(entry.Digest)), color.Faint)
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", formatVersion(entry.FullName, entry.Version), details)
+	}
+	_, _ = fmt.Fprintf(r.out, "\nTotal: %s artifacts, %s\n", paint(len(entries), color.FgHiCyan), formatSize(total))
+}
+
+func (r Reporter) CachePruned(result cache.PruneResult) {
+	for _, entry := range result.Entries {
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", paint("-", color.FgRed), formatVersion(entry.FullName, entry.Version))
+	}
+	_, _ = fmt.Fprintf(
+		r.out,
+		"Done: removed %s entries and %s artifacts (%s)\n",
+		paint(len(result.Entries), color.FgHiCyan),
+		paint(result.Blobs, color.FgHiCyan),
+		formatSize(result.Size),
+	)
+}
+
+func (r Reporter) CacheProblems(problems []cache.Problem) {
+	r.Error("Cache has corrupted artifacts")
+	for _, problem := range problems {
+		_, _ = fmt.Fprintf(
+			r.out,
+			"%s %s\n",
+			formatVersion(problem.Entry.FullName, problem.Entry.Version),
+			paint(problem.Message, color.FgYellow),
+		)
+	}
+	_, _ = fmt.Fprintln(r.out, paint("Run hapm cache prune --all to drop the cache", color.Faint))
+}
+
+func (r Reporter) CacheValid() {
+	_, _ = fmt.Fprintln(r.out, "Cache is valid")
+}
+
+func (r Reporter) ManifestIssues(packages []manager.PackageIssues) {
+	for _, pkg := range packages {
+		_, _ = fmt.Fprintln(r.out, paint(pkg.Package+":", color.Bold))
+		for _, issue := range pkg.Issues {
+			severity := paint(issue.Severity, color.FgYellow)
+			if issue.Severity == hapkg.IssueError {
+				severity = paint(issue.Severity, color.FgRed)
+			}
+			_, _ = fmt.Fprintf(r.out, "  %s %s: %s\n", severity, issue.Domain, issue.Message)
+		}
+	}
+}
+
+func (r Reporter) RequirementConflicts(conflicts []manager.RequirementConflict) {
+	r.Error("Integrations require conflicting package versions")
+	prefix := paint("*", color.Faint)
+	for _, conflict := range conflicts {
+		_, _ = fmt.Fprintln(r.out, paint(conflict.Name+":", color.FgYellow))
+		for _, requirement := range conflict.Requirements {
+			source := paint(requirement.Package+" ("+requirement.Domain+")", color.Faint)
+			_, _ = fmt.Fprintf(r.out, "%s %s %s\n", prefix, requirement.Spec, source)
+		}
+	}
+}
+
+func (r Reporter) RequirementsWritten(path string, count int) {
+	_, _ = fmt.Fprintf(r.out, "Requirements are written to %s (%s)\n", path, paint(count, color.FgHiCyan))
+}
+
+func (r Reporter) Vendored(path string, packages []hapkg.PackageDescription) {
+	for _, pkg := range packages {
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", paint("+", color.FgGreen), formatVersion(pkg.FullName, pkg.Version))
+	}
+	_, _ = fmt.Fprintf(r.out, "Done: %s packages are vendored to %s\n", paint(len(packages), color.FgHiCyan), path)
+}
+
+func (r Reporter) ManifestBumped(path string, bumps []manager.PackageDiff) {
+	for _, bump := range bumps {
+		_, _ = fmt.Fprintln(r.out, formatUpdate(bump))
+	}
+	_, _ = fmt.Fprintf(r.out, "Bumped %s packages in %s\n\n", paint(len(bumps), color.FgHiCyan), path)
+}
+
+func (r Reporter) StorageProblems(problems []manager.StorageProblem, fix bool) {
+	fixed := 0
+	for _, problem := range problems {
+		name := problem.Path
+		if problem.Package != "" {
+			name = formatVersion(problem.Package, problem.Version)
+		}
+		messageColor := color.FgYellow
+		if problem.Notice {
+			messageColor = color.Faint
+		}
+		line := name + " " + paint(problem.Message, messageColor)
+		switch {
+		case problem.Fixed:
+			fixed++
+			line += " " + paint("fixed", color.FgGreen)
+		case problem.FixErr != nil:
+			line += " " + paint("fix failed: "+problem.FixErr.Error(), color.FgRed)
+		case fix && !problem.Fixable:
+			line += " " + paint("remove it manually", color.Faint)
+		}
+		_, _ = fmt.Fprintln(r.out, line)
+	}
+	if !fix {
+		_, _ = fmt.Fprintln(r.out, paint("Run hapm doctor --fix to repair the storage", color.Faint))
+		return
+	}
+	_, _ = fmt.Fprintf(r.out, "Done: fixed %s of %s problems\n", paint(fixed, color.FgHiCyan), paint(len(problems), color.FgHiCyan))
+}
+
+func (r Reporter) StorageHealthy() {
+	_, _ = fmt.Fprintln(r.out, "Storage is healthy")
+}
+
+func (r Reporter) AppDaemonExportHint(files []string) {
+	if len(files) == 0 {
+		return
+	}
+	heading := "AppDaemon app configuration was merged into appdaemon/apps/apps.yaml:"
+	_, _ = fmt.Fprintln(r.out, paint(heading, color.FgYellow))
+	prefix := paint("*", color.Faint)
+	for _, file := range files {
+		_, _ = fmt.Fprintf(r.out, "%s %s\n", prefix, file)
+	}
+}
+
 type Progress struct {
 	out      io.Writer
 	title    string
+	detail   func() string
 	running  bool
 	finished bool
 	disabled bool
@@ -204,6 +587,13 @@ func (p *Progress) Start(title string) {
 	go p.show()
 }
 
+// SetDetail sets function that returns text shown after the animation.
+func (p *Progress) SetDetail(detail func() string) {
+	p.mu.Lock()
+	p.detail = detail
+	p.mu.Unlock()
+}
+
 func (p *Progress) Stop() {
 	if p.disabled {
 		return
@@ -225,12 +615,14 @@ func (p *Progress) Stop() {
 func (p *Progress) show() {
 	states := [][2]int{{0, 1}, {2, 1}, {4, 1}}
 	prefix := "* " + p.title + " "
+	width := len(prefix) + len(progressSteps)
 	for {
 		p.mu.Lock()
 		running := p.running
+		detail := p.detail
 		p.mu.Unlock()
 		if !running {
-			blank := strings.Repeat(" ", len(prefix)+len(progressSteps))
+			blank := strings.Repeat(" ", width)
 			_, _ = fmt.Fprint(p.out, blank+"\r")
 			p.mu.Lock()
 			p.finished = true
@@ -254,11 +646,61 @@ func (p *Progress) show() {
 				}
 			}
 		}
+		if detail != nil {
+			if text := detail(); text != "" {
+				line += " " + text
+			}
+		}
+		if size := utf8.RuneCountInString(line); size < width {
+			line += strings.Repeat(" ", width-size)
+		} else {
+			width = size
+		}
 		_, _ = fmt.Fprint(p.out, line+"\r")
 		time.Sleep(70 * time.Millisecond)
 	}
 }
 
+// Downloads counts bytes received for every package.
+type Downloads struct {
+	mu       sync.Mutex
+	received map[string]int64
+	last     string
+}
+
+func NewDownloads() *Downloads {
+	return &Downloads{received: map[string]int64{}}
+}
+
+// Add records bytes received for the package.
+func (d *Downloads) Add(fullName string, received int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.received[fullName] += received
+	d.last = fullName
+}
+
+// String describes the package that received data last and the total
+// amount of received data.
+func (d *Downloads) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last == "" {
+		return ""
+	}
+	total := int64(0)
+	for _, received := range d.received {
+		total += received
+	}
+	return fmt.Sprintf(
+		"%s %s, %s of %d packages",
+		d.last,
+		formatSize(d.received[d.last]),
+		formatSize(total),
+		len(d.received),
+	)
+}
+
 func formatKind(kind string) string {
 	if kind == "" {
 		return paint(":", color.Faint)
@@ -287,6 +729,21 @@ func formatEntry(diff manager.PackageDiff, fullName bool) string {
 		prefix = "*"
 		textColor = color.FgYellow
 		versionStr = paint(diff.CurrentVersion, color.Faint) + " → " + diff.Version
+	case "configure":
+		prefix = "~"
+		textColor = color.FgCyan
+		versionStr = paint(versionStr, color.Faint)
+	case "reinstall":
+		prefix = "!"
+		textColor = color.FgMagenta
+		if diff.CurrentVersion != "" {
+			versionStr = paint(diff.CurrentVersion, color.Faint) + " → " + diff.Version
+		}
+		reason := "artifact is missing"
+		if diff.CurrentKind != "" {
+			reason = "moved from " + diff.CurrentKind
+		}
+		versionStr += " " + paint(reason, color.Faint)
 	default:
 		prefix = "-"
 		textColor = color.FgRed
@@ -300,9 +757,41 @@ func formatEntry(diff manager.PackageDiff, fullName bool) string {
 	return title + "@" + versionStr
 }
 
-func formatPackage(pkg hapkg.PackageDescription) string {
+func formatChange(change manager.ExportChange) string {
+	switch change.Operation {
+	case "add":
+		return paint("+ "+change.Path, color.FgGreen)
+	case "update":
+		return paint("* "+change.Path, color.FgYellow)
+	}
+	return paint("- "+change.Path, color.FgRed)
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	suffixes := []string{"KiB", "MiB", "GiB"}
+	suffix := ""
+	for _, next := range suffixes {
+		value /= unit
+		suffix = next
+		if value < unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+func formatPackage(pkg hapkg.PackageDescription, domains []string) string {
 	version := paint("@"+pkg.Version, color.Faint)
-	return "  " + pkg.FullName + version
+	line := "  " + pkg.FullName + version
+	if len(domains) > 0 {
+		line += " " + paint("("+strings.Join(domains, ", ")+")", color.Faint)
+	}
+	return line
 }
 
 func formatVersion(pkg string, version string) string {
@@ -311,6 +800,31 @@ func formatVersion(pkg string, version string) string {
 	return line
 }
 
+// releaseDetails returns the release name, if it differs from the tag,
+// and the publication date.
+func releaseDetails(release hapkg.Release) string {
+	parts := make([]string, 0, 2)
+	if name := strings.TrimSpace(release.Name); name != "" && name != release.Tag {
+		parts = append(parts, name)
+	}
+	if !release.PublishedAt.IsZero() {
+		parts = append(parts, "("+release.PublishedAt.Format(time.DateOnly)+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+func releaseBody(release hapkg.Release) string {
+	return strings.TrimSpace(strings.ReplaceAll(release.Body, "\r\n", "\n"))
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, hapkg.DigestPrefix)
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return digest
+}
+
 func paint(value any, attrs ...color.Attribute) string {
 	if len(attrs) == 0 {
 		return fmt.Sprint(value)
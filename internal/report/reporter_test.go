@@ -2,8 +2,10 @@ package report
 
 import (
 	"bytes"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mishamyrt/hapm/internal/manager"
 	"github.com/mishamyrt/hapm/internal/hapkg"
@@ -16,12 +18,19 @@ func TestReporterDiffAndSummary(t *testing.T) {
 		{PackageDescription: hapkg.PackageDescription{FullName: "foo/new", Kind: "integrations", Version: "v1.0.0"}, Operation: "add"},
 		{PackageDescription: hapkg.PackageDescription{FullName: "foo/old", Kind: "integrations", Version: "v2.0.0"}, Operation: "switch", CurrentVersion: "v1.0.0"},
 		{PackageDescription: hapkg.PackageDescription{FullName: "foo/drop", Kind: "integrations", Version: "v1.0.0"}, Operation: "delete"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/multi", Kind: "integrations", Version: "v1.0.0"}, Operation: "configure"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/card", Kind: "integrations", Version: "v1.0.0"}, Operation: "reinstall", CurrentKind: "plugins"},
+		{PackageDescription: hapkg.PackageDescription{FullName: "foo/lost", Kind: "integrations", Version: "v1.0.0"}, Operation: "reinstall"},
 	}
 	r.Diff(diffs, false, false)
 	r.Summary(diffs)
 
 	text := out.String()
-	for _, needle := range []string{"Integrations:", "+ new", "* old", "- drop", "Done:"} {
+	needles := []string{
+		"Integrations:", "+ new", "* old", "- drop", "~ multi", "! card", "moved from plugins",
+		"! lost", "artifact is missing", "reconfigured", "reinstalled 2", "Done:",
+	}
+	for _, needle := range needles {
 		if !strings.Contains(text, needle) {
 			t.Fatalf("missing %q in output: %s", needle, text)
 		}
@@ -41,3 +50,140 @@ func TestReporterWarnings(t *testing.T) {
 		t.Fatalf("missing wrong format warning")
 	}
 }
+
+func TestReporterPackagesDomains(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := New(out)
+	packages := []hapkg.PackageDescription{
+		{FullName: "foo/multi", Kind: "integrations", Version: "v1.0.0"},
+	}
+	r.Packages(packages, map[string][]string{"foo/multi": {"alpha", "beta"}})
+	if !strings.Contains(out.String(), "(alpha, beta)") {
+		t.Fatalf("missing domains in output: %s", out.String())
+	}
+}
+
+func TestReporterExportPlan(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := New(out)
+	r.ExportPlan([]manager.ExportChange{
+		{Path: "custom_components/a/__init__.py", Package: "foo/a", Operation: "add", Size: 2048},
+		{Path: "custom_components/b/__init__.py", Package: "foo/b", Operation: "update", Size: 10},
+		{Path: "configuration.yaml", Operation: "delete", Size: 5},
+	})
+	text := out.String()
+	for _, needle := range []string{"foo/a:", "foo/b:", "Other files:", "+ custom_components/a/__init__.py", "2.0 KiB", "- configuration.yaml", "Would add"} {
+		if !strings.Contains(text, needle) {
+			t.Fatalf("missing %q in output: %s", needle, text)
+		}
+	}
+	if strings.Index(text, "Other files:") < strings.Index(text, "foo/b:") {
+		t.Fatalf("unmanaged files must be listed last: %s", text)
+	}
+}
+
+func TestReporterManifestIssues(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := New(out)
+	r.ManifestIssues([]manager.PackageIssues{{
+		Package: "foo/demo",
+		Issues: []hapkg.ManifestIssue{
+			{Domain: "demo", Severity: hapkg.IssueError, Message: "required key version is missing"},
+			{Domain: "demo", Severity: hapkg.IssueWarning, Message: "required key name is missing"},
+		},
+	}})
+	text := out.String()
+	for _, needle := range []string{"foo/demo:", "error demo: required key version", "warning demo: required key name"} {
+		if !strings.Contains(text, needle) {
+			t.Fatalf("missing %q in output: %s", needle, text)
+		}
+	}
+}
+
+func TestDownloads(t *testing.T) {
+	downloads := NewDownloads()
+	if text := downloads.String(); text != "" {
+		t.Fatalf("expected empty description, got %q", text)
+	}
+	downloads.Add("foo/bar", 2048)
+	downloads.Add("foo/baz", 512)
+	downloads.Add("foo/bar", 1024)
+	if text := downloads.String(); text != "foo/bar 3.0 KiB, 3.5 KiB of 2 packages" {
+		t.Fatalf("unexpected description: %q", text)
+	}
+}
+
+func TestReporterPackageErrors(t *testing.T) {
+	out := &bytes.Buffer{}
+	New(out).PackageErrors("calculating changes", []manager.PackageError{
+		{FullName: "foo/bar", Err: errors.New("versions not found")},
+		{FullName: "foo/long-name", Err: errors.New("http status: 404")},
+	})
+	text := out.String()
+	for _, needle := range []string{
+		"Error while calculating changes:",
+		"foo/bar        versions not found",
+		"foo/long-name  http status: 404",
+	} {
+		if !strings.Contains(text, needle) {
+			t.Fatalf("missing %q in output: %s", needle, text)
+		}
+	}
+}
+
+func TestReporterHeldUpdates(t *testing.T) {
+	out := &bytes.Buffer{}
+	New(out).HeldUpdates([]manager.PackageDiff{{
+		PackageDescription: hapkg.PackageDescription{FullName: "foo/bar", Kind: "integrations", Version: "v2.0.0"},
+		CurrentVersion:     "v1.0.0",
+		Operation:          "switch",
+		Held:               "allows minor updates",
+	}})
+	text := out.String()
+	for _, needle := range []string{"Held back by package policies:", "foo/bar@v2.0.0 (v1.0.0) allows minor updates"} {
+		if !strings.Contains(text, needle) {
+			t.Fatalf("missing %q in output: %s", needle, text)
+		}
+	}
+}
+
+func TestReporterChangelog(t *testing.T) {
+	changelogs := []manager.PackageChangelog{
+		{
+			FullName:       "foo/bar",
+			CurrentVersion: "v1.1.0",
+			Version:        "v1.2.0",
+			Releases: []hapkg.Release{{
+				Tag:         "v1.2.0",
+				Name:        "Spring release",
+				Body:        "* Fixed things\r\n* Added more",
+				PublishedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			}},
+		},
+		{FullName: "foo/quiet", CurrentVersion: "v1.0.0", Version: "v2.0.0"},
+	}
+	out := &bytes.Buffer{}
+	New(out).ChangelogMarkdown(changelogs)
+	expected := `## foo/bar v1.1.0 → v1.2.0
+
+### v1.2.0 Spring release (2024-05-01)
+
+* Fixed things
+* Added more
+
+## foo/quiet v1.0.0 → v2.0.0
+
+_No release notes found._
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected markdown:\n%s", out.String())
+	}
+
+	out.Reset()
+	New(out).Changelog(changelogs)
+	for _, needle := range []string{"foo/bar v1.1.0 → v1.2.0", "v1.2.0", "    * Added more", "No release notes found"} {
+		if !strings.Contains(out.String(), needle) {
+			t.Fatalf("missing %q in output: %s", needle, out.String())
+		}
+	}
+}
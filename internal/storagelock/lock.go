@@ -0,0 +1,173 @@
+// Package storagelock implements an advisory lock that keeps several hapm
+// processes from changing the same storage at once.
+package storagelock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name is the name of the lock file in the storage directory.
+const Name = "_storage.lock"
+
+// pollInterval is how often a waiting process checks the lock.
+var pollInterval = 200 * time.Millisecond
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("lock is held by another process")
+
+// Holder describes the process that holds the lock.
+type Holder struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Options describe how to acquire the lock.
+type Options struct {
+	// Wait makes Acquire wait until the lock is released instead of
+	// failing immediately.
+	Wait bool
+	// Timeout limits waiting. Zero means no limit.
+	Timeout time.Duration
+}
+
+// LockedError is returned when the storage is locked by another process.
+type LockedError struct {
+	Path   string
+	Holder Holder
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf(
+		"storage is locked by process %d on %s since %s",
+		e.Holder.PID,
+		e.Holder.Host,
+		e.Holder.StartedAt.Local().Format(time.DateTime),
+	)
+}
+
+// Lock is an acquired storage lock.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// Acquire takes the lock of the storage directory, which is created if
+// it doesn't exist. The lock file is locked by the operating system while
+// the process holds it, so locks of dead processes are released without
+// any cleanup.
+func Acquire(dir string, opts Options) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, Name)
+	holder, err := current()
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+	for {
+		lock, err := tryAcquire(path, holder)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, err
+		}
+		if !opts.Wait || (!deadline.IsZero() && time.Now().After(deadline)) {
+			return nil, &LockedError{Path: path, Holder: readHolder(path)}
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Release removes the lock file and unlocks it.
+func (l *Lock) Release() error {
+	return l.release()
+}
+
+// tryAcquire locks the lock file without waiting. The previous holder may
+// remove the file right before it is locked, so locking is repeated until
+// the locked file is the one at the path.
+func tryAcquire(path string, holder Holder) (*Lock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(file); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		same, err := samePath(file, path)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		if !same {
+			_ = file.Close()
+			continue
+		}
+		if err := record(file, holder); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return &Lock{path: path, file: file}, nil
+	}
+}
+
+// samePath reports whether the opened file is still located at the path.
+func samePath(file *os.File, path string) (bool, error) {
+	opened, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	located, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(opened, located), nil
+}
+
+func current() (Holder, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return Holder{}, err
+	}
+	return Holder{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()}, nil
+}
+
+// record writes the holder to the locked file.
+func record(file *os.File, holder Holder) error {
+	content, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(append(content, '\n'), 0)
+	return err
+}
+
+// readHolder returns the holder recorded in the lock file. The holder may
+// be writing it right now, so unreadable content gives empty holder.
+func readHolder(path string) Holder {
+	var holder Holder
+	content, err := os.ReadFile(path)
+	if err == nil {
+		_ = json.Unmarshal(content, &holder)
+	}
+	return holder
+}
@@ -0,0 +1,25 @@
+//go:build !unix && !windows
+
+package storagelock
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile can't lock files on this platform, so the lock only records
+// the holder.
+func lockFile(*os.File) error {
+	return nil
+}
+
+func (l *Lock) release() error {
+	err := os.Remove(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
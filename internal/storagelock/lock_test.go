@@ -0,0 +1,141 @@
+package storagelock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireRelease(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "storage")
+	lock, err := Acquire(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire(dir, Options{})
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected locked error, got %v", err)
+	}
+	host, _ := os.Hostname()
+	if lockedErr.Holder.PID != os.Getpid() || lockedErr.Holder.Host != host || lockedErr.Holder.StartedAt.IsZero() {
+		t.Fatalf("unexpected holder: %+v", lockedErr.Holder)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = Acquire(dir, Options{})
+	if err != nil {
+		t.Fatalf("expected released lock to be acquired: %v", err)
+	}
+	_ = lock.Release()
+}
+
+func TestAcquireWait(t *testing.T) {
+	defaults := pollInterval
+	pollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		pollInterval = defaults
+	})
+	dir := t.TempDir()
+	lock, err := Acquire(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	_, err = Acquire(dir, Options{Wait: true, Timeout: 50 * time.Millisecond})
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) || time.Since(started) < 50*time.Millisecond {
+		t.Fatalf("expected locked error after timeout, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = lock.Release()
+	}()
+	next, err := Acquire(dir, Options{Wait: true})
+	if err != nil {
+		t.Fatalf("expected lock after release: %v", err)
+	}
+	_ = next.Release()
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	host, _ := os.Hostname()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeHolder(t, dir, Holder{PID: cmd.Process.Pid, Host: host, StartedAt: time.Now()})
+	lock, err := Acquire(dir, Options{})
+	if err != nil {
+		t.Fatalf("expected lock of dead process to be taken: %v", err)
+	}
+	_ = lock.Release()
+
+	if err := os.WriteFile(filepath.Join(dir, Name), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = Acquire(dir, Options{})
+	if err != nil {
+		t.Fatalf("expected broken lock to be taken: %v", err)
+	}
+	_ = lock.Release()
+}
+
+func TestAcquireExclusive(t *testing.T) {
+	defaults := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() {
+		pollInterval = defaults
+	})
+	dir := t.TempDir()
+	var holders atomic.Int32
+	var overlapped atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				lock, err := Acquire(dir, Options{Wait: true})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if holders.Add(1) > 1 {
+					overlapped.Store(true)
+				}
+				time.Sleep(100 * time.Microsecond)
+				holders.Add(-1)
+				if err := lock.Release(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if overlapped.Load() {
+		t.Fatal("lock was held by several holders at once")
+	}
+}
+
+func writeHolder(t *testing.T, dir string, holder Holder) {
+	t.Helper()
+	content, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, Name), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
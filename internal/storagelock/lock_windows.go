@@ -0,0 +1,43 @@
+//go:build windows
+
+package storagelock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset is the start of the locked byte range. Windows locks are
+// mandatory, so the range is placed past the holder record to keep it
+// readable by waiting processes.
+const lockOffset = 1 << 30
+
+// lockFile takes an exclusive lock of the file without waiting.
+func lockFile(file *os.File) error {
+	overlapped := &windows.Overlapped{Offset: lockOffset}
+	err := windows.LockFileEx(
+		windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0,
+		1,
+		0,
+		overlapped,
+	)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+// release unlocks the file and then removes it. An open file can't be
+// removed on Windows, so the file is left to a process that has just
+// opened it.
+func (l *Lock) release() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(l.path)
+	return nil
+}